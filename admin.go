@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"rest/auth"
+	"rest/db"
+	"rest/rbac"
+)
+
+const apiKeyUsage = `Usage:
+  server apikey create <name>
+  server apikey rotate <id>
+  server apikey revoke <id>
+  server apikey list`
+
+// Manages API keys from the command line, e.g. `server apikey create ci`
+func runAPIKeyCommand(database *db.Database, args []string) int {
+	store := auth.NewDBKeyStore(database)
+
+	if len(args) < 1 {
+		fmt.Println(apiKeyUsage)
+		return 2
+	}
+
+	switch {
+	case args[0] == "create" && len(args) == 2:
+		k, key, err := store.Create(args[1])
+		if errors.Is(err, auth.ErrDuplicateName) {
+			fmt.Fprintf(os.Stderr, "An API key named %q already exists, pick another name\n", args[1])
+			return 1
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to create API key:", err)
+			return 1
+		}
+		fmt.Printf("Created API key %s (%s), grant it roles as %s\n", k.ID, k.Name, auth.Actor(k))
+		fmt.Println("Key (shown only once):", key)
+	case args[0] == "rotate" && len(args) == 2:
+		k, key, err := store.Rotate(args[1])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to rotate API key:", err)
+			return 1
+		}
+		fmt.Printf("Rotated API key %s (%s)\n", k.ID, k.Name)
+		fmt.Println("Key (shown only once):", key)
+	case args[0] == "revoke" && len(args) == 2:
+		if err := store.Revoke(args[1]); err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to revoke API key:", err)
+			return 1
+		}
+		fmt.Println("Revoked API key", args[1])
+	case args[0] == "list" && len(args) == 1:
+		keys, err := store.List()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to list API keys:", err)
+			return 1
+		}
+		for _, k := range keys {
+			status := "active"
+			if k.RevokedAt != nil {
+				status = "revoked"
+			}
+			lastUsed := "never"
+			if k.LastUsedAt != nil {
+				lastUsed = k.LastUsedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%s  %-20s %s...  %-8s last used: %s\n", k.ID, k.Name, k.Prefix, status, lastUsed)
+		}
+	default:
+		fmt.Println(apiKeyUsage)
+		return 2
+	}
+
+	return 0
+}
+
+const roleUsage = `Usage:
+  server role grant <actor> <role>
+  server role revoke <actor> <role>
+
+Actors are named after how they authenticate, apikey:<key ID> or jwt:<subject>.
+Roles are viewer, editor and admin.`
+
+// Manages role assignments stored in the database
+func runRoleCommand(database *db.Database, args []string) int {
+	if len(args) != 3 || (args[0] != "grant" && args[0] != "revoke") {
+		fmt.Println(roleUsage)
+		return 2
+	}
+
+	role, err := rbac.ParseRole(args[2])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	source := rbac.NewDBSource(database)
+	if args[0] == "grant" {
+		if err := source.Grant(args[1], role); err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to grant role:", err)
+			return 1
+		}
+		fmt.Printf("Granted %s to %s\n", role, args[1])
+	} else {
+		if err := source.Revoke(args[1], role); err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to revoke role:", err)
+			return 1
+		}
+		fmt.Printf("Revoked %s from %s\n", role, args[1])
+	}
+
+	return 0
+}
@@ -0,0 +1,163 @@
+package alerts
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"rest/db"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Kinds of alert
+const (
+	LowStock  = "low_stock"
+	Recovered = "recovered"
+)
+
+// Alert is raised once when a product falls to its reorder threshold and
+// once more when it is restocked above it
+type Alert struct {
+	ID          string     `json:"id"`
+	ProductID   string     `json:"product_id"`
+	Kind        string     `json:"kind"`
+	Quantity    string     `json:"quantity"`
+	Threshold   *int       `json:"threshold"`
+	CreatedAt   time.Time  `json:"created_at"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+	Attempts    int        `json:"attempts"`
+}
+
+const alertColumns = "id::STRING, product_id, kind, quantity, threshold, created_at, delivered_at, attempts"
+
+func scanAlert(row pgx.Row) (Alert, error) {
+	var a Alert
+	err := row.Scan(&a.ID, &a.ProductID, &a.Kind, &a.Quantity, &a.Threshold, &a.CreatedAt, &a.DeliveredAt, &a.Attempts)
+	return a, err
+}
+
+// IsLow reports whether a quantity is at or below the reorder threshold,
+// quantities that db.Units does not count never are
+func IsLow(quantity string, threshold *int) bool {
+	if threshold == nil {
+		return false
+	}
+	n, ok := db.Units(quantity)
+	return ok && n <= *threshold
+}
+
+// The alert to raise given whether the product is low now and the kind of its
+// last alert, empty when nothing changed
+func transition(low bool, last string) string {
+	switch {
+	case low && last != LowStock:
+		return LowStock
+	case !low && last == LowStock:
+		return Recovered
+	}
+	return ""
+}
+
+// Check compares a product with its reorder threshold and records an alert if
+// it crossed it since the last one. It must run in the transaction that
+// changed the product, the alert is delivered after that commits.
+func Check(tx *db.Database, productID string) error {
+	var quantity string
+	var threshold *int
+	var last string
+	err := tx.QueryRow(
+		"SELECT p.quantity, p.reorder_threshold, COALESCE((SELECT kind FROM stock_alerts a WHERE a.product_id = p.id ORDER BY a.id DESC LIMIT 1), '') FROM products p WHERE p.id = $1",
+		productID,
+	).Scan(&quantity, &threshold, &last)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	kind := transition(IsLow(quantity, threshold), last)
+	if kind == "" {
+		return nil
+	}
+	return tx.ExecQuery("INSERT INTO stock_alerts (product_id, kind, quantity, threshold) VALUES ($1, $2, $3, $4)", productID, kind, quantity, threshold)
+}
+
+// MaxAttempts is how often delivery of an alert is tried before giving up
+const MaxAttempts = 10
+
+// Dispatcher delivers recorded alerts to every sink. An alert is marked
+// delivered once all sinks accepted it, so a sink may see it more than once.
+type Dispatcher struct {
+	database *db.Database
+	sinks    []Sink
+}
+
+func NewDispatcher(database *db.Database, sinks []Sink) *Dispatcher {
+	return &Dispatcher{database: database, sinks: sinks}
+}
+
+// Run delivers pending alerts every interval until ctx is done
+func (d *Dispatcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := d.Deliver(ctx); err != nil {
+			slog.Error("failed to deliver stock alerts", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Deliver sends the pending alerts, oldest first, returning how many were delivered
+func (d *Dispatcher) Deliver(ctx context.Context) (int, error) {
+	database := d.database.WithContext(ctx)
+
+	rows, err := database.Query("SELECT "+alertColumns+" FROM stock_alerts WHERE delivered_at IS NULL AND attempts < $1 ORDER BY id LIMIT 100", MaxAttempts)
+	if err != nil {
+		return 0, err
+	}
+	var pending []Alert
+	for rows.Next() {
+		a, err := scanAlert(rows)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		pending = append(pending, a)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	delivered := 0
+	for _, a := range pending {
+		var failed error
+		for _, sink := range d.sinks {
+			if err := sink.Send(ctx, a); err != nil {
+				slog.Warn("failed to send stock alert", "alert", a.ID, "sink", sink.Name(), "error", err)
+				failed = err
+			}
+		}
+
+		if failed != nil {
+			err = database.ExecQuery("UPDATE stock_alerts SET attempts = attempts + 1 WHERE id = $1", a.ID)
+		} else {
+			err = database.ExecQuery("UPDATE stock_alerts SET attempts = attempts + 1, delivered_at = now() WHERE id = $1", a.ID)
+			delivered++
+		}
+		if err != nil {
+			return delivered, err
+		}
+	}
+	return delivered, nil
+}
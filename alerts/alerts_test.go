@@ -0,0 +1,110 @@
+package alerts
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func threshold(n int) *int {
+	return &n
+}
+
+func TestIsLow(t *testing.T) {
+	assert.True(t, IsLow("5", threshold(5)))
+	assert.True(t, IsLow("0", threshold(5)))
+	assert.False(t, IsLow("6", threshold(5)))
+	assert.False(t, IsLow("5", nil))
+	assert.False(t, IsLow("QUANTITY", threshold(5)))
+	assert.False(t, IsLow("-1", threshold(5)))
+	assert.False(t, IsLow("99999999999999999999", threshold(5)))
+}
+
+func TestTransition(t *testing.T) {
+	testCases := []struct {
+		name     string
+		low      bool
+		last     string
+		expected string
+	}{
+		{"first time low", true, "", LowStock},
+		{"still low", true, LowStock, ""},
+		{"low again after recovering", true, Recovered, LowStock},
+		{"restocked", false, LowStock, Recovered},
+		{"never low", false, "", ""},
+		{"still recovered", false, Recovered, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, transition(tc.low, tc.last))
+		})
+	}
+}
+
+func TestWebhookSink(t *testing.T) {
+	var received Alert
+	status := http.StatusNoContent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	sink := NewWebhookSink(server.URL)
+	alert := Alert{ID: "1", ProductID: "3", Kind: LowStock, Quantity: "2", Threshold: threshold(5)}
+	require.NoError(t, sink.Send(context.Background(), alert))
+	assert.Equal(t, alert, received)
+
+	status = http.StatusBadGateway
+	assert.Error(t, sink.Send(context.Background(), alert))
+}
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "alerts.jsonl")
+	sink := &FileSink{Path: path}
+
+	require.NoError(t, sink.Send(context.Background(), Alert{ID: "1", ProductID: "3", Kind: LowStock}))
+	require.NoError(t, sink.Send(context.Background(), Alert{ID: "2", ProductID: "3", Kind: Recovered}))
+
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	var kinds []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var a Alert
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &a))
+		kinds = append(kinds, a.Kind)
+	}
+	assert.Equal(t, []string{LowStock, Recovered}, kinds)
+}
+
+func TestParseSinks(t *testing.T) {
+	sinks, err := ParseSinks("log, webhook,file", "http://localhost/hook", "/tmp/alerts.jsonl")
+	require.NoError(t, err)
+	var names []string
+	for _, s := range sinks {
+		names = append(names, s.Name())
+	}
+	assert.Equal(t, []string{"log", "webhook", "file"}, names)
+
+	sinks, err = ParseSinks("", "", "")
+	require.NoError(t, err)
+	assert.Empty(t, sinks)
+
+	for _, names := range []string{"webhook", "file", "email"} {
+		_, err := ParseSinks(names, "", "")
+		assert.Error(t, err, names)
+	}
+}
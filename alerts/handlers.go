@@ -0,0 +1,147 @@
+package alerts
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"rest/audit"
+	"rest/db"
+	"rest/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// LowStockProduct is a product at or below its reorder threshold
+type LowStockProduct struct {
+	ID               string `json:"id"`
+	Name             string `json:"name"`
+	Quantity         string `json:"quantity"`
+	ReorderThreshold int    `json:"reorder_threshold"`
+}
+
+// ListLowStock handles GET /products/low-stock, emptiest first
+func ListLowStock(database *db.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		database := database.WithContext(c.Request.Context())
+
+		limit, offset := utils.Pagination(c)
+		rows, err := database.Query(
+			"SELECT id, name, quantity, reorder_threshold FROM products WHERE deleted_at IS NULL AND units <= reorder_threshold ORDER BY units, id LIMIT $1 OFFSET $2",
+			limit, offset,
+		)
+		if err != nil {
+			utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		defer rows.Close()
+
+		products := []LowStockProduct{}
+		for rows.Next() {
+			var p LowStockProduct
+			if err := rows.Scan(&p.ID, &p.Name, &p.Quantity, &p.ReorderThreshold); err != nil {
+				utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+				return
+			}
+			products = append(products, p)
+		}
+
+		c.JSON(http.StatusOK, products)
+	}
+}
+
+// SetThreshold handles PUT /products/:id/threshold, a null threshold turns
+// alerts off for the product. changed runs in the transaction.
+func SetThreshold(database *db.Database, changed func(tx *db.Database, productID, operation string) error) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		database := database.WithContext(c.Request.Context())
+
+		id := c.Param("id")
+		var input struct {
+			ReorderThreshold *int `json:"reorder_threshold"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			utils.ErrorResponse(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		if input.ReorderThreshold != nil && *input.ReorderThreshold < 0 {
+			utils.ErrorResponse(c, http.StatusBadRequest, "reorder_threshold must not be negative")
+			return
+		}
+
+		var before *int
+		err := database.Transaction(func(tx *db.Database) error {
+			err := tx.QueryRow("SELECT reorder_threshold FROM products WHERE id = $1 AND deleted_at IS NULL FOR UPDATE", id).Scan(&before)
+			if err != nil {
+				return err
+			}
+			if err := tx.ExecQuery("UPDATE products SET reorder_threshold = $1 WHERE id = $2", input.ReorderThreshold, id); err != nil {
+				return err
+			}
+			if err := changed(tx, id, "threshold"); err != nil {
+				return err
+			}
+			return Check(tx, id)
+		})
+		if errors.Is(err, pgx.ErrNoRows) {
+			utils.ErrorResponse(c, http.StatusNotFound, "Product not found")
+			return
+		}
+		if err != nil {
+			utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		audit.Record(c, "product", id, gin.H{"reorder_threshold": before}, gin.H{"reorder_threshold": input.ReorderThreshold})
+		c.JSON(http.StatusOK, gin.H{"id": id, "reorder_threshold": input.ReorderThreshold})
+	}
+}
+
+// ListAlerts handles GET /alerts, newest first, filtered by product and kind
+func ListAlerts(database *db.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		database := database.WithContext(c.Request.Context())
+
+		var conditions []string
+		var values []any
+		for _, filter := range []struct{ param, condition string }{
+			{"product_id", "product_id = $%d"},
+			{"kind", "kind = $%d"},
+		} {
+			if value := c.Query(filter.param); value != "" {
+				values = append(values, value)
+				conditions = append(conditions, fmt.Sprintf(filter.condition, len(values)))
+			}
+		}
+		where := ""
+		if len(conditions) > 0 {
+			where = " WHERE " + strings.Join(conditions, " AND ")
+		}
+
+		limit, offset := utils.Pagination(c)
+		values = append(values, limit, offset)
+		rows, err := database.Query(
+			fmt.Sprintf("SELECT %s FROM stock_alerts%s ORDER BY id DESC LIMIT $%d OFFSET $%d", alertColumns, where, len(values)-1, len(values)),
+			values...,
+		)
+		if err != nil {
+			utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		defer rows.Close()
+
+		alerts := []Alert{}
+		for rows.Next() {
+			a, err := scanAlert(rows)
+			if err != nil {
+				utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+				return
+			}
+			alerts = append(alerts, a)
+		}
+
+		c.JSON(http.StatusOK, alerts)
+	}
+}
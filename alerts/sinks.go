@@ -0,0 +1,131 @@
+package alerts
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sink is somewhere alerts are delivered to
+type Sink interface {
+	Name() string
+	Send(ctx context.Context, a Alert) error
+}
+
+// LogSink writes alerts to the application log
+type LogSink struct {
+	Logger *slog.Logger
+}
+
+func (s LogSink) Name() string { return "log" }
+
+func (s LogSink) Send(ctx context.Context, a Alert) error {
+	logger := s.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	level := slog.LevelWarn
+	if a.Kind == Recovered {
+		level = slog.LevelInfo
+	}
+	logger.Log(ctx, level, "stock alert", "kind", a.Kind, "product_id", a.ProductID, "quantity", a.Quantity, "threshold", a.Threshold)
+	return nil
+}
+
+// WebhookSink posts every alert as JSON to a URL
+type WebhookSink struct {
+	URL    string
+	Client *http.Client
+}
+
+func NewWebhookSink(url string) *WebhookSink {
+	return &WebhookSink{URL: url, Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (s *WebhookSink) Name() string { return "webhook" }
+
+func (s *WebhookSink) Send(ctx context.Context, a Alert) error {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// FileSink appends every alert to a file as a line of JSON
+type FileSink struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+func (s *FileSink) Name() string { return "file" }
+
+func (s *FileSink) Send(ctx context.Context, a Alert) error {
+	line, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ParseSinks builds the sinks named in a comma separated list such as
+// "log,webhook", the webhook and file sinks need their URL and path
+func ParseSinks(names, webhookURL, filePath string) ([]Sink, error) {
+	var sinks []Sink
+	for _, name := range strings.Split(names, ",") {
+		switch strings.TrimSpace(name) {
+		case "":
+		case "log":
+			sinks = append(sinks, LogSink{})
+		case "webhook":
+			if webhookURL == "" {
+				return nil, fmt.Errorf("the webhook alert sink needs a URL")
+			}
+			sinks = append(sinks, NewWebhookSink(webhookURL))
+		case "file":
+			if filePath == "" {
+				return nil, fmt.Errorf("the file alert sink needs a path")
+			}
+			sinks = append(sinks, &FileSink{Path: filePath})
+		default:
+			return nil, fmt.Errorf("unknown alert sink %q, use log, webhook or file", name)
+		}
+	}
+	return sinks, nil
+}
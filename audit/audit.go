@@ -0,0 +1,188 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"time"
+
+	"rest/db"
+	"rest/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Authentication middleware stores the caller's identity under this key
+const ActorKey = "actor"
+
+const changeKey = "audit.change"
+
+// Entry is one row of the append-only audit log
+type Entry struct {
+	ID         string          `json:"id"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	Actor      string          `json:"actor"`
+	Method     string          `json:"method"`
+	Route      string          `json:"route"`
+	Status     int             `json:"status"`
+	RequestID  string          `json:"request_id"`
+	ClientIP   string          `json:"client_ip"`
+	Resource   string          `json:"resource,omitempty"`
+	ResourceID string          `json:"resource_id,omitempty"`
+	Before     json.RawMessage `json:"before,omitempty"`
+	After      json.RawMessage `json:"after,omitempty"`
+	Diff       json.RawMessage `json:"diff,omitempty"`
+}
+
+// Change is how a single field differs between two versions of a resource
+type Change struct {
+	From any `json:"from"`
+	To   any `json:"to"`
+}
+
+type change struct {
+	resource string
+	id       string
+	before   any
+	after    any
+}
+
+// Record lets a handler tell the audit middleware which resource it changed
+// and what it looked like before and after. Either state may be nil.
+func Record(c *gin.Context, resource, id string, before, after any) {
+	c.Set(changeKey, change{resource, id, before, after})
+}
+
+// Actor is the authenticated caller, or anonymous when authentication is off
+func Actor(c *gin.Context) string {
+	if actor := c.GetString(ActorKey); actor != "" {
+		return actor
+	}
+	return "anonymous"
+}
+
+// Diff compares the JSON representation of two values field by field
+func Diff(before, after any) (map[string]Change, error) {
+	from, err := toMap(before)
+	if err != nil {
+		return nil, err
+	}
+
+	to, err := toMap(after)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := map[string]Change{}
+	for field, value := range from {
+		if !reflect.DeepEqual(value, to[field]) {
+			diff[field] = Change{value, to[field]}
+		}
+	}
+	for field, value := range to {
+		if _, ok := from[field]; !ok {
+			diff[field] = Change{nil, value}
+		}
+	}
+
+	return diff, nil
+}
+
+func toMap(v any) (map[string]any, error) {
+	m := map[string]any{}
+	if v == nil {
+		return m, nil
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &m)
+	return m, err
+}
+
+func isMutation(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// Callers that failed to authenticate or hit the rate limit changed nothing,
+// and writing them down would let anyone fill the log
+func rejected(status int) bool {
+	return status == http.StatusUnauthorized || status == http.StatusTooManyRequests
+}
+
+// Middleware writes an audit entry for every POST, PUT, PATCH and DELETE
+// request, except the ones rejected before authentication passed
+func Middleware(database *db.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		if !isMutation(c.Request.Method) || rejected(c.Writer.Status()) {
+			return
+		}
+
+		entry := Entry{
+			Actor:     Actor(c),
+			Method:    c.Request.Method,
+			Route:     c.FullPath(),
+			Status:    c.Writer.Status(),
+			RequestID: logging.RequestIDFrom(c),
+			ClientIP:  c.ClientIP(),
+		}
+		if entry.Route == "" {
+			entry.Route = c.Request.URL.Path
+		}
+
+		if value, ok := c.Get(changeKey); ok {
+			ch := value.(change)
+			entry.Resource, entry.ResourceID = ch.resource, ch.id
+
+			diff, err := Diff(ch.before, ch.after)
+			if err != nil {
+				logging.FromGin(c).Warn("failed to diff audit entry", "error", err)
+			}
+			entry.Before = marshal(ch.before)
+			entry.After = marshal(ch.after)
+			entry.Diff = marshal(diff)
+		}
+
+		// the change is committed by now, so the entry is written even when the
+		// client went away
+		if err := insert(database.WithContext(context.WithoutCancel(c.Request.Context())), entry); err != nil {
+			logging.FromGin(c).Error("failed to write audit entry", "error", err)
+		}
+	}
+}
+
+func marshal(v any) json.RawMessage {
+	if v == nil || reflect.ValueOf(v).IsZero() {
+		return nil
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+func insert(database *db.Database, e Entry) error {
+	return database.ExecQuery(
+		"INSERT INTO audit_log (actor, method, route, status, request_id, client_ip, resource, resource_id, before, after, diff) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)",
+		e.Actor, e.Method, e.Route, e.Status, e.RequestID, e.ClientIP, e.Resource, e.ResourceID, nullJSON(e.Before), nullJSON(e.After), nullJSON(e.Diff),
+	)
+}
+
+func nullJSON(data json.RawMessage) any {
+	if data == nil {
+		return nil
+	}
+	return string(data)
+}
@@ -0,0 +1,214 @@
+package audit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"rest/db"
+	"rest/logging"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type product struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Price string `json:"price"`
+}
+
+func TestDiff(t *testing.T) {
+	testCases := []struct {
+		name     string
+		before   any
+		after    any
+		expected map[string]Change
+	}{
+		{
+			name:     "Update",
+			before:   product{"1", "old", "1.00"},
+			after:    product{"1", "new", "1.00"},
+			expected: map[string]Change{"name": {"old", "new"}},
+		},
+		{
+			name:     "Create",
+			before:   nil,
+			after:    product{"1", "new", "1.00"},
+			expected: map[string]Change{"id": {nil, "1"}, "name": {nil, "new"}, "price": {nil, "1.00"}},
+		},
+		{
+			name:     "Delete",
+			before:   product{"1", "old", "1.00"},
+			after:    nil,
+			expected: map[string]Change{"id": {"1", nil}, "name": {"old", nil}, "price": {"1.00", nil}},
+		},
+		{
+			name:     "No change",
+			before:   product{"1", "old", "1.00"},
+			after:    product{"1", "old", "1.00"},
+			expected: map[string]Change{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			diff, err := Diff(tc.before, tc.after)
+
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, diff)
+		})
+	}
+}
+
+// fakeLog records the statements sent to it and fails like pgx does once the
+// context of a statement is cancelled
+type fakeLog struct {
+	db.Conn
+	sql  []string
+	args [][]any
+}
+
+func (l *fakeLog) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	if err := ctx.Err(); err != nil {
+		return pgconn.CommandTag{}, err
+	}
+	l.sql, l.args = append(l.sql, sql), append(l.args, args)
+	return pgconn.NewCommandTag("INSERT 0 1"), nil
+}
+
+func (l *fakeLog) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	l.sql, l.args = append(l.sql, sql), append(l.args, args)
+	return &fakeRows{}, nil
+}
+
+type fakeRows struct{ pgx.Rows }
+
+func (r *fakeRows) Next() bool { return false }
+func (r *fakeRows) Close()     {}
+func (r *fakeRows) Err() error { return nil }
+
+// Serves every method on /products/:id with the audit middleware in front,
+// answering with status and recording a change of product 1
+func auditedRouter(log *fakeLog, status int) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	database := db.NewDatabase("", "", "", "", "test", log)
+	r := gin.New()
+	r.Use(logging.RequestID(), func(c *gin.Context) { c.Set(ActorKey, "apikey:k1") }, Middleware(&database))
+	r.Any("/products/:id", func(c *gin.Context) {
+		Record(c, "product", "1", product{"1", "old", "1.00"}, product{"1", "new", "1.00"})
+		c.Status(status)
+	})
+	return r
+}
+
+func TestMiddleware(t *testing.T) {
+	testCases := []struct {
+		method  string
+		status  int
+		written bool
+	}{
+		{http.MethodPost, http.StatusCreated, true},
+		{http.MethodPut, http.StatusOK, true},
+		{http.MethodPatch, http.StatusOK, true},
+		{http.MethodDelete, http.StatusNoContent, true},
+		{http.MethodPut, http.StatusNotFound, true},
+		{http.MethodGet, http.StatusOK, false},
+		{http.MethodPut, http.StatusUnauthorized, false},
+		{http.MethodPost, http.StatusTooManyRequests, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.method+" "+http.StatusText(tc.status), func(t *testing.T) {
+			log := &fakeLog{}
+			req := httptest.NewRequest(tc.method, "/products/1", nil)
+			req.Header.Set(logging.Header, "req-1")
+			req.RemoteAddr = "192.0.2.7:1234"
+			auditedRouter(log, tc.status).ServeHTTP(httptest.NewRecorder(), req)
+
+			if !tc.written {
+				assert.Empty(t, log.sql)
+				return
+			}
+			require.Len(t, log.sql, 1)
+			assert.True(t, strings.HasPrefix(log.sql[0], "INSERT INTO audit_log"))
+			args := log.args[0]
+			assert.Equal(t, []any{"apikey:k1", tc.method, "/products/:id", tc.status, "req-1", "192.0.2.7", "product", "1"}, args[:8])
+			assert.JSONEq(t, `{"name": {"from": "old", "to": "new"}}`, args[10].(string))
+		})
+	}
+}
+
+func TestMiddlewareAfterDisconnect(t *testing.T) {
+	log := &fakeLog{}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	req := httptest.NewRequest(http.MethodDelete, "/products/1", nil).WithContext(ctx)
+	auditedRouter(log, http.StatusNoContent).ServeHTTP(httptest.NewRecorder(), req)
+	assert.Len(t, log.sql, 1, "the entry is written although the client went away")
+}
+
+func TestList(t *testing.T) {
+	from := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(24 * time.Hour)
+
+	testCases := []struct {
+		name          string
+		query         string
+		expectedWhere string
+		expectedArgs  []any
+	}{
+		{
+			name:         "No filter",
+			query:        "",
+			expectedArgs: []any{10, 0},
+		},
+		{
+			name:          "Actor and resource",
+			query:         "actor=apikey:k1&resource=product&resource_id=1&page=2&limit=5",
+			expectedWhere: " WHERE actor = $1 AND resource = $2 AND resource_id = $3 ORDER BY",
+			expectedArgs:  []any{"apikey:k1", "product", "1", 5, 5},
+		},
+		{
+			name:          "Time range",
+			query:         "from=" + from.Format(time.RFC3339) + "&to=" + to.Format(time.RFC3339),
+			expectedWhere: " WHERE occurred_at >= $1 AND occurred_at < $2 ORDER BY",
+			expectedArgs:  []any{from, to, 10, 0},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			log := &fakeLog{}
+			database := db.NewDatabase("", "", "", "", "test", log)
+			r := gin.New()
+			r.GET("/audit", List(&database))
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/audit?"+tc.query, nil))
+			require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+			assert.JSONEq(t, "[]", w.Body.String())
+
+			require.Len(t, log.sql, 1)
+			if tc.expectedWhere == "" {
+				assert.NotContains(t, log.sql[0], "WHERE")
+			} else {
+				assert.Contains(t, log.sql[0], tc.expectedWhere)
+			}
+			assert.Equal(t, tc.expectedArgs, log.args[0])
+		})
+	}
+
+	w := httptest.NewRecorder()
+	r := gin.New()
+	r.GET("/audit", List(&db.Database{Conn: &fakeLog{}}))
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/audit?from=yesterday", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
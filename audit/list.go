@@ -0,0 +1,77 @@
+package audit
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"rest/db"
+	"rest/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// List handles GET /audit, filtered by actor, resource, resource_id and an
+// RFC 3339 from/to time range. Entries are returned newest first.
+func List(database *db.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		database := database.WithContext(c.Request.Context())
+
+		var conditions []string
+		var values []any
+
+		for _, filter := range []string{"actor", "resource", "resource_id"} {
+			if value := c.Query(filter); value != "" {
+				values = append(values, value)
+				conditions = append(conditions, fmt.Sprintf("%s = $%d", filter, len(values)))
+			}
+		}
+
+		for _, bound := range []struct{ param, op string }{{"from", ">="}, {"to", "<"}} {
+			value := c.Query(bound.param)
+			if value == "" {
+				continue
+			}
+
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				utils.ErrorResponse(c, http.StatusBadRequest, bound.param+" must be an RFC 3339 timestamp")
+				return
+			}
+			values = append(values, t)
+			conditions = append(conditions, fmt.Sprintf("occurred_at %s $%d", bound.op, len(values)))
+		}
+
+		where := ""
+		if len(conditions) > 0 {
+			where = " WHERE " + strings.Join(conditions, " AND ")
+		}
+
+		limit, offset := utils.Pagination(c)
+		values = append(values, limit, offset)
+
+		rows, err := database.Query(
+			fmt.Sprintf("SELECT id::STRING, occurred_at, actor, method, route, status, request_id, client_ip, resource, resource_id, before, after, diff FROM audit_log%s ORDER BY occurred_at DESC, id LIMIT $%d OFFSET $%d", where, len(values)-1, len(values)),
+			values...,
+		)
+		if err != nil {
+			utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		defer rows.Close()
+
+		entries := []Entry{}
+		for rows.Next() {
+			var e Entry
+			err := rows.Scan(&e.ID, &e.OccurredAt, &e.Actor, &e.Method, &e.Route, &e.Status, &e.RequestID, &e.ClientIP, &e.Resource, &e.ResourceID, &e.Before, &e.After, &e.Diff)
+			if err != nil {
+				utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+				return
+			}
+			entries = append(entries, e)
+		}
+
+		c.JSON(http.StatusOK, entries)
+	}
+}
@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"rest/db"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const keyPrefix = "rk_"
+
+var ErrInvalidKey = errors.New("invalid API key")
+
+// Returned when creating a key with the name of an existing one, revoked
+// keys included. Revoking keeps a key in apikey list, where operators pick
+// keys out by name, so a name must not be shared with a revoked key.
+var ErrDuplicateName = errors.New("an API key with this name already exists")
+
+// APIKey is the stored metadata of a key, the key itself is only known to its owner
+type APIKey struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+type KeyStore interface {
+	// Authenticate returns the key matching the plaintext key, or ErrInvalidKey
+	Authenticate(key string) (APIKey, error)
+}
+
+// Generates a new random key
+func GenerateKey() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return keyPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Keys are random and long, so a plain SHA-256 is enough to store them safely
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// DBKeyStore keeps API keys in the api_keys table
+type DBKeyStore struct {
+	db *db.Database
+}
+
+func NewDBKeyStore(db *db.Database) *DBKeyStore {
+	return &DBKeyStore{db}
+}
+
+const keyColumns = "id::STRING, name, prefix, created_at, last_used_at, revoked_at"
+
+func scanKey(row pgx.Row) (APIKey, error) {
+	var k APIKey
+	err := row.Scan(&k.ID, &k.Name, &k.Prefix, &k.CreatedAt, &k.LastUsedAt, &k.RevokedAt)
+	return k, err
+}
+
+// Inserts a key, names are unique
+func (s *DBKeyStore) insert(name, prefix, hash string) (APIKey, error) {
+	k, err := scanKey(s.db.QueryRow(
+		"INSERT INTO api_keys (name, prefix, hash) VALUES ($1, $2, $3) RETURNING "+keyColumns,
+		name, prefix, hash,
+	))
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "api_keys_name_key" {
+		return k, ErrDuplicateName
+	}
+	return k, err
+}
+
+// Creates a key and returns it along with the plaintext, which is not stored
+func (s *DBKeyStore) Create(name string) (APIKey, string, error) {
+	key, err := GenerateKey()
+	if err != nil {
+		return APIKey{}, "", err
+	}
+
+	k, err := s.insert(name, key[:len(keyPrefix)+6], HashKey(key))
+	return k, key, err
+}
+
+// Stores a key chosen by the operator, used to bootstrap access on startup
+func (s *DBKeyStore) Import(name, key string) (APIKey, error) {
+	prefix := key
+	if len(prefix) > len(keyPrefix)+6 {
+		prefix = prefix[:len(keyPrefix)+6]
+	}
+
+	return s.insert(name, prefix, HashKey(key))
+}
+
+// Replaces the secret of a key, the old one stops working immediately
+func (s *DBKeyStore) Rotate(id string) (APIKey, string, error) {
+	key, err := GenerateKey()
+	if err != nil {
+		return APIKey{}, "", err
+	}
+
+	k, err := scanKey(s.db.QueryRow(
+		"UPDATE api_keys SET prefix = $1, hash = $2 WHERE id = $3 AND revoked_at IS NULL RETURNING "+keyColumns,
+		key[:len(keyPrefix)+6], HashKey(key), id,
+	))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return k, "", ErrInvalidKey
+	}
+	return k, key, err
+}
+
+func (s *DBKeyStore) Revoke(id string) error {
+	var revoked string
+	err := s.db.QueryRow("UPDATE api_keys SET revoked_at = now() WHERE id = $1 AND revoked_at IS NULL RETURNING id", id).Scan(&revoked)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrInvalidKey
+	}
+	return err
+}
+
+func (s *DBKeyStore) List() ([]APIKey, error) {
+	rows, err := s.db.Query("SELECT " + keyColumns + " FROM api_keys ORDER BY created_at")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []APIKey
+	for rows.Next() {
+		k, err := scanKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+func (s *DBKeyStore) Authenticate(key string) (APIKey, error) {
+	k, err := scanKey(s.db.QueryRow("SELECT "+keyColumns+" FROM api_keys WHERE hash = $1 AND revoked_at IS NULL", HashKey(key)))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return k, ErrInvalidKey
+	}
+	if err != nil {
+		return k, err
+	}
+
+	// Only touch the row once a minute to keep writes off the hot path
+	err = s.db.ExecQuery("UPDATE api_keys SET last_used_at = now() WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < now() - INTERVAL '1 minute')", k.ID)
+	return k, err
+}
@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+
+	"rest/audit"
+	"rest/problem"
+
+	"github.com/gin-gonic/gin"
+)
+
+const Header = "X-API-Key"
+
+// APIKeyMiddleware rejects requests that don't carry a valid, unrevoked API key
+// in the X-API-Key header. The caller is apikey:<key ID>, which roles are
+// granted to and the audit log records, the name only labels the key.
+func APIKeyMiddleware(store KeyStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(Header)
+		if key == "" {
+			unauthorized(c, "missing-credentials", "An API key must be provided in the X-API-Key header")
+			return
+		}
+
+		k, err := store.Authenticate(key)
+		if errors.Is(err, ErrInvalidKey) {
+			unauthorized(c, "invalid-credentials", "The API key is invalid or has been revoked")
+			return
+		}
+		if err != nil {
+			problem.Abort(c, http.StatusInternalServerError, "authentication-error", err.Error())
+			return
+		}
+
+		c.Set(audit.ActorKey, Actor(k))
+		c.Next()
+	}
+}
+
+// Actor is how a key is named in role assignments and the audit log
+func Actor(k APIKey) string {
+	return "apikey:" + k.ID
+}
+
+func unauthorized(c *gin.Context, typ, detail string) {
+	c.Header("WWW-Authenticate", `ApiKey header="`+Header+`"`)
+	problem.Abort(c, http.StatusUnauthorized, typ, detail)
+}
@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"rest/audit"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+type memoryKeyStore map[string]APIKey
+
+func (s memoryKeyStore) Authenticate(key string) (APIKey, error) {
+	k, ok := s[HashKey(key)]
+	if !ok {
+		return k, ErrInvalidKey
+	}
+	return k, nil
+}
+
+func TestAPIKeyMiddleware(t *testing.T) {
+	key, err := GenerateKey()
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(key, keyPrefix))
+
+	store := memoryKeyStore{HashKey(key): {ID: "1", Name: "ci"}}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(APIKeyMiddleware(store))
+	r.GET("/products", func(c *gin.Context) {
+		c.String(http.StatusOK, c.GetString(audit.ActorKey))
+	})
+
+	testCases := []struct {
+		name           string
+		key            string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{name: "Valid key", key: key, expectedStatus: http.StatusOK, expectedBody: "apikey:1"},
+		{name: "Missing key", key: "", expectedStatus: http.StatusUnauthorized, expectedBody: "missing-credentials"},
+		{name: "Invalid key", key: "rk_wrong", expectedStatus: http.StatusUnauthorized, expectedBody: "invalid-credentials"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/products", nil)
+			if tc.key != "" {
+				req.Header.Set(Header, tc.key)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedStatus, w.Code)
+			assert.Contains(t, w.Body.String(), tc.expectedBody)
+			if tc.expectedStatus == http.StatusUnauthorized {
+				assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
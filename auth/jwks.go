@@ -0,0 +1,227 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+var ErrUnknownKey = errors.New("no matching signing key")
+
+// Returned when a token names an algorithm its key is not meant for
+var ErrWrongAlgorithm = errors.New("signing key does not allow this algorithm")
+
+// JWK is a single JSON Web Key as described in RFC 7517
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+
+	// Symmetric
+	K string `json:"k"`
+}
+
+// Converts the JWK into the key type jwt-go expects for its algorithm
+func (k JWK) PublicKey() (any, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	case "oct":
+		return base64.RawURLEncoding.DecodeString(k.K)
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// A key of the set and the algorithm it is restricted to, if any
+type setKey struct {
+	key any
+	alg string
+}
+
+// JWKS loads a key set from a file or an http(s) URL and caches it for ttl.
+// An unknown kid triggers a refresh, at most once every minRefresh, which is
+// also how long a failed fetch is not retried.
+type JWKS struct {
+	source     string
+	ttl        time.Duration
+	minRefresh time.Duration
+	client     *http.Client
+	fetches    singleflight.Group
+
+	mu          sync.Mutex
+	keys        map[string]setKey
+	err         error // of the last fetch
+	fetchedAt   time.Time
+	attemptedAt time.Time
+}
+
+func NewJWKS(source string, ttl time.Duration) *JWKS {
+	return &JWKS{
+		source:     source,
+		ttl:        ttl,
+		minRefresh: 30 * time.Second,
+		client:     &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Key returns the key with the given kid, if it may be used with alg. Tokens
+// without a kid are accepted when the set holds a single key.
+//
+// The set is fetched outside the lock, once however many requests need it.
+// Requests whose key is cached keep using the stale set meanwhile, the
+// others wait for the fetch.
+func (s *JWKS) Key(kid, alg string) (any, error) {
+	s.mu.Lock()
+	keys, err := s.keys, s.err
+	refresh := time.Since(s.attemptedAt) > s.minRefresh &&
+		(keys == nil || time.Since(s.fetchedAt) > s.ttl || !has(keys, kid))
+	s.mu.Unlock()
+
+	if refresh {
+		fetched := s.fetches.DoChan("", func() (any, error) { return nil, s.refresh() })
+		if !has(keys, kid) {
+			<-fetched
+			s.mu.Lock()
+			keys, err = s.keys, s.err
+			s.mu.Unlock()
+		}
+	}
+
+	if keys == nil && err != nil {
+		return nil, err
+	}
+	k, ok := find(keys, kid)
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	if k.alg != "" && k.alg != alg {
+		return nil, fmt.Errorf("%w: key %q is for %s, not %s", ErrWrongAlgorithm, kid, k.alg, alg)
+	}
+	return k.key, nil
+}
+
+func find(keys map[string]setKey, kid string) (setKey, bool) {
+	if kid == "" && len(keys) == 1 {
+		for _, k := range keys {
+			return k, true
+		}
+	}
+	k, ok := keys[kid]
+	return k, ok
+}
+
+func has(keys map[string]setKey, kid string) bool {
+	_, ok := find(keys, kid)
+	return ok
+}
+
+// Fetches the set and replaces the cached one, which is kept when it fails
+func (s *JWKS) refresh() error {
+	keys, err := s.fetch()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attemptedAt = time.Now()
+	s.err = err
+	if err == nil {
+		s.keys = keys
+		s.fetchedAt = s.attemptedAt
+	}
+	return err
+}
+
+func (s *JWKS) fetch() (map[string]setKey, error) {
+	data, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+
+	var set struct {
+		Keys []JWK `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]setKey{}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.PublicKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", jwk.Kid, err)
+		}
+		keys[jwk.Kid] = setKey{key, jwk.Alg}
+	}
+	return keys, nil
+}
+
+func (s *JWKS) read() ([]byte, error) {
+	if !strings.HasPrefix(s.source, "http://") && !strings.HasPrefix(s.source, "https://") {
+		return os.ReadFile(s.source)
+	}
+
+	resp, err := s.client.Get(s.source)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching JWKS failed with status: %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
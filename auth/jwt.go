@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"rest/audit"
+	"rest/problem"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Verified token claims are stored on the gin context under this key
+const ClaimsKey = "auth.claims"
+
+// Returned by Verify for tokens that do not say who they were issued to
+var ErrMissingSubject = errors.New("token has no sub claim")
+
+// Returned by NewJWTVerifier without an issuer or audience to check. Any
+// token the keys signed would be accepted, including ones meant for other
+// services.
+var ErrMissingIssuerOrAudience = errors.New("JWT issuer and audience are required")
+
+type KeySet interface {
+	// Key returns the key with the given kid, which the token claims was
+	// used with alg
+	Key(kid, alg string) (any, error)
+}
+
+type JWTConfig struct {
+	Issuer    string
+	Audience  string
+	ClockSkew time.Duration
+	Keys      KeySet
+}
+
+type JWTVerifier struct {
+	config JWTConfig
+	parser *jwt.Parser
+}
+
+func NewJWTVerifier(config JWTConfig) (*JWTVerifier, error) {
+	if config.Issuer == "" || config.Audience == "" {
+		return nil, ErrMissingIssuerOrAudience
+	}
+
+	parser := jwt.NewParser(
+		jwt.WithValidMethods([]string{"HS256", "RS256", "ES256"}),
+		jwt.WithLeeway(config.ClockSkew),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuer(config.Issuer),
+		jwt.WithAudience(config.Audience),
+	)
+	return &JWTVerifier{config, parser}, nil
+}
+
+// Verify checks the signature and the registered claims of a token, which
+// must have a subject to become the actor
+func (v *JWTVerifier) Verify(token string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := v.parser.ParseWithClaims(token, claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		return v.config.Keys.Key(kid, t.Method.Alg())
+	})
+	if err != nil {
+		return claims, err
+	}
+
+	if subject, _ := claims.GetSubject(); subject == "" {
+		return claims, ErrMissingSubject
+	}
+	return claims, nil
+}
+
+// JWTMiddleware rejects requests without a valid Authorization: Bearer token
+// and puts the token claims on the context for the handlers
+func JWTMiddleware(v *JWTVerifier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := c.GetHeader("Authorization")
+		token, found := strings.CutPrefix(header, "Bearer ")
+		if !found || token == "" {
+			c.Header("WWW-Authenticate", "Bearer")
+			problem.Abort(c, http.StatusUnauthorized, "missing-credentials", "A bearer token must be provided in the Authorization header")
+			return
+		}
+
+		claims, err := v.Verify(token)
+		if err != nil {
+			detail := "The bearer token is invalid"
+			if errors.Is(err, jwt.ErrTokenExpired) {
+				detail = "The bearer token has expired"
+			}
+			if errors.Is(err, ErrMissingSubject) {
+				detail = "The bearer token has no subject"
+			}
+			c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			problem.Abort(c, http.StatusUnauthorized, "invalid-credentials", detail)
+			return
+		}
+
+		subject, _ := claims.GetSubject()
+		c.Set(ClaimsKey, claims)
+		c.Set(audit.ActorKey, "jwt:"+subject)
+		c.Next()
+	}
+}
+
+// Claims returns the verified claims of the request, if any
+func Claims(c *gin.Context) (jwt.MapClaims, bool) {
+	value, ok := c.Get(ClaimsKey)
+	if !ok {
+		return nil, false
+	}
+	claims, ok := value.(jwt.MapClaims)
+	return claims, ok
+}
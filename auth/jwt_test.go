@@ -0,0 +1,223 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"rest/audit"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testKeys struct {
+	rsa  *rsa.PrivateKey
+	ec   *ecdsa.PrivateKey
+	hmac []byte
+}
+
+func newTestKeys(t *testing.T) testKeys {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	return testKeys{rsaKey, ecKey, []byte("0123456789abcdef0123456789abcdef")}
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (k testKeys) jwks() []byte {
+	set := map[string][]JWK{"keys": {
+		{Kty: "RSA", Kid: "rsa", Use: "sig", N: encode(k.rsa.N.Bytes()), E: encode(big.NewInt(int64(k.rsa.E)).Bytes())},
+		{Kty: "EC", Kid: "ec", Use: "sig", Crv: "P-256", X: encode(k.ec.X.Bytes()), Y: encode(k.ec.Y.Bytes())},
+		{Kty: "oct", Kid: "hmac", K: encode(k.hmac)},
+	}}
+	data, _ := json.Marshal(set)
+	return data
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key any, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	require.NoError(t, err)
+	return signed
+}
+
+func validClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"sub": "alice",
+		"iss": "https://issuer.test",
+		"aud": "products",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+func with(claims jwt.MapClaims, key string, value any) jwt.MapClaims {
+	copied := jwt.MapClaims{}
+	for k, v := range claims {
+		copied[k] = v
+	}
+	copied[key] = value
+	return copied
+}
+
+func TestJWTMiddleware(t *testing.T) {
+	keys := newTestKeys(t)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(path, keys.jwks(), 0o600))
+
+	verifier, err := NewJWTVerifier(JWTConfig{
+		Issuer:    "https://issuer.test",
+		Audience:  "products",
+		ClockSkew: time.Minute,
+		Keys:      NewJWKS(path, time.Hour),
+	})
+	require.NoError(t, err)
+
+	_, err = NewJWTVerifier(JWTConfig{Issuer: "https://issuer.test", Keys: NewJWKS(path, time.Hour)})
+	assert.ErrorIs(t, err, ErrMissingIssuerOrAudience)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(JWTMiddleware(verifier))
+	r.GET("/products", func(c *gin.Context) {
+		claims, _ := Claims(c)
+		c.JSON(http.StatusOK, gin.H{"actor": c.GetString(audit.ActorKey), "sub": claims["sub"]})
+	})
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name           string
+		token          string
+		expectedStatus int
+	}{
+		{name: "RS256", token: sign(t, jwt.SigningMethodRS256, "rsa", keys.rsa, validClaims()), expectedStatus: http.StatusOK},
+		{name: "ES256", token: sign(t, jwt.SigningMethodES256, "ec", keys.ec, validClaims()), expectedStatus: http.StatusOK},
+		{name: "HS256", token: sign(t, jwt.SigningMethodHS256, "hmac", keys.hmac, validClaims()), expectedStatus: http.StatusOK},
+		{name: "Expired within skew", token: sign(t, jwt.SigningMethodRS256, "rsa", keys.rsa, with(validClaims(), "exp", time.Now().Add(-30*time.Second).Unix())), expectedStatus: http.StatusOK},
+		{name: "Expired", token: sign(t, jwt.SigningMethodRS256, "rsa", keys.rsa, with(validClaims(), "exp", time.Now().Add(-time.Hour).Unix())), expectedStatus: http.StatusUnauthorized},
+		{name: "Not yet valid", token: sign(t, jwt.SigningMethodRS256, "rsa", keys.rsa, with(validClaims(), "nbf", time.Now().Add(time.Hour).Unix())), expectedStatus: http.StatusUnauthorized},
+		{name: "Wrong issuer", token: sign(t, jwt.SigningMethodRS256, "rsa", keys.rsa, with(validClaims(), "iss", "https://evil.test")), expectedStatus: http.StatusUnauthorized},
+		{name: "Wrong audience", token: sign(t, jwt.SigningMethodRS256, "rsa", keys.rsa, with(validClaims(), "aud", "orders")), expectedStatus: http.StatusUnauthorized},
+		{name: "Missing issuer", token: sign(t, jwt.SigningMethodRS256, "rsa", keys.rsa, with(validClaims(), "iss", nil)), expectedStatus: http.StatusUnauthorized},
+		{name: "Missing audience", token: sign(t, jwt.SigningMethodRS256, "rsa", keys.rsa, with(validClaims(), "aud", nil)), expectedStatus: http.StatusUnauthorized},
+		{name: "Missing expiry", token: sign(t, jwt.SigningMethodRS256, "rsa", keys.rsa, with(validClaims(), "exp", nil)), expectedStatus: http.StatusUnauthorized},
+		{name: "Unknown key", token: sign(t, jwt.SigningMethodRS256, "other", otherKey, validClaims()), expectedStatus: http.StatusUnauthorized},
+		{name: "Wrong signature", token: sign(t, jwt.SigningMethodRS256, "rsa", otherKey, validClaims()), expectedStatus: http.StatusUnauthorized},
+		{name: "Missing subject", token: sign(t, jwt.SigningMethodRS256, "rsa", keys.rsa, with(validClaims(), "sub", nil)), expectedStatus: http.StatusUnauthorized},
+		{name: "Empty subject", token: sign(t, jwt.SigningMethodRS256, "rsa", keys.rsa, with(validClaims(), "sub", "")), expectedStatus: http.StatusUnauthorized},
+		{name: "Unsigned", token: sign(t, jwt.SigningMethodNone, "rsa", jwt.UnsafeAllowNoneSignatureType, validClaims()), expectedStatus: http.StatusUnauthorized},
+		{name: "Missing token", token: "", expectedStatus: http.StatusUnauthorized},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/products", nil)
+			if tc.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedStatus, w.Code)
+			if tc.expectedStatus == http.StatusOK {
+				assert.JSONEq(t, `{"actor":"jwt:alice","sub":"alice"}`, w.Body.String())
+			} else {
+				assert.NotEmpty(t, w.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+func TestJWKSFromURLIsCached(t *testing.T) {
+	keys := newTestKeys(t)
+	var fetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Write(keys.jwks())
+	}))
+	defer server.Close()
+
+	jwks := NewJWKS(server.URL, time.Hour)
+
+	for i := 0; i < 3; i++ {
+		_, err := jwks.Key("rsa", "RS256")
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, int32(1), fetches.Load())
+
+	// An unknown kid only refreshes once the minimum interval has passed
+	_, err := jwks.Key("missing", "RS256")
+	assert.ErrorIs(t, err, ErrUnknownKey)
+	assert.Equal(t, int32(1), fetches.Load())
+}
+
+func TestJWKSEnforcesAlgorithm(t *testing.T) {
+	keys := newTestKeys(t)
+	set := map[string][]JWK{"keys": {
+		{Kty: "RSA", Kid: "rsa", Alg: "RS256", N: encode(keys.rsa.N.Bytes()), E: encode(big.NewInt(int64(keys.rsa.E)).Bytes())},
+		{Kty: "oct", Kid: "hmac", K: encode(keys.hmac)},
+	}}
+	data, err := json.Marshal(set)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+	jwks := NewJWKS(path, time.Hour)
+
+	_, err = jwks.Key("rsa", "RS256")
+	assert.NoError(t, err)
+	_, err = jwks.Key("rsa", "RS512")
+	assert.ErrorIs(t, err, ErrWrongAlgorithm)
+
+	// keys without alg can be used with any algorithm their type allows
+	_, err = jwks.Key("hmac", "HS256")
+	assert.NoError(t, err)
+}
+
+func TestJWKSRefreshServesStaleKeys(t *testing.T) {
+	keys := newTestKeys(t)
+	var fetches atomic.Int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// every fetch after the first hangs until released
+		if fetches.Add(1) > 1 {
+			<-release
+		}
+		w.Write(keys.jwks())
+	}))
+	defer server.Close()
+	defer close(release)
+
+	jwks := NewJWKS(server.URL, time.Hour)
+	_, err := jwks.Key("rsa", "RS256")
+	require.NoError(t, err)
+
+	jwks.ttl = 0
+	jwks.minRefresh = 0
+	for i := 0; i < 3; i++ {
+		_, err := jwks.Key("rsa", "RS256")
+		assert.NoError(t, err)
+	}
+	require.Eventually(t, func() bool { return fetches.Load() == 2 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(2), fetches.Load(), "requests share one fetch")
+}
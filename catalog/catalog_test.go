@@ -0,0 +1,84 @@
+package catalog
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeTag(t *testing.T) {
+	testCases := []struct {
+		tag      string
+		expected string
+		valid    bool
+	}{
+		{"5g", "5g", true},
+		{"Noise Cancelling", "noise-cancelling", true},
+		{"  USB-C ", "usb-c", true},
+		{"", "", false},
+		{"-leading-dash", "", false},
+		{"wi_fi", "", false},
+		{"ñandú", "", false},
+		{"a123456789a123456789a123456789a123456789a123456789", "a123456789a123456789a123456789a123456789a123456789", true},
+		{"a123456789a123456789a123456789a123456789a123456789a", "", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.tag, func(t *testing.T) {
+			normalized, err := NormalizeTag(tc.tag)
+			if !tc.valid {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, normalized)
+		})
+	}
+}
+
+func TestNormalizeTags(t *testing.T) {
+	tags, err := normalizeTags([]string{"Wireless", "5g", "wireless", "Noise Cancelling"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"5g", "noise-cancelling", "wireless"}, tags)
+
+	tags, err = normalizeTags(nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{}, tags)
+
+	_, err = normalizeTags([]string{"ok", "not ok!"})
+	assert.Error(t, err)
+}
+
+func TestTree(t *testing.T) {
+	electronics, phones, laptops := "e", "p", "l"
+	categories := []Category{
+		{ID: "s", Name: "Smartphones", ParentID: &phones},
+		{ID: phones, Name: "Phones", ParentID: &electronics},
+		{ID: laptops, Name: "Laptops", ParentID: &electronics},
+		{ID: electronics, Name: "Electronics"},
+		{ID: "b", Name: "Books"},
+	}
+
+	tree := Tree(categories)
+	require.Len(t, tree, 2)
+	assert.Equal(t, "Books", tree[0].Name)
+	assert.Empty(t, tree[0].Children)
+
+	assert.Equal(t, "Electronics", tree[1].Name)
+	require.Len(t, tree[1].Children, 2)
+	assert.Equal(t, "Laptops", tree[1].Children[0].Name)
+	assert.Equal(t, "Phones", tree[1].Children[1].Name)
+	require.Len(t, tree[1].Children[1].Children, 1)
+	assert.Equal(t, "Smartphones", tree[1].Children[1].Children[0].Name)
+}
+
+func TestTreeEmpty(t *testing.T) {
+	assert.Equal(t, []*CategoryNode{}, Tree(nil))
+}
+
+func TestDescendantsSQL(t *testing.T) {
+	sql := DescendantsSQL(3)
+	assert.Contains(t, sql, "WHERE id = $3")
+	assert.NotContains(t, sql, "$1")
+}
@@ -0,0 +1,363 @@
+package catalog
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"rest/audit"
+	"rest/db"
+	"rest/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// SQLSTATE of a unique constraint violation
+const uniqueViolation = "23505"
+
+type Category struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	ParentID  *string   `json:"parent_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CategoryNode is a category with its subcategories, as GET /categories returns them
+type CategoryNode struct {
+	Category
+	Children []*CategoryNode `json:"children"`
+}
+
+// CategoryDetail is a category with the path to it from the root and its
+// direct subcategories
+type CategoryDetail struct {
+	Category
+	Path     []Category `json:"path"`
+	Children []Category `json:"children"`
+}
+
+type categoryInput struct {
+	Name     string  `json:"name"`
+	ParentID *string `json:"parent_id"`
+}
+
+const categoryColumns = "id::STRING, name, parent_id::STRING, created_at"
+
+// Selects the IDs of a category and all of its descendants, $n being the category
+func DescendantsSQL(n int) string {
+	return fmt.Sprintf("WITH RECURSIVE tree (id) AS (SELECT id FROM categories WHERE id = $%d UNION ALL SELECT c.id FROM categories c JOIN tree ON c.parent_id = tree.id) SELECT id FROM tree", n)
+}
+
+func scanCategories(rows pgx.Rows) ([]Category, error) {
+	defer rows.Close()
+
+	categories := []Category{}
+	for rows.Next() {
+		var c Category
+		if err := rows.Scan(&c.ID, &c.Name, &c.ParentID, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		categories = append(categories, c)
+	}
+	return categories, rows.Err()
+}
+
+// Tree nests categories under their parents, siblings sorted by name
+func Tree(categories []Category) []*CategoryNode {
+	nodes := map[string]*CategoryNode{}
+	for _, c := range categories {
+		nodes[c.ID] = &CategoryNode{Category: c, Children: []*CategoryNode{}}
+	}
+
+	roots := []*CategoryNode{}
+	for _, c := range categories {
+		node := nodes[c.ID]
+		if parent, ok := nodes[deref(c.ParentID)]; ok {
+			parent.Children = append(parent.Children, node)
+		} else {
+			roots = append(roots, node)
+		}
+	}
+
+	var sortNodes func([]*CategoryNode)
+	sortNodes = func(list []*CategoryNode) {
+		sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+		for _, n := range list {
+			sortNodes(n.Children)
+		}
+	}
+	sortNodes(roots)
+	return roots
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// ListCategories handles GET /categories, returning the whole tree
+func ListCategories(database *db.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		database := database.WithContext(c.Request.Context())
+
+		rows, err := database.Query("SELECT " + categoryColumns + " FROM categories")
+		if err != nil {
+			utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		categories, err := scanCategories(rows)
+		if err != nil {
+			utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, Tree(categories))
+	}
+}
+
+// GetCategory handles GET /categories/:id
+func GetCategory(database *db.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		database := database.WithContext(c.Request.Context())
+
+		var detail CategoryDetail
+		err := database.QueryRow("SELECT "+categoryColumns+" FROM categories WHERE id = $1", c.Param("id")).
+			Scan(&detail.ID, &detail.Name, &detail.ParentID, &detail.CreatedAt)
+		if errors.Is(err, pgx.ErrNoRows) {
+			utils.ErrorResponse(c, http.StatusNotFound, "Category not found")
+			return
+		}
+		if err != nil {
+			utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		rows, err := database.Query(
+			"WITH RECURSIVE path (id, depth) AS (SELECT parent_id, 1 FROM categories WHERE id = $1 UNION ALL SELECT c.parent_id, path.depth + 1 FROM categories c JOIN path ON c.id = path.id) "+
+				"SELECT c.id::STRING, c.name, c.parent_id::STRING, c.created_at FROM path JOIN categories c ON c.id = path.id ORDER BY path.depth DESC",
+			detail.ID,
+		)
+		if err == nil {
+			detail.Path, err = scanCategories(rows)
+		}
+		if err != nil {
+			utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		rows, err = database.Query("SELECT "+categoryColumns+" FROM categories WHERE parent_id = $1 ORDER BY name", detail.ID)
+		if err == nil {
+			detail.Children, err = scanCategories(rows)
+		}
+		if err != nil {
+			utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, detail)
+	}
+}
+
+// Checks that a category can be placed under parent, it must exist and must
+// not be the category itself or one of its descendants
+func checkParent(tx *db.Database, id string, parent *string) (int, error) {
+	if parent == nil {
+		return 0, nil
+	}
+
+	var exists bool
+	if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM categories WHERE id = $1)", *parent).Scan(&exists); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if !exists {
+		return http.StatusUnprocessableEntity, errors.New("Parent category does not exist")
+	}
+
+	if id == "" {
+		return 0, nil
+	}
+	var cycle bool
+	if err := tx.QueryRow("SELECT $2::UUID IN ("+DescendantsSQL(1)+")", id, *parent).Scan(&cycle); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if cycle {
+		return http.StatusConflict, errors.New("A category cannot be moved under itself or its descendants")
+	}
+	return 0, nil
+}
+
+func bindCategory(c *gin.Context) (categoryInput, bool) {
+	var input categoryInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err.Error())
+		return input, false
+	}
+	input.Name = strings.TrimSpace(input.Name)
+	if input.Name == "" {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Empty field")
+		return input, false
+	}
+	return input, true
+}
+
+// statusError carries the response status out of a transaction
+type statusError struct {
+	status int
+	err    error
+}
+
+func (e statusError) Error() string { return e.err.Error() }
+
+func respondError(c *gin.Context, err error) {
+	var se statusError
+	if errors.As(err, &se) {
+		utils.ErrorResponse(c, se.status, se.err.Error())
+		return
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		utils.ErrorResponse(c, http.StatusNotFound, "Category not found")
+		return
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+		utils.ErrorResponse(c, http.StatusConflict, "A category with this name already exists under this parent")
+		return
+	}
+	utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+}
+
+// CreateCategory handles POST /categories
+func CreateCategory(database *db.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		database := database.WithContext(c.Request.Context())
+
+		input, ok := bindCategory(c)
+		if !ok {
+			return
+		}
+
+		var category Category
+		err := database.Transaction(func(tx *db.Database) error {
+			if status, err := checkParent(tx, "", input.ParentID); err != nil {
+				return statusError{status, err}
+			}
+			return tx.QueryRow("INSERT INTO categories (name, parent_id) VALUES ($1, $2) RETURNING "+categoryColumns, input.Name, input.ParentID).
+				Scan(&category.ID, &category.Name, &category.ParentID, &category.CreatedAt)
+		})
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		audit.Record(c, "category", category.ID, nil, category)
+		c.JSON(http.StatusCreated, category)
+	}
+}
+
+// UpdateCategory handles PUT /categories/:id, renaming or moving a category
+func UpdateCategory(database *db.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		database := database.WithContext(c.Request.Context())
+
+		id := c.Param("id")
+		input, ok := bindCategory(c)
+		if !ok {
+			return
+		}
+
+		var before, after Category
+		err := database.Transaction(func(tx *db.Database) error {
+			err := tx.QueryRow("SELECT "+categoryColumns+" FROM categories WHERE id = $1 FOR UPDATE", id).
+				Scan(&before.ID, &before.Name, &before.ParentID, &before.CreatedAt)
+			if err != nil {
+				return err
+			}
+			if status, err := checkParent(tx, id, input.ParentID); err != nil {
+				return statusError{status, err}
+			}
+			return tx.QueryRow("UPDATE categories SET name = $1, parent_id = $2 WHERE id = $3 RETURNING "+categoryColumns, input.Name, input.ParentID, id).
+				Scan(&after.ID, &after.Name, &after.ParentID, &after.CreatedAt)
+		})
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		audit.Record(c, "category", id, before, after)
+		c.JSON(http.StatusOK, after)
+	}
+}
+
+// DeleteCategory handles DELETE /categories/:id. Categories with
+// subcategories cannot be deleted, their products become uncategorized and
+// changed runs for each of them in the transaction.
+func DeleteCategory(database *db.Database, changed func(tx *db.Database, productID, operation string) error) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		database := database.WithContext(c.Request.Context())
+
+		id := c.Param("id")
+		var category Category
+		err := database.Transaction(func(tx *db.Database) error {
+			err := tx.QueryRow("SELECT "+categoryColumns+" FROM categories WHERE id = $1 FOR UPDATE", id).
+				Scan(&category.ID, &category.Name, &category.ParentID, &category.CreatedAt)
+			if err != nil {
+				return err
+			}
+
+			var children bool
+			if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM categories WHERE parent_id = $1)", id).Scan(&children); err != nil {
+				return err
+			}
+			if children {
+				return statusError{http.StatusConflict, errors.New("Category has subcategories")}
+			}
+
+			rows, err := tx.Query("UPDATE products SET category_id = NULL WHERE category_id = $1 RETURNING id", id)
+			if err != nil {
+				return err
+			}
+			var products []string
+			for rows.Next() {
+				var product string
+				if err := rows.Scan(&product); err != nil {
+					rows.Close()
+					return err
+				}
+				products = append(products, product)
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return err
+			}
+			for _, product := range products {
+				if err := changed(tx, product, "category"); err != nil {
+					return err
+				}
+			}
+			return tx.ExecQuery("DELETE FROM categories WHERE id = $1", id)
+		})
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		audit.Record(c, "category", id, category, nil)
+		c.JSON(http.StatusNoContent, nil)
+	}
+}
+
+// CategoryExists reports whether a category exists, for handlers outside
+// this package that take a category ID. The ID must be a UUID, which the
+// OpenAPI validation checks before the handler runs.
+func CategoryExists(database *db.Database, id string) (bool, error) {
+	var exists bool
+	err := database.QueryRow("SELECT EXISTS (SELECT 1 FROM categories WHERE id = $1)", id).Scan(&exists)
+	return exists, err
+}
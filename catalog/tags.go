@@ -0,0 +1,268 @@
+package catalog
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"rest/audit"
+	"rest/db"
+	"rest/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+var tagPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,49}$`)
+
+// NormalizeTag lowercases a tag and turns spaces into dashes, so that
+// "Noise Cancelling" and "noise-cancelling" are the same tag
+func NormalizeTag(tag string) (string, error) {
+	normalized := strings.Join(strings.Fields(strings.ToLower(tag)), "-")
+	if !tagPattern.MatchString(normalized) {
+		return "", fmt.Errorf("invalid tag %q, tags are up to 50 letters, digits and dashes", tag)
+	}
+	return normalized, nil
+}
+
+// Normalizes, deduplicates and sorts tags
+func normalizeTags(tags []string) ([]string, error) {
+	seen := map[string]bool{}
+	normalized := []string{}
+	for _, tag := range tags {
+		t, err := NormalizeTag(tag)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[t] {
+			seen[t] = true
+			normalized = append(normalized, t)
+		}
+	}
+	sort.Strings(normalized)
+	return normalized, nil
+}
+
+type TagCount struct {
+	Tag      string `json:"tag"`
+	Products int    `json:"products"`
+}
+
+// ListTags handles GET /tags, every tag with the number of products carrying it
+func ListTags(database *db.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		database := database.WithContext(c.Request.Context())
+
+		rows, err := database.Query("SELECT t.tag, count(*) FROM product_tags t JOIN products p ON p.id = t.product_id WHERE p.deleted_at IS NULL GROUP BY t.tag ORDER BY t.tag")
+		if err != nil {
+			utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		defer rows.Close()
+
+		tags := []TagCount{}
+		for rows.Next() {
+			var t TagCount
+			if err := rows.Scan(&t.Tag, &t.Products); err != nil {
+				utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+				return
+			}
+			tags = append(tags, t)
+		}
+
+		c.JSON(http.StatusOK, tags)
+	}
+}
+
+var errProductNotFound = errors.New("Product not found")
+
+func lockProduct(tx *db.Database, id string) error {
+	var found string
+	err := tx.QueryRow("SELECT id FROM products WHERE id = $1 AND deleted_at IS NULL FOR UPDATE", id).Scan(&found)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return statusError{http.StatusNotFound, errProductNotFound}
+	}
+	return err
+}
+
+func productTags(database *db.Database, id string) ([]string, error) {
+	rows, err := database.Query("SELECT tag FROM product_tags WHERE product_id = $1 ORDER BY tag", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []string{}
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+// GetProductTags handles GET /products/:id/tags
+func GetProductTags(database *db.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		database := database.WithContext(c.Request.Context())
+
+		id := c.Param("id")
+		var exists bool
+		err := database.QueryRow("SELECT EXISTS (SELECT 1 FROM products WHERE id = $1 AND deleted_at IS NULL)", id).Scan(&exists)
+		if err != nil {
+			utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if !exists {
+			utils.ErrorResponse(c, http.StatusNotFound, errProductNotFound.Error())
+			return
+		}
+
+		tags, err := productTags(database, id)
+		if err != nil {
+			utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"tags": tags})
+	}
+}
+
+// SetProductTags handles PUT /products/:id/tags, replacing the tags of a
+// product. changed runs in the transaction.
+func SetProductTags(database *db.Database, changed func(tx *db.Database, productID, operation string) error) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		database := database.WithContext(c.Request.Context())
+
+		id := c.Param("id")
+		var input struct {
+			Tags []string `json:"tags"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			utils.ErrorResponse(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		tags, err := normalizeTags(input.Tags)
+		if err != nil {
+			utils.ErrorResponse(c, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		var before []string
+		err = database.Transaction(func(tx *db.Database) error {
+			if err := lockProduct(tx, id); err != nil {
+				return err
+			}
+			var err error
+			if before, err = productTags(tx, id); err != nil {
+				return err
+			}
+			if err := tx.ExecQuery("DELETE FROM product_tags WHERE product_id = $1", id); err != nil {
+				return err
+			}
+			if len(tags) > 0 {
+				if err := tx.ExecQuery("INSERT INTO product_tags (product_id, tag) SELECT $1, unnest($2::STRING[])", id, tags); err != nil {
+					return err
+				}
+			}
+			return changed(tx, id, "tags")
+		})
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		audit.Record(c, "product", id, gin.H{"tags": before}, gin.H{"tags": tags})
+		c.JSON(http.StatusOK, gin.H{"tags": tags})
+	}
+}
+
+// RemoveProductTag handles DELETE /products/:id/tags/:tag, changed runs in
+// the transaction
+func RemoveProductTag(database *db.Database, changed func(tx *db.Database, productID, operation string) error) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		database := database.WithContext(c.Request.Context())
+
+		id := c.Param("id")
+		tag, err := NormalizeTag(c.Param("tag"))
+		if err != nil {
+			utils.ErrorResponse(c, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		err = database.Transaction(func(tx *db.Database) error {
+			if err := lockProduct(tx, id); err != nil {
+				return err
+			}
+			var removed string
+			err := tx.QueryRow("DELETE FROM product_tags WHERE product_id = $1 AND tag = $2 RETURNING tag", id, tag).Scan(&removed)
+			if errors.Is(err, pgx.ErrNoRows) {
+				return statusError{http.StatusNotFound, errors.New("Product does not have this tag")}
+			}
+			if err != nil {
+				return err
+			}
+			return changed(tx, id, "tags")
+		})
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		audit.Record(c, "product", id, gin.H{"tags": []string{tag}}, gin.H{"tags": []string{}})
+		c.JSON(http.StatusNoContent, nil)
+	}
+}
+
+// SetProductCategory handles PUT /products/:id/category, a null category_id
+// removes the product from its category. changed runs in the transaction.
+func SetProductCategory(database *db.Database, changed func(tx *db.Database, productID, operation string) error) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		database := database.WithContext(c.Request.Context())
+
+		id := c.Param("id")
+		var input struct {
+			CategoryID *string `json:"category_id"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			utils.ErrorResponse(c, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		var before *string
+		err := database.Transaction(func(tx *db.Database) error {
+			err := tx.QueryRow("SELECT category_id::STRING FROM products WHERE id = $1 AND deleted_at IS NULL FOR UPDATE", id).Scan(&before)
+			if errors.Is(err, pgx.ErrNoRows) {
+				return statusError{http.StatusNotFound, errProductNotFound}
+			}
+			if err != nil {
+				return err
+			}
+			if input.CategoryID != nil {
+				exists, err := CategoryExists(tx, *input.CategoryID)
+				if err != nil {
+					return err
+				}
+				if !exists {
+					return statusError{http.StatusUnprocessableEntity, errors.New("Category does not exist")}
+				}
+			}
+			if err := tx.ExecQuery("UPDATE products SET category_id = $1 WHERE id = $2", input.CategoryID, id); err != nil {
+				return err
+			}
+			return changed(tx, id, "category")
+		})
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		audit.Record(c, "product", id, gin.H{"category_id": before}, gin.H{"category_id": input.CategoryID})
+		c.JSON(http.StatusOK, gin.H{"id": id, "category_id": input.CategoryID})
+	}
+}
@@ -1,178 +1,216 @@
-package main
+// Package client is a Go client for the products API.
+package client
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"math"
+	mathrand "math/rand"
 	"net/http"
-	"os"
-	"rest/utils"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 )
 
-// Makes a POST request to the server
-func create(p utils.Product) {
+const DefaultBaseURL = "http://localhost:8888"
 
-	url := "http://localhost:8888/products"
+type Config struct {
+	// BaseURL of the API, http://localhost:8888 when empty
+	BaseURL string
+	// HTTPClient sends the requests, a client with Timeout when nil
+	HTTPClient *http.Client
+	// Timeout of a single attempt when HTTPClient is nil, 30s when zero
+	Timeout time.Duration
 
-	jsonData, err := json.Marshal(p)
-	if err != nil {
-		log.Fatalf("Error marshalling product to JSON: %v", err)
+	// APIKey is sent as X-API-Key, Token as a bearer token
+	APIKey string
+	Token  string
+
+	// MaxRetries is how often a failed request is retried, 3 when zero and
+	// none when negative. The wait doubles from MinBackoff up to MaxBackoff.
+	MaxRetries int
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	UserAgent string
+}
+
+// Client talks to the products API, it is safe for concurrent use
+type Client struct {
+	config  Config
+	baseURL *url.URL
+	http    *http.Client
+}
+
+func New(config Config) (*Client, error) {
+	if config.BaseURL == "" {
+		config.BaseURL = DefaultBaseURL
+	}
+	baseURL, err := url.Parse(strings.TrimSuffix(config.BaseURL, "/"))
+	if err != nil || baseURL.Scheme == "" || baseURL.Host == "" {
+		return nil, fmt.Errorf("invalid base URL %q", config.BaseURL)
 	}
 
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(jsonData))
-	if err != nil {
-		log.Fatalf("Error making POST request: %v", err)
+	if config.Timeout == 0 {
+		config.Timeout = 30 * time.Second
+	}
+	httpClient := config.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: config.Timeout}
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusCreated {
-		fmt.Println("POST request successful!")
-	} else {
-		fmt.Printf("POST request failed with status: %s\n", resp.Status)
+	if config.MaxRetries == 0 {
+		config.MaxRetries = 3
+	}
+	if config.MinBackoff == 0 {
+		config.MinBackoff = 100 * time.Millisecond
+	}
+	if config.MaxBackoff == 0 {
+		config.MaxBackoff = 5 * time.Second
+	}
+	if config.UserAgent == "" {
+		config.UserAgent = "rest-client"
 	}
-}
 
-// Makes a GET request to the server
-func read(page int, limit int) {
+	return &Client{config, baseURL, httpClient}, nil
+}
 
-	baseURL := "http://localhost:8888/products"
-	url := fmt.Sprintf("%s?page=%d&limit=%d", baseURL, page, limit)
+type request struct {
+	method string
+	path   string
+	query  url.Values
+	body   any
+	header http.Header
+}
 
-	resp, err := http.Get(url)
-	if err != nil {
-		log.Fatalf("Error making Get request: %v", err)
+// Sends a request, retrying it when that is safe, and decodes a successful
+// response into out
+func (c *Client) do(ctx context.Context, r request, out any) error {
+	var body []byte
+	if r.body != nil {
+		var err error
+		if body, err = json.Marshal(r.body); err != nil {
+			return err
+		}
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		fmt.Println("Error reading response body:", err)
-		return
-	}
+	u := *c.baseURL
+	u.Path += r.path
+	u.RawQuery = r.query.Encode()
+
+	// POST is only repeated when the server can recognise the repeat
+	retryable := r.method != http.MethodPost || r.header.Get("Idempotency-Key") != ""
 
-	if resp.StatusCode == http.StatusOK {
+	for attempt := 0; ; attempt++ {
+		err := c.attempt(ctx, r, u.String(), body, out)
+		if err == nil {
+			return nil
+		}
 
-		var products []utils.Product
-		if err := json.Unmarshal(body, &products); err != nil {
-			fmt.Println("Error unmarshalling JSON:", err)
-			return
+		wait, ok := c.retryAfter(err, attempt)
+		if !ok || !retryable {
+			return err
 		}
 
-		for _, product := range products {
-			fmt.Printf("ID: %s, Name: %s, Price: %s, Quantity: %s\n", product.ID, product.Name, product.Price, product.Quantity)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
 		}
-	} else {
-		fmt.Printf("Get request failed with status: %s\n", resp.Status)
 	}
 }
 
-// Makes a PUT request to the server
-func update(p utils.Product) {
-
-	jsonData, err := json.Marshal(p)
-	if err != nil {
-		log.Fatalf("Error marshalling product to JSON: %v", err)
+func (c *Client) attempt(ctx context.Context, r request, url string, body []byte, out any) error {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
 	}
 
-	baseURL := "http://localhost:8888/product"
-	url := fmt.Sprintf("%s?id=%s", baseURL, p.ID)
-
-	req, err := http.NewRequest("PUT", url, bytes.NewBuffer([]byte(jsonData)))
+	req, err := http.NewRequestWithContext(ctx, r.method, url, reader)
 	if err != nil {
-		fmt.Println("Error creating request:", err)
-		return
+		return err
+	}
+	for name, values := range r.header {
+		req.Header[name] = values
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", c.config.UserAgent)
+	if c.config.APIKey != "" {
+		req.Header.Set("X-API-Key", c.config.APIKey)
+	}
+	if c.config.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.config.Token)
 	}
-	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := c.http.Do(req)
 	if err != nil {
-		fmt.Println("Error making PUT request:", err)
-		return
+		return err
 	}
 	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	data, err := io.ReadAll(resp.Body)
 	if err != nil {
-		fmt.Println("Error reading response body:", err)
-		return
+		return err
 	}
 
-	if resp.StatusCode == http.StatusOK {
-
-		var product utils.Product
-		if err := json.Unmarshal(body, &product); err != nil {
-			fmt.Println("Error unmarshalling JSON:", err)
-			return
-		}
-		fmt.Printf("ID: %s, Name: %s, Price: %s, Quantity: %s\n", product.ID, product.Name, product.Price, product.Quantity)
-
+	if resp.StatusCode >= 400 {
+		return newError(resp, data)
 	}
-
-}
-
-// Makes a DELETE request to the server
-func delete(id string) {
-
-	baseURL := "http://localhost:8888/product"
-	url := fmt.Sprintf("%s?id=%s", baseURL, id)
-
-	req, err := http.NewRequest("DELETE", url, nil)
-	if err != nil {
-		fmt.Println("Error creating request:", err)
-		return
+	if out == nil || len(data) == 0 {
+		return nil
 	}
+	return json.Unmarshal(data, out)
+}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		fmt.Println("Error making DELETE request:", err)
-		return
+// Decides whether a failed attempt is worth repeating and how long to wait
+func (c *Client) retryAfter(err error, attempt int) (time.Duration, bool) {
+	if attempt >= c.config.MaxRetries || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return 0, false
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusNoContent {
-		fmt.Println("Product deleted successfully")
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		switch apiErr.StatusCode {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		default:
+			return 0, false
+		}
+		if apiErr.RetryAfter > 0 {
+			return min(apiErr.RetryAfter, c.config.MaxBackoff), true
+		}
+	} else if !errors.As(err, new(*url.Error)) {
+		// only failures to reach the server are retried, not broken responses
+		return 0, false
 	}
 
+	// exponential backoff with full jitter
+	backoff := float64(c.config.MinBackoff) * math.Pow(2, float64(attempt))
+	backoff = min(backoff, float64(c.config.MaxBackoff))
+	return time.Duration(mathrand.Int63n(int64(backoff)) + 1), true
 }
 
-func addAllProductsToDB(path string) {
-	products := utils.JsonToArray(path)
-
-	for _, p := range products {
-		create(p)
-	}
+// Generates the key CreateProduct sends so that its retries are safe
+func idempotencyKey() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
 }
 
-func main() {
-
-	if len(os.Args) < 2 {
-		fmt.Println("Please provide an argument.")
-		return
-	}
-
-	arg := os.Args[1]
-
-	switch arg {
-	case "db":
-		fmt.Println("Adding data to DB")
-		addAllProductsToDB("../data.json")
-	case "c":
-		fmt.Println("Making a POST request")
-		create(utils.NewProduct("0", "TEST", "POLLA LEFTA", "FULL"))
-	case "r":
-		fmt.Println("Making a GET request")
-		read(1, 30) // RETURNS ALL PRODUCTS
-	case "u":
-		fmt.Println("Making a PUT request")
-		update(utils.NewProduct("0", "TEST", "TEST", "TEST")) // !!! MAKE SURE TO USE A VALID ID !!!
-	case "d":
-		fmt.Println("Making a DELETE request")
-		delete("1") // !!! MAKE SURE TO USE A VALID ID !!!
-	default:
-		fmt.Println("Unknown request. Please use 'db', 'c', 'r', 'u', or 'd'.")
+func retryAfterHeader(value string) time.Duration {
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
 	}
+	return 0
 }
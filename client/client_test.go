@@ -0,0 +1,273 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestClient(t *testing.T, handler http.HandlerFunc, config Config) *Client {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	config.BaseURL = server.URL
+	config.MinBackoff = time.Millisecond
+	config.MaxBackoff = 10 * time.Millisecond
+	c, err := New(config)
+	require.NoError(t, err)
+	return c
+}
+
+func TestNew(t *testing.T) {
+	c, err := New(Config{})
+	require.NoError(t, err)
+	assert.Equal(t, DefaultBaseURL, c.baseURL.String())
+
+	_, err = New(Config{BaseURL: "localhost"})
+	assert.Error(t, err)
+}
+
+func TestGetProduct(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/products/1", r.URL.Path)
+		assert.Equal(t, "secret", r.Header.Get("X-API-Key"))
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		w.Write([]byte(`{"id":"1","name":"Apple iPhone 15","price":"999.99","quantity":"150"}`))
+	}, Config{APIKey: "secret", Token: "token"})
+
+	p, err := c.GetProduct(context.Background(), "1")
+	require.NoError(t, err)
+	assert.Equal(t, Product{ID: "1", Name: "Apple iPhone 15", Price: "999.99", Quantity: "150"}, p)
+}
+
+func TestErrors(t *testing.T) {
+	testCases := []struct {
+		name     string
+		status   int
+		body     string
+		sentinel error
+		expected Error
+	}{
+		{
+			name:     "Legacy error",
+			status:   http.StatusNotFound,
+			body:     `{"error":"Page out of range","request_id":"abc"}`,
+			sentinel: ErrNotFound,
+			expected: Error{StatusCode: 404, Title: "Not Found", Detail: "Page out of range", RequestID: "abc"},
+		},
+		{
+			name:     "Validation problem",
+			status:   http.StatusBadRequest,
+			body:     `{"type":"/problems/validation-failed","title":"Bad Request","status":400,"detail":"The request does not match the API schema","errors":[{"field":"body.price","message":"must be a string"}]}`,
+			sentinel: ErrBadRequest,
+			expected: Error{StatusCode: 400, Type: "/problems/validation-failed", Title: "Bad Request", Detail: "The request does not match the API schema", Violations: []Violation{{"body.price", "must be a string"}}},
+		},
+		{
+			name:     "Forbidden",
+			status:   http.StatusForbidden,
+			body:     `{"type":"/problems/forbidden","title":"Forbidden","status":403}`,
+			sentinel: ErrForbidden,
+			expected: Error{StatusCode: 403, Type: "/problems/forbidden", Title: "Forbidden"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var calls atomic.Int32
+			c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				calls.Add(1)
+				w.WriteHeader(tc.status)
+				w.Write([]byte(tc.body))
+			}, Config{})
+
+			_, err := c.GetProduct(context.Background(), "1")
+			assert.ErrorIs(t, err, tc.sentinel)
+
+			var apiErr *Error
+			require.True(t, errors.As(err, &apiErr))
+			assert.Equal(t, tc.expected, *apiErr)
+			assert.Equal(t, int32(1), calls.Load(), "client errors are not retried")
+		})
+	}
+}
+
+func TestRetries(t *testing.T) {
+	var calls atomic.Int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch calls.Add(1) {
+		case 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			w.Write([]byte(`[]`))
+		}
+	}, Config{})
+
+	_, err := c.ListProducts(context.Background(), ListOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), calls.Load())
+}
+
+func TestRetriesExhausted(t *testing.T) {
+	var calls atomic.Int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}, Config{MaxRetries: 2})
+
+	_, err := c.ListProducts(context.Background(), ListOptions{})
+	assert.ErrorIs(t, err, ErrServer)
+	assert.Equal(t, int32(3), calls.Load())
+}
+
+func TestCreateProductIsRetriedWithTheSameKey(t *testing.T) {
+	var keys []string
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+
+		var p Product
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&p))
+		assert.Equal(t, "31", p.ID)
+
+		if len(keys) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(p)
+	}, Config{})
+
+	p, err := c.CreateProduct(context.Background(), Product{ID: "31", Name: "NAME", Price: "1", Quantity: "1"})
+	require.NoError(t, err)
+	assert.Equal(t, "31", p.ID)
+
+	require.Len(t, keys, 2)
+	assert.NotEmpty(t, keys[0])
+	assert.Equal(t, keys[0], keys[1])
+}
+
+func TestUpdateAndDelete(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/product", r.URL.Path)
+		assert.Equal(t, "1", r.URL.Query().Get("id"))
+
+		switch r.Method {
+		case http.MethodPut:
+			body, _ := io.ReadAll(r.Body)
+			assert.JSONEq(t, `{"id":"","name":"","price":"5","quantity":""}`, string(body))
+			w.Write([]byte(`{"id":"","name":"Laptop","price":"5","quantity":"10"}`))
+		case http.MethodDelete:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}, Config{})
+
+	p, err := c.UpdateProduct(context.Background(), "1", Product{ID: "ignored", Price: "5"})
+	require.NoError(t, err)
+	assert.Equal(t, Product{ID: "1", Name: "Laptop", Price: "5", Quantity: "10"}, p)
+
+	assert.NoError(t, c.DeleteProduct(context.Background(), "1"))
+}
+
+func TestImportProducts(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/products/import", r.URL.Path)
+		assert.NotEmpty(t, r.Header.Get("Idempotency-Key"))
+		body, _ := io.ReadAll(r.Body)
+		assert.JSONEq(t, `{"products":[{"id":"1","name":"Laptop","price":"5","quantity":"10","reorder_threshold":2},{"id":"2","name":"Cable","price":"1","quantity":"3"}]}`, string(body))
+		w.Write([]byte(`{"imported":1,"results":[{"id":"1","status":201},{"id":"2","status":409,"error":"A product with this ID already exists"}]}`))
+	}, Config{})
+
+	threshold := 2
+	deleted := time.Now()
+	results, err := c.ImportProducts(context.Background(), []Product{
+		{ID: "1", Name: "Laptop", Price: "5", Quantity: "10", ReorderThreshold: &threshold},
+		{ID: "2", Name: "Cable", Price: "1", Quantity: "3", DeletedAt: &deleted},
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.NoError(t, results[0].Err())
+	assert.ErrorIs(t, results[1].Err(), ErrConflict)
+	assert.EqualError(t, results[1].Err(), "409 Conflict: A product with this ID already exists")
+}
+
+func TestContextCancelStopsRetrying(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}, Config{})
+	c.config.MaxBackoff = time.Minute
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := c.ListProducts(ctx, ListOptions{})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestProductIterator(t *testing.T) {
+	const total = 25
+	var pages []string
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		pages = append(pages, r.URL.Query().Get("page"))
+
+		offset := (page - 1) * limit
+		if offset >= total {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":"Page out of range"}`))
+			return
+		}
+
+		products := []Product{}
+		for i := offset; i < min(offset+limit, total); i++ {
+			products = append(products, Product{ID: strconv.Itoa(i)})
+		}
+		json.NewEncoder(w).Encode(products)
+	}, Config{})
+
+	var ids []string
+	it := c.Products(ListOptions{Limit: 10})
+	for it.Next(context.Background()) {
+		ids = append(ids, it.Product().ID)
+	}
+	require.NoError(t, it.Err())
+	assert.Len(t, ids, total)
+	assert.Equal(t, []string{"1", "2", "3"}, pages, "the short last page ends the iteration")
+
+	// an exact multiple of the limit ends on the 404
+	pages = nil
+	ids = nil
+	it = c.Products(ListOptions{Limit: 5})
+	for it.Next(context.Background()) {
+		ids = append(ids, it.Product().ID)
+	}
+	require.NoError(t, it.Err())
+	assert.Len(t, ids, total)
+	assert.Equal(t, []string{"1", "2", "3", "4", "5", "6"}, pages)
+}
+
+func TestProductIteratorError(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}, Config{})
+
+	it := c.Products(ListOptions{})
+	assert.False(t, it.Next(context.Background()))
+	assert.ErrorIs(t, it.Err(), ErrForbidden)
+}
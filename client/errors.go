@@ -0,0 +1,105 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Errors.Is matches an *Error against these by status code
+var (
+	ErrBadRequest   = errors.New("bad request")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("forbidden")
+	ErrNotFound     = errors.New("not found")
+	ErrConflict     = errors.New("conflict")
+	ErrRateLimited  = errors.New("rate limited")
+	ErrServer       = errors.New("server error")
+)
+
+// Violation is one reason a request failed validation
+type Violation struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Error is returned for every response with a 4xx or 5xx status. It is
+// filled from both problem responses and the older {"error": ...} bodies.
+type Error struct {
+	StatusCode int
+	Type       string
+	Title      string
+	Detail     string
+	RequestID  string
+	TraceID    string
+	Violations []Violation
+	// RetryAfter is set on 429 responses
+	RetryAfter time.Duration
+}
+
+func newError(resp *http.Response, body []byte) *Error {
+	var payload struct {
+		Type      string      `json:"type"`
+		Title     string      `json:"title"`
+		Detail    string      `json:"detail"`
+		Error     string      `json:"error"`
+		RequestID string      `json:"request_id"`
+		TraceID   string      `json:"trace_id"`
+		Errors    []Violation `json:"errors"`
+	}
+	json.Unmarshal(body, &payload)
+
+	e := &Error{
+		StatusCode: resp.StatusCode,
+		Type:       payload.Type,
+		Title:      payload.Title,
+		Detail:     payload.Detail,
+		RequestID:  payload.RequestID,
+		TraceID:    payload.TraceID,
+		Violations: payload.Errors,
+		RetryAfter: retryAfterHeader(resp.Header.Get("Retry-After")),
+	}
+	if e.Title == "" {
+		e.Title = http.StatusText(resp.StatusCode)
+	}
+	if e.Detail == "" {
+		e.Detail = payload.Error
+	}
+	if e.RequestID == "" {
+		e.RequestID = resp.Header.Get("X-Request-ID")
+	}
+	return e
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("%d %s", e.StatusCode, e.Title)
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+	for _, v := range e.Violations {
+		msg += fmt.Sprintf("; %s %s", v.Field, v.Message)
+	}
+	return msg
+}
+
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrBadRequest:
+		return e.StatusCode == http.StatusBadRequest
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrForbidden:
+		return e.StatusCode == http.StatusForbidden
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrConflict:
+		return e.StatusCode == http.StatusConflict
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrServer:
+		return e.StatusCode >= 500
+	}
+	return false
+}
@@ -0,0 +1,242 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Product as the server returns it. CategoryID and ReorderThreshold are not
+// taken by CreateProduct and UpdateProduct, they are set with
+// SetProductCategory and SetReorderThreshold or by ImportProducts.
+type Product struct {
+	ID               string     `json:"id"`
+	Name             string     `json:"name"`
+	Price            string     `json:"price"`
+	Quantity         string     `json:"quantity"`
+	CategoryID       *string    `json:"category_id,omitempty"`
+	ReorderThreshold *int       `json:"reorder_threshold,omitempty"`
+	DeletedAt        *time.Time `json:"deleted_at,omitempty"`
+}
+
+type ProductHistory struct {
+	Operation string     `json:"operation"`
+	ChangedAt time.Time  `json:"changed_at"`
+	Name      string     `json:"name"`
+	Price     string     `json:"price"`
+	Quantity  string     `json:"quantity"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+// ListOptions selects a page, the server defaults to page 1 of 10
+type ListOptions struct {
+	Page           int
+	Limit          int
+	IncludeDeleted bool
+}
+
+func (o ListOptions) query() url.Values {
+	query := url.Values{}
+	if o.Page > 0 {
+		query.Set("page", strconv.Itoa(o.Page))
+	}
+	if o.Limit > 0 {
+		query.Set("limit", strconv.Itoa(o.Limit))
+	}
+	if o.IncludeDeleted {
+		query.Set("include_deleted", "true")
+	}
+	return query
+}
+
+// ListProducts returns one page of products, a page past the end is ErrNotFound
+func (c *Client) ListProducts(ctx context.Context, options ListOptions) ([]Product, error) {
+	var products []Product
+	err := c.do(ctx, request{method: http.MethodGet, path: "/products", query: options.query()}, &products)
+	return products, err
+}
+
+func (c *Client) GetProduct(ctx context.Context, id string) (Product, error) {
+	var p Product
+	err := c.do(ctx, request{method: http.MethodGet, path: "/products/" + url.PathEscape(id)}, &p)
+	return p, err
+}
+
+// GetProductAsOf returns the product as it was at a point in time
+func (c *Client) GetProductAsOf(ctx context.Context, id string, at time.Time) (Product, error) {
+	var p Product
+	query := url.Values{"as_of": {at.UTC().Format(time.RFC3339)}}
+	err := c.do(ctx, request{method: http.MethodGet, path: "/products/" + url.PathEscape(id), query: query}, &p)
+	return p, err
+}
+
+// CreateProduct sends an Idempotency-Key so that it can be retried safely
+func (c *Client) CreateProduct(ctx context.Context, p Product) (Product, error) {
+	p.CategoryID = nil
+	p.ReorderThreshold = nil
+	p.DeletedAt = nil
+
+	var created Product
+	header := http.Header{"Idempotency-Key": {idempotencyKey()}}
+	err := c.do(ctx, request{method: http.MethodPost, path: "/products", body: p, header: header}, &created)
+	return created, err
+}
+
+// ImportResult is the outcome of one product of ImportProducts
+type ImportResult struct {
+	ID     string `json:"id"`
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Err is the *Error POST /products would have returned for the product, nil
+// when it was created
+func (r ImportResult) Err() error {
+	if r.Status < 400 {
+		return nil
+	}
+	return &Error{StatusCode: r.Status, Title: http.StatusText(r.Status), Detail: r.Error}
+}
+
+// ImportProducts creates many products in one request, category and reorder
+// threshold included. A product that fails does not stop the rest, the
+// results are in the order of products. Like CreateProduct it sends an
+// Idempotency-Key.
+func (c *Client) ImportProducts(ctx context.Context, products []Product) ([]ImportResult, error) {
+	body := struct {
+		Products []Product `json:"products"`
+	}{make([]Product, len(products))}
+	for i, p := range products {
+		p.DeletedAt = nil
+		body.Products[i] = p
+	}
+
+	var response struct {
+		Results []ImportResult `json:"results"`
+	}
+	header := http.Header{"Idempotency-Key": {idempotencyKey()}}
+	err := c.do(ctx, request{method: http.MethodPost, path: "/products/import", body: body, header: header}, &response)
+	return response.Results, err
+}
+
+// UpdateProduct changes the non-empty fields of p
+func (c *Client) UpdateProduct(ctx context.Context, id string, p Product) (Product, error) {
+	p.ID = ""
+	p.CategoryID = nil
+	p.ReorderThreshold = nil
+	p.DeletedAt = nil
+
+	var updated Product
+	err := c.do(ctx, request{method: http.MethodPut, path: "/product", query: url.Values{"id": {id}}, body: p}, &updated)
+	if err == nil {
+		updated.ID = id
+	}
+	return updated, err
+}
+
+// SetProductCategory files a product under a category, nil takes it out
+func (c *Client) SetProductCategory(ctx context.Context, id string, categoryID *string) error {
+	body := map[string]*string{"category_id": categoryID}
+	return c.do(ctx, request{method: http.MethodPut, path: "/products/" + url.PathEscape(id) + "/category", body: body}, nil)
+}
+
+// SetReorderThreshold sets the quantity a low stock alert fires at, nil
+// turns the alert off
+func (c *Client) SetReorderThreshold(ctx context.Context, id string, threshold *int) error {
+	body := map[string]*int{"reorder_threshold": threshold}
+	return c.do(ctx, request{method: http.MethodPut, path: "/products/" + url.PathEscape(id) + "/threshold", body: body}, nil)
+}
+
+func (c *Client) DeleteProduct(ctx context.Context, id string) error {
+	return c.do(ctx, request{method: http.MethodDelete, path: "/product", query: url.Values{"id": {id}}}, nil)
+}
+
+func (c *Client) RestoreProduct(ctx context.Context, id string) (Product, error) {
+	var p Product
+	err := c.do(ctx, request{method: http.MethodPost, path: "/products/" + url.PathEscape(id) + "/restore"}, &p)
+	return p, err
+}
+
+// ProductHistory returns one page of the changes of a product, newest first
+func (c *Client) ProductHistory(ctx context.Context, id string, options ListOptions) ([]ProductHistory, error) {
+	var history []ProductHistory
+	err := c.do(ctx, request{method: http.MethodGet, path: "/products/" + url.PathEscape(id) + "/history", query: options.query()}, &history)
+	return history, err
+}
+
+// ProductIterator walks through every product page by page:
+//
+//	it := c.Products(client.ListOptions{Limit: 50})
+//	for it.Next(ctx) {
+//		p := it.Product()
+//	}
+//	if err := it.Err(); err != nil { ... }
+type ProductIterator struct {
+	client  *Client
+	options ListOptions
+	page    []Product
+	index   int
+	done    bool
+	err     error
+}
+
+// Products starts iterating at options.Page, or the first page
+func (c *Client) Products(options ListOptions) *ProductIterator {
+	if options.Page < 1 {
+		options.Page = 1
+	}
+	return &ProductIterator{client: c, options: options, index: -1}
+}
+
+// Next advances to the next product, fetching the next page when needed. It
+// returns false at the end or on an error.
+func (it *ProductIterator) Next(ctx context.Context) bool {
+	if it.err != nil {
+		return false
+	}
+
+	it.index++
+	if it.index < len(it.page) {
+		return true
+	}
+	if it.done {
+		return false
+	}
+
+	page, err := it.client.ListProducts(ctx, it.options)
+	if errors.Is(err, ErrNotFound) {
+		// the server answers 404 for a page past the last product
+		it.done = true
+		return false
+	}
+	if err != nil {
+		it.err = err
+		return false
+	}
+
+	// a short page is the last one
+	limit := it.options.Limit
+	if limit < 1 {
+		limit = 10
+	}
+	if len(page) < limit {
+		it.done = true
+	}
+
+	it.options.Page++
+	it.page = page
+	it.index = 0
+	return len(page) > 0
+}
+
+// Product is the current product, valid after Next returned true
+func (it *ProductIterator) Product() Product {
+	return it.page[it.index]
+}
+
+func (it *ProductIterator) Err() error {
+	return it.err
+}
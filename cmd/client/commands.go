@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"rest/client"
+)
+
+var commands []command
+
+func init() {
+	// assigned here because completion refers back to the list
+	commands = []command{
+		{name: "list", summary: "List products", setup: listCommand},
+		{name: "get", args: "<id>", summary: "Show a product", setup: getCommand},
+		{name: "create", summary: "Create a product", setup: createCommand},
+		{name: "update", args: "<id>", summary: "Replace the name, price and quantity of a product", setup: updateCommand},
+		{name: "patch", args: "<id>", summary: "Change some fields of a product", setup: patchCommand},
+		{name: "delete", args: "<id>", summary: "Delete a product", setup: deleteCommand},
+		{name: "restore", args: "<id>", summary: "Restore a deleted product", setup: restoreCommand},
+		{name: "history", args: "<id>", summary: "Show the changes of a product", setup: historyCommand},
+		{name: "import", args: "<file|->", summary: "Create the products of a JSON, YAML or CSV file", setup: importCommand},
+		{name: "export", summary: "Write every product as JSON, YAML or CSV", setup: exportCommand},
+		{name: "completion", args: "<bash|zsh|fish>", summary: "Print a shell completion script", setup: completionCommand},
+	}
+}
+
+func oneID(args []string) (string, error) {
+	if len(args) != 1 || args[0] == "" {
+		return "", usagef("expected exactly one product ID")
+	}
+	return args[0], nil
+}
+
+func noArgs(args []string) error {
+	if len(args) != 0 {
+		return usagef("unexpected arguments %s", strings.Join(args, " "))
+	}
+	return nil
+}
+
+type productFlags struct {
+	id, name, price, quantity string
+}
+
+func (f *productFlags) register(fs *flag.FlagSet, withID bool) {
+	if withID {
+		fs.StringVar(&f.id, "id", "", "product ID")
+	}
+	fs.StringVar(&f.name, "name", "", "product name")
+	fs.StringVar(&f.price, "price", "", "product price")
+	fs.StringVar(&f.quantity, "quantity", "", "units in stock")
+}
+
+func (f *productFlags) product() client.Product {
+	return client.Product{ID: f.id, Name: f.name, Price: f.price, Quantity: f.quantity}
+}
+
+func listCommand(fs *flag.FlagSet) func(context.Context, *env, []string) error {
+	page := fs.Int("page", 1, "page to show")
+	limit := fs.Int("limit", 10, "products per page")
+	all := fs.Bool("all", false, "show every page")
+	includeDeleted := fs.Bool("include-deleted", false, "also list deleted products")
+
+	return func(ctx context.Context, e *env, args []string) error {
+		if err := noArgs(args); err != nil {
+			return err
+		}
+
+		options := client.ListOptions{Page: *page, Limit: *limit, IncludeDeleted: *includeDeleted}
+
+		var products []client.Product
+		var err error
+		if *all {
+			products, err = allProducts(ctx, e.client, options)
+		} else {
+			products, err = e.client.ListProducts(ctx, options)
+		}
+		if err != nil {
+			return err
+		}
+		return writeProducts(e.stdout, e.output, products)
+	}
+}
+
+func allProducts(ctx context.Context, c *client.Client, options client.ListOptions) ([]client.Product, error) {
+	products := []client.Product{}
+	it := c.Products(options)
+	for it.Next(ctx) {
+		products = append(products, it.Product())
+	}
+	return products, it.Err()
+}
+
+func getCommand(fs *flag.FlagSet) func(context.Context, *env, []string) error {
+	asOf := fs.String("as-of", "", "show the product as it was at this RFC 3339 time")
+
+	return func(ctx context.Context, e *env, args []string) error {
+		id, err := oneID(args)
+		if err != nil {
+			return err
+		}
+
+		var p client.Product
+		if *asOf != "" {
+			at, err := time.Parse(time.RFC3339, *asOf)
+			if err != nil {
+				return usagef("--as-of must be an RFC 3339 time")
+			}
+			p, err = e.client.GetProductAsOf(ctx, id, at)
+			if err != nil {
+				return err
+			}
+		} else if p, err = e.client.GetProduct(ctx, id); err != nil {
+			return err
+		}
+		return writeProducts(e.stdout, e.output, []client.Product{p})
+	}
+}
+
+func createCommand(fs *flag.FlagSet) func(context.Context, *env, []string) error {
+	var f productFlags
+	f.register(fs, true)
+
+	return func(ctx context.Context, e *env, args []string) error {
+		if err := noArgs(args); err != nil {
+			return err
+		}
+		if f.id == "" || f.name == "" || f.price == "" || f.quantity == "" {
+			return usagef("--id, --name, --price and --quantity are required")
+		}
+
+		p, err := e.client.CreateProduct(ctx, f.product())
+		if err != nil {
+			return err
+		}
+		return writeProducts(e.stdout, e.output, []client.Product{p})
+	}
+}
+
+func updateCommand(fs *flag.FlagSet) func(context.Context, *env, []string) error {
+	var f productFlags
+	f.register(fs, false)
+
+	return func(ctx context.Context, e *env, args []string) error {
+		id, err := oneID(args)
+		if err != nil {
+			return err
+		}
+		if f.name == "" || f.price == "" || f.quantity == "" {
+			return usagef("--name, --price and --quantity are required, use patch to change some of them")
+		}
+		return update(ctx, e, id, f.product())
+	}
+}
+
+func patchCommand(fs *flag.FlagSet) func(context.Context, *env, []string) error {
+	var f productFlags
+	f.register(fs, false)
+
+	return func(ctx context.Context, e *env, args []string) error {
+		id, err := oneID(args)
+		if err != nil {
+			return err
+		}
+		if f.name == "" && f.price == "" && f.quantity == "" {
+			return usagef("at least one of --name, --price and --quantity is required")
+		}
+		return update(ctx, e, id, f.product())
+	}
+}
+
+func update(ctx context.Context, e *env, id string, p client.Product) error {
+	updated, err := e.client.UpdateProduct(ctx, id, p)
+	if err != nil {
+		return err
+	}
+	return writeProducts(e.stdout, e.output, []client.Product{updated})
+}
+
+func deleteCommand(fs *flag.FlagSet) func(context.Context, *env, []string) error {
+	return func(ctx context.Context, e *env, args []string) error {
+		id, err := oneID(args)
+		if err != nil {
+			return err
+		}
+		if err := e.client.DeleteProduct(ctx, id); err != nil {
+			return err
+		}
+		fmt.Fprintln(e.stderr, "Deleted product", id)
+		return nil
+	}
+}
+
+func restoreCommand(fs *flag.FlagSet) func(context.Context, *env, []string) error {
+	return func(ctx context.Context, e *env, args []string) error {
+		id, err := oneID(args)
+		if err != nil {
+			return err
+		}
+		p, err := e.client.RestoreProduct(ctx, id)
+		if err != nil {
+			return err
+		}
+		return writeProducts(e.stdout, e.output, []client.Product{p})
+	}
+}
+
+func historyCommand(fs *flag.FlagSet) func(context.Context, *env, []string) error {
+	page := fs.Int("page", 1, "page to show")
+	limit := fs.Int("limit", 10, "changes per page")
+
+	return func(ctx context.Context, e *env, args []string) error {
+		id, err := oneID(args)
+		if err != nil {
+			return err
+		}
+		history, err := e.client.ProductHistory(ctx, id, client.ListOptions{Page: *page, Limit: *limit})
+		if err != nil {
+			return err
+		}
+		return writeHistory(e.stdout, e.output, history)
+	}
+}
+
+// Picks the file format from --format or the file extension
+func fileFormat(format, path string) (string, error) {
+	if format == "" {
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".yaml", ".yml":
+			format = "yaml"
+		case ".csv":
+			format = "csv"
+		default:
+			format = "json"
+		}
+	}
+	if format != "json" && format != "yaml" && format != "csv" {
+		return "", usagef("unknown format %q, use json, yaml or csv", format)
+	}
+	return format, nil
+}
+
+func importCommand(fs *flag.FlagSet) func(context.Context, *env, []string) error {
+	format := fs.String("format", "", "json, yaml or csv, taken from the file extension by default")
+
+	return func(ctx context.Context, e *env, args []string) error {
+		if len(args) != 1 {
+			return usagef("expected a file, or - for standard input")
+		}
+		path := args[0]
+
+		format, err := fileFormat(*format, path)
+		if err != nil {
+			return err
+		}
+
+		r := e.stdin
+		if path != "-" {
+			file, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			r = file
+		}
+
+		products, err := readProducts(r, format)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+
+		// keep going so that one bad product or batch does not stop the rest
+		var lastErr error
+		failed := 0
+		for start := 0; start < len(products); start += importBatch {
+			batch := products[start:min(start+importBatch, len(products))]
+			results, err := e.client.ImportProducts(ctx, batch)
+			if err != nil {
+				fmt.Fprintf(e.stderr, "Failed to import products %s to %s: %v\n", batch[0].ID, batch[len(batch)-1].ID, err)
+				lastErr = err
+				failed += len(batch)
+				continue
+			}
+			for _, result := range results {
+				if err := result.Err(); err != nil {
+					fmt.Fprintf(e.stderr, "Failed to import product %s: %v\n", result.ID, err)
+					lastErr = err
+					failed++
+				}
+			}
+		}
+
+		fmt.Fprintf(e.stderr, "Imported %d of %d products\n", len(products)-failed, len(products))
+		if lastErr != nil {
+			return fmt.Errorf("%d products failed to import: %w", failed, lastErr)
+		}
+		return nil
+	}
+}
+
+// importBatch is how many products import sends per request, the server
+// takes up to 1000
+const importBatch = 100
+
+func exportCommand(fs *flag.FlagSet) func(context.Context, *env, []string) error {
+	format := fs.String("format", "", "json, yaml or csv, taken from --file or json by default")
+	file := fs.String("file", "", "write to this file instead of standard output")
+	includeDeleted := fs.Bool("include-deleted", false, "also export deleted products")
+
+	return func(ctx context.Context, e *env, args []string) error {
+		if err := noArgs(args); err != nil {
+			return err
+		}
+
+		format, err := fileFormat(*format, *file)
+		if err != nil {
+			return err
+		}
+
+		products, err := allProducts(ctx, e.client, client.ListOptions{Limit: 100, IncludeDeleted: *includeDeleted})
+		if err != nil {
+			return err
+		}
+
+		if *file == "" {
+			return writeProducts(e.stdout, format, products)
+		}
+
+		f, err := os.Create(*file)
+		if err != nil {
+			return err
+		}
+		err = writeProducts(f, format, products)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(e.stderr, "Exported %d products to %s\n", len(products), *file)
+		return nil
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Flags of a command, global ones included, as --name
+func commandFlags(c command) []string {
+	fs := flag.NewFlagSet(c.name, flag.ContinueOnError)
+	var options globalOptions
+	options.register(fs)
+	c.setup(fs)
+
+	var flags []string
+	fs.VisitAll(func(f *flag.Flag) {
+		if len(f.Name) > 1 {
+			flags = append(flags, "--"+f.Name)
+		}
+	})
+	sort.Strings(flags)
+	return flags
+}
+
+func completionCommand(fs *flag.FlagSet) func(context.Context, *env, []string) error {
+	return func(ctx context.Context, e *env, args []string) error {
+		if len(args) != 1 {
+			return usagef("expected bash, zsh or fish")
+		}
+
+		switch args[0] {
+		case "bash":
+			return bashCompletion(e.stdout)
+		case "zsh":
+			fmt.Fprintln(e.stdout, "#compdef client")
+			fmt.Fprintln(e.stdout, "autoload -U +X bashcompinit && bashcompinit")
+			return bashCompletion(e.stdout)
+		case "fish":
+			return fishCompletion(e.stdout)
+		}
+		return usagef("unknown shell %q, use bash, zsh or fish", args[0])
+	}
+}
+
+func bashCompletion(w io.Writer) error {
+	var cases strings.Builder
+	for _, name := range commandNames() {
+		c, _ := findCommand(name)
+		words := strings.Join(commandFlags(c), " ")
+		switch name {
+		case "completion":
+			words = "bash zsh fish"
+		case "import":
+			fmt.Fprintf(&cases, "    %s)\n      COMPREPLY=($(compgen -f -W %q -- \"$cur\")); return ;;\n", name, words)
+			continue
+		}
+		fmt.Fprintf(&cases, "    %s)\n      COMPREPLY=($(compgen -W %q -- \"$cur\")); return ;;\n", name, words)
+	}
+
+	_, err := fmt.Fprintf(w, `# bash completion for client, load it with: source <(client completion bash)
+_client() {
+  local cur="${COMP_WORDS[COMP_CWORD]}"
+  local prev="${COMP_WORDS[COMP_CWORD-1]}"
+  case "$prev" in
+    --output|-o|--format)
+      COMPREPLY=($(compgen -W "table json yaml csv" -- "$cur")); return ;;
+  esac
+
+  local i
+  for ((i = 1; i < COMP_CWORD; i++)); do
+    case "${COMP_WORDS[i]}" in
+%s    esac
+  done
+  COMPREPLY=($(compgen -W %q -- "$cur"))
+}
+complete -F _client client
+`, cases.String(), strings.Join(commandNames(), " ")+" "+strings.Join(commandFlags(command{setup: noFlags}), " "))
+	return err
+}
+
+func fishCompletion(w io.Writer) error {
+	fmt.Fprintln(w, "# fish completion for client, load it with: client completion fish | source")
+	fmt.Fprintln(w, "complete -c client -f")
+	for _, name := range commandNames() {
+		c, _ := findCommand(name)
+		fmt.Fprintf(w, "complete -c client -n __fish_use_subcommand -a %s -d %q\n", name, c.summary)
+		for _, f := range commandFlags(c) {
+			fmt.Fprintf(w, "complete -c client -n '__fish_seen_subcommand_from %s' -l %s\n", name, strings.TrimPrefix(f, "--"))
+		}
+	}
+	fmt.Fprintln(w, "complete -c client -n '__fish_seen_subcommand_from completion' -a 'bash zsh fish'")
+	_, err := fmt.Fprintln(w, "complete -c client -l output -s o -a 'table json yaml csv'")
+	return err
+}
+
+func noFlags(*flag.FlagSet) func(context.Context, *env, []string) error {
+	return nil
+}
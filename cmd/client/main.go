@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"rest/client"
+)
+
+// Exit codes, so that scripts can tell failures apart without parsing output
+const (
+	exitOK          = 0
+	exitError       = 1
+	exitUsage       = 2
+	exitUnreachable = 3
+	exitNotFound    = 4
+	exitDenied      = 5
+	exitConflict    = 6
+	exitInvalid     = 7
+	exitRateLimited = 8
+	exitServer      = 9
+	exitRejected    = 10
+)
+
+const usageHeader = `Usage: client [global flags] <command> [flags] [arguments]
+
+Global flags (also accepted after the command):
+  --server URL     API address, $API_URL or http://localhost:8888
+  --token TOKEN    bearer token, $API_TOKEN
+  --api-key KEY    API key, $API_KEY
+  --output FORMAT  table, json, yaml or csv (default table)
+  --timeout D      timeout of each request (default 30s)
+
+Commands:
+`
+
+const usageFooter = `
+Exit codes: 0 success, 1 error, 2 usage, 3 server unreachable, 4 not found,
+5 unauthorized or forbidden, 6 conflict, 7 invalid request, 8 rate limited,
+9 server error, 10 other rejected request.`
+
+type usageError struct{ msg string }
+
+func (e usageError) Error() string { return e.msg }
+
+func usagef(format string, args ...any) error {
+	return usageError{fmt.Sprintf(format, args...)}
+}
+
+type globalOptions struct {
+	server  string
+	token   string
+	apiKey  string
+	output  string
+	timeout time.Duration
+}
+
+func (o *globalOptions) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.server, "server", o.server, "API address")
+	fs.StringVar(&o.token, "token", o.token, "bearer token")
+	fs.StringVar(&o.apiKey, "api-key", o.apiKey, "API key")
+	fs.StringVar(&o.output, "output", o.output, "table, json, yaml or csv")
+	fs.StringVar(&o.output, "o", o.output, "shorthand for --output")
+	fs.DurationVar(&o.timeout, "timeout", o.timeout, "timeout of each request")
+}
+
+// env is what a command runs with
+type env struct {
+	client *client.Client
+	output string
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// command is a subcommand; setup registers its flags and returns the function
+// running it, so that completion can list the flags without running anything
+type command struct {
+	name    string
+	args    string
+	summary string
+	setup   func(fs *flag.FlagSet) func(ctx context.Context, e *env, args []string) error
+}
+
+func commandNames() []string {
+	names := make([]string, 0, len(commands))
+	for _, c := range commands {
+		names = append(names, c.name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func findCommand(name string) (command, bool) {
+	for _, c := range commands {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return command{}, false
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprint(w, usageHeader)
+	for _, name := range commandNames() {
+		c, _ := findCommand(name)
+		fmt.Fprintf(w, "  %-40s %s\n", strings.TrimSpace(c.name+" "+c.args), c.summary)
+	}
+	fmt.Fprintln(w, usageFooter)
+}
+
+// Parses flags that may appear between arguments, e.g. `get 1 --output json`
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	options := globalOptions{
+		server:  os.Getenv("API_URL"),
+		token:   os.Getenv("API_TOKEN"),
+		apiKey:  os.Getenv("API_KEY"),
+		output:  "table",
+		timeout: 30 * time.Second,
+	}
+
+	global := flag.NewFlagSet("client", flag.ContinueOnError)
+	global.SetOutput(io.Discard)
+	options.register(global)
+	if err := global.Parse(args); err != nil || global.NArg() == 0 {
+		if err != nil && err != flag.ErrHelp {
+			fmt.Fprintln(stderr, "Error:", err)
+		}
+		printUsage(stderr)
+		return exitUsage
+	}
+
+	name := global.Arg(0)
+	if name == "help" {
+		printUsage(stdout)
+		return exitOK
+	}
+	cmd, ok := findCommand(name)
+	if !ok {
+		fmt.Fprintf(stderr, "Error: unknown command %q\n\n", name)
+		printUsage(stderr)
+		return exitUsage
+	}
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	options.register(fs)
+	runCommand := cmd.setup(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: client %s [flags] %s\n\n%s\n\nFlags:\n", name, cmd.args, cmd.summary)
+		fs.PrintDefaults()
+	}
+
+	positional, err := parseInterspersed(fs, global.Args()[1:])
+	if err == flag.ErrHelp {
+		return exitOK
+	}
+	if err != nil {
+		return exitUsage
+	}
+
+	switch options.output {
+	case "table", "json", "yaml", "csv":
+	default:
+		fmt.Fprintf(stderr, "Error: unknown output format %q\n", options.output)
+		return exitUsage
+	}
+
+	c, err := client.New(client.Config{
+		BaseURL: options.server,
+		Timeout: options.timeout,
+		Token:   options.token,
+		APIKey:  options.apiKey,
+	})
+	if err != nil {
+		fmt.Fprintln(stderr, "Error:", err)
+		return exitUsage
+	}
+
+	e := &env{client: c, output: options.output, stdin: stdin, stdout: stdout, stderr: stderr}
+	if err := runCommand(context.Background(), e, positional); err != nil {
+		fmt.Fprintln(stderr, "Error:", err)
+		if _, ok := err.(usageError); ok {
+			fs.Usage()
+		}
+		return exitCode(err)
+	}
+	return exitOK
+}
+
+// Maps an error to the exit code that describes it
+func exitCode(err error) int {
+	var apiErr *client.Error
+	var usage usageError
+	switch {
+	case errors.As(err, &usage):
+		return exitUsage
+	case errors.As(err, &apiErr):
+		switch {
+		case apiErr.StatusCode == 404:
+			return exitNotFound
+		case apiErr.StatusCode == 401 || apiErr.StatusCode == 403:
+			return exitDenied
+		case apiErr.StatusCode == 409:
+			return exitConflict
+		case apiErr.StatusCode == 400 || apiErr.StatusCode == 422:
+			return exitInvalid
+		case apiErr.StatusCode == 429:
+			return exitRateLimited
+		case apiErr.StatusCode >= 500:
+			return exitServer
+		default:
+			return exitRejected
+		}
+	case errors.As(err, new(*url.Error)):
+		return exitUnreachable
+	}
+	return exitError
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"rest/client"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeAPI keeps products in memory and answers like the server does
+type fakeAPI struct {
+	mu       sync.Mutex
+	products []client.Product
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	notFound := func() {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error":"not found"}`))
+	}
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/products":
+		if r.URL.Query().Get("page") != "1" {
+			notFound()
+			return
+		}
+		json.NewEncoder(w).Encode(f.products)
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/products/"):
+		for _, p := range f.products {
+			if p.ID == strings.TrimPrefix(r.URL.Path, "/products/") {
+				json.NewEncoder(w).Encode(p)
+				return
+			}
+		}
+		notFound()
+	case r.Method == http.MethodPost && r.URL.Path == "/products":
+		if r.Header.Get("X-API-Key") != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		var p client.Product
+		json.NewDecoder(r.Body).Decode(&p)
+		if f.exists(p.ID) {
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(`{"error":"duplicate key"}`))
+			return
+		}
+		f.products = append(f.products, p)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(p)
+	case r.Method == http.MethodPost && r.URL.Path == "/products/import":
+		var input struct{ Products []client.Product }
+		json.NewDecoder(r.Body).Decode(&input)
+		var results []client.ImportResult
+		for _, p := range input.Products {
+			if f.exists(p.ID) {
+				results = append(results, client.ImportResult{ID: p.ID, Status: http.StatusConflict, Error: "duplicate key"})
+				continue
+			}
+			f.products = append(f.products, p)
+			results = append(results, client.ImportResult{ID: p.ID, Status: http.StatusCreated})
+		}
+		json.NewEncoder(w).Encode(map[string]any{"results": results})
+	case r.Method == http.MethodPut && r.URL.Path == "/product":
+		var patch client.Product
+		json.NewDecoder(r.Body).Decode(&patch)
+		for i, p := range f.products {
+			if p.ID == r.URL.Query().Get("id") {
+				if patch.Name != "" {
+					p.Name = patch.Name
+				}
+				if patch.Price != "" {
+					p.Price = patch.Price
+				}
+				if patch.Quantity != "" {
+					p.Quantity = patch.Quantity
+				}
+				f.products[i] = p
+				json.NewEncoder(w).Encode(p)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/products/"):
+		id, field, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/products/"), "/")
+		var patch client.Product
+		json.NewDecoder(r.Body).Decode(&patch)
+		for i, p := range f.products {
+			if p.ID != id {
+				continue
+			}
+			switch field {
+			case "category":
+				p.CategoryID = patch.CategoryID
+			case "threshold":
+				p.ReorderThreshold = patch.ReorderThreshold
+			}
+			f.products[i] = p
+			json.NewEncoder(w).Encode(p)
+			return
+		}
+		notFound()
+	default:
+		notFound()
+	}
+}
+
+func (f *fakeAPI) exists(id string) bool {
+	for _, p := range f.products {
+		if p.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func runClient(t *testing.T, api http.Handler, stdin string, args ...string) (int, string, string) {
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+
+	var stdout, stderr bytes.Buffer
+	code := run(append([]string{"--server", server.URL, "--api-key", "secret"}, args...), strings.NewReader(stdin), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func newFakeAPI() *fakeAPI {
+	return &fakeAPI{products: []client.Product{
+		{ID: "1", Name: "Apple iPhone 15", Price: "999.99", Quantity: "150"},
+		{ID: "2", Name: "Samsung Galaxy S23", Price: "849.99", Quantity: "200"},
+	}}
+}
+
+func TestOutputFormats(t *testing.T) {
+	testCases := map[string]string{
+		"table": "id  name                price   quantity  category_id  reorder_threshold  deleted_at\n" +
+			"1   Apple iPhone 15     999.99  150                                       \n" +
+			"2   Samsung Galaxy S23  849.99  200                                       \n",
+		"csv": "id,name,price,quantity,category_id,reorder_threshold,deleted_at\n" +
+			"1,Apple iPhone 15,999.99,150,,,\n" +
+			"2,Samsung Galaxy S23,849.99,200,,,\n",
+		"yaml": "- id: \"1\"\n  name: Apple iPhone 15\n  price: \"999.99\"\n  quantity: \"150\"\n" +
+			"- id: \"2\"\n  name: Samsung Galaxy S23\n  price: \"849.99\"\n  quantity: \"200\"\n",
+	}
+
+	for format, expected := range testCases {
+		t.Run(format, func(t *testing.T) {
+			code, stdout, stderr := runClient(t, newFakeAPI(), "", "list", "--output", format)
+			assert.Equal(t, exitOK, code, stderr)
+			assert.Equal(t, expected, stdout)
+		})
+	}
+
+	code, stdout, _ := runClient(t, newFakeAPI(), "", "get", "1", "-o", "json")
+	assert.Equal(t, exitOK, code)
+	assert.JSONEq(t, `[{"id":"1","name":"Apple iPhone 15","price":"999.99","quantity":"150"}]`, stdout)
+}
+
+func TestExitCodes(t *testing.T) {
+	testCases := []struct {
+		name string
+		args []string
+		code int
+	}{
+		{name: "Not found", args: []string{"get", "404"}, code: exitNotFound},
+		{name: "Conflict", args: []string{"create", "--id", "1", "--name", "n", "--price", "1", "--quantity", "1"}, code: exitConflict},
+		{name: "Forbidden", args: []string{"--api-key", "wrong", "create", "--id", "3", "--name", "n", "--price", "1", "--quantity", "1"}, code: exitDenied},
+		{name: "Server error", args: []string{"patch", "404", "--name", "n"}, code: exitServer},
+		{name: "Missing flags", args: []string{"create", "--id", "3"}, code: exitUsage},
+		{name: "Update needs every field", args: []string{"update", "1", "--name", "n"}, code: exitUsage},
+		{name: "Unknown command", args: []string{"frobnicate"}, code: exitUsage},
+		{name: "Unknown output", args: []string{"list", "-o", "xml"}, code: exitUsage},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			code, _, _ := runClient(t, newFakeAPI(), "", tc.args...)
+			assert.Equal(t, tc.code, code)
+		})
+	}
+
+	var stderr bytes.Buffer
+	code := run([]string{"--server", "http://127.0.0.1:1", "--timeout", "1s", "list"}, nil, &bytes.Buffer{}, &stderr)
+	assert.Equal(t, exitUnreachable, code, stderr.String())
+}
+
+func TestPatch(t *testing.T) {
+	api := newFakeAPI()
+	code, stdout, _ := runClient(t, api, "", "patch", "1", "--price", "899.99", "-o", "csv")
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, "id,name,price,quantity,category_id,reorder_threshold,deleted_at\n1,Apple iPhone 15,899.99,150,,,\n", stdout)
+}
+
+func TestImportAndExport(t *testing.T) {
+	api := newFakeAPI()
+	csv := "quantity,price,name,id\n10,5.00,Cable,3\n20,7.50,Charger,4\n"
+
+	code, _, stderr := runClient(t, api, csv, "import", "--format", "csv", "-")
+	assert.Equal(t, exitOK, code, stderr)
+	assert.Contains(t, stderr, "Imported 2 of 2 products")
+
+	file := filepath.Join(t.TempDir(), "products.yaml")
+	code, _, stderr = runClient(t, api, "", "export", "--file", file)
+	assert.Equal(t, exitOK, code, stderr)
+
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	products, err := readProducts(bytes.NewReader(data), "yaml")
+	require.NoError(t, err)
+	assert.Equal(t, api.products, products)
+
+	// importing the export again conflicts on every product
+	code, _, stderr = runClient(t, api, "", "import", file)
+	assert.Equal(t, exitConflict, code)
+	assert.Contains(t, stderr, "Imported 0 of 4 products")
+}
+
+func TestImportKeepsCategoryAndThreshold(t *testing.T) {
+	api := newFakeAPI()
+	csv := "id,name,price,quantity,category_id,reorder_threshold\n" +
+		"3,Cable,5.00,10,6f1c2a4e-8b0d-4c3a-9f2e-1d5b7a9c0e3f,4\n" +
+		"4,Charger,7.50,20,,\n"
+
+	code, _, stderr := runClient(t, api, csv, "import", "--format", "csv", "-")
+	assert.Equal(t, exitOK, code, stderr)
+
+	category, threshold := "6f1c2a4e-8b0d-4c3a-9f2e-1d5b7a9c0e3f", 4
+	assert.Equal(t, client.Product{ID: "3", Name: "Cable", Price: "5.00", Quantity: "10", CategoryID: &category, ReorderThreshold: &threshold}, api.products[2])
+	assert.Equal(t, client.Product{ID: "4", Name: "Charger", Price: "7.50", Quantity: "20"}, api.products[3])
+
+	file := filepath.Join(t.TempDir(), "products.json")
+	code, _, stderr = runClient(t, api, "", "export", "--file", file)
+	assert.Equal(t, exitOK, code, stderr)
+
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	products, err := readProducts(bytes.NewReader(data), "json")
+	require.NoError(t, err)
+	assert.Equal(t, api.products, products)
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) { return 0, errors.New("no space left on device") }
+
+func TestWriteRowsFails(t *testing.T) {
+	for _, format := range []string{"csv", "table"} {
+		err := writeRows(failingWriter{}, format, productColumns, nil)
+		assert.EqualError(t, err, "no space left on device", format)
+	}
+}
+
+func TestExportToFullDevice(t *testing.T) {
+	if _, err := os.Stat("/dev/full"); err != nil {
+		t.Skip("no /dev/full")
+	}
+	code, _, stderr := runClient(t, newFakeAPI(), "", "export", "--format", "csv", "--file", "/dev/full")
+	assert.Equal(t, exitError, code)
+	assert.Contains(t, stderr, "no space left on device")
+}
+
+func TestCompletion(t *testing.T) {
+	for _, shell := range []string{"bash", "zsh", "fish"} {
+		code, stdout, _ := runClient(t, newFakeAPI(), "", "completion", shell)
+		assert.Equal(t, exitOK, code)
+		for _, name := range commandNames() {
+			assert.Contains(t, stdout, name)
+		}
+		assert.Contains(t, stdout, "include-deleted")
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"rest/client"
+
+	"gopkg.in/yaml.v3"
+)
+
+var productColumns = []string{"id", "name", "price", "quantity", "category_id", "reorder_threshold", "deleted_at"}
+
+// yamlProduct names the YAML keys like the JSON ones
+type yamlProduct struct {
+	ID               string     `yaml:"id"`
+	Name             string     `yaml:"name"`
+	Price            string     `yaml:"price"`
+	Quantity         string     `yaml:"quantity"`
+	CategoryID       *string    `yaml:"category_id,omitempty"`
+	ReorderThreshold *int       `yaml:"reorder_threshold,omitempty"`
+	DeletedAt        *time.Time `yaml:"deleted_at,omitempty"`
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+func productRow(p client.Product) []string {
+	var category, threshold string
+	if p.CategoryID != nil {
+		category = *p.CategoryID
+	}
+	if p.ReorderThreshold != nil {
+		threshold = strconv.Itoa(*p.ReorderThreshold)
+	}
+	return []string{p.ID, p.Name, p.Price, p.Quantity, category, threshold, formatTime(p.DeletedAt)}
+}
+
+func writeProducts(w io.Writer, format string, products []client.Product) error {
+	switch format {
+	case "json":
+		return writeJSON(w, products)
+	case "yaml":
+		rows := make([]yamlProduct, len(products))
+		for i, p := range products {
+			rows[i] = yamlProduct(p)
+		}
+		return yaml.NewEncoder(w).Encode(rows)
+	}
+
+	rows := make([][]string, len(products))
+	for i, p := range products {
+		rows[i] = productRow(p)
+	}
+	return writeRows(w, format, productColumns, rows)
+}
+
+func writeHistory(w io.Writer, format string, history []client.ProductHistory) error {
+	switch format {
+	case "json":
+		return writeJSON(w, history)
+	case "yaml":
+		type yamlHistory struct {
+			Operation string     `yaml:"operation"`
+			ChangedAt time.Time  `yaml:"changed_at"`
+			Name      string     `yaml:"name"`
+			Price     string     `yaml:"price"`
+			Quantity  string     `yaml:"quantity"`
+			DeletedAt *time.Time `yaml:"deleted_at,omitempty"`
+		}
+		rows := make([]yamlHistory, len(history))
+		for i, h := range history {
+			rows[i] = yamlHistory(h)
+		}
+		return yaml.NewEncoder(w).Encode(rows)
+	}
+
+	rows := make([][]string, len(history))
+	for i, h := range history {
+		rows[i] = []string{h.ChangedAt.Format(time.RFC3339), h.Operation, h.Name, h.Price, h.Quantity, formatTime(h.DeletedAt)}
+	}
+	return writeRows(w, format, []string{"changed_at", "operation", "name", "price", "quantity", "deleted_at"}, rows)
+}
+
+func writeJSON(w io.Writer, v any) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// Writes rows as CSV or as an aligned table
+func writeRows(w io.Writer, format string, columns []string, rows [][]string) error {
+	if format == "csv" {
+		return csv.NewWriter(w).WriteAll(append([][]string{columns}, rows...))
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for i, column := range columns {
+		if i > 0 {
+			fmt.Fprint(tw, "\t")
+		}
+		fmt.Fprint(tw, column)
+	}
+	fmt.Fprintln(tw)
+	for _, row := range rows {
+		for i, value := range row {
+			if i > 0 {
+				fmt.Fprint(tw, "\t")
+			}
+			fmt.Fprint(tw, value)
+		}
+		fmt.Fprintln(tw)
+	}
+	return tw.Flush()
+}
+
+// Reads products written by writeProducts, or data.json
+func readProducts(r io.Reader, format string) ([]client.Product, error) {
+	var products []client.Product
+	switch format {
+	case "json":
+		if err := json.NewDecoder(r).Decode(&products); err != nil {
+			return nil, err
+		}
+	case "yaml":
+		var rows []yamlProduct
+		if err := yaml.NewDecoder(r).Decode(&rows); err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			products = append(products, client.Product(row))
+		}
+	case "csv":
+		records, err := csv.NewReader(r).ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		if len(records) == 0 {
+			return nil, nil
+		}
+
+		// columns are found by the header so that they can be in any order
+		index := map[string]int{}
+		for i, column := range records[0] {
+			index[column] = i
+		}
+		for _, column := range []string{"id", "name", "price", "quantity"} {
+			if _, ok := index[column]; !ok {
+				return nil, fmt.Errorf("missing %s column", column)
+			}
+		}
+
+		// category_id and reorder_threshold are optional, empty when unset
+		for line, record := range records[1:] {
+			p := client.Product{
+				ID:       record[index["id"]],
+				Name:     record[index["name"]],
+				Price:    record[index["price"]],
+				Quantity: record[index["quantity"]],
+			}
+			if i, ok := index["category_id"]; ok && record[i] != "" {
+				p.CategoryID = &record[i]
+			}
+			if i, ok := index["reorder_threshold"]; ok && record[i] != "" {
+				threshold, err := strconv.Atoi(record[i])
+				if err != nil {
+					return nil, fmt.Errorf("line %d: reorder_threshold must be a whole number", line+2)
+				}
+				p.ReorderThreshold = &threshold
+			}
+			products = append(products, p)
+		}
+	}
+	return products, nil
+}
@@ -2,73 +2,196 @@ package db
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/multitracer"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// Conn is satisfied by both the connection pool and an open transaction
+type Conn interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
 type Database struct {
 	Name string
-	Conn *pgx.Conn
+	Conn Conn
+
+	ctx context.Context
 }
 
-func NewDatabase(user, password, host, port, name string, conn *pgx.Conn) Database {
-	return Database{name, conn}
+func NewDatabase(user, password, host, port, name string, conn Conn) Database {
+	return Database{Name: name, Conn: conn}
 }
 
-func Create(user, password, host, port, name string, conn *pgx.Conn) *Database {
+// WithContext returns a copy of the database whose queries run under ctx, so
+// that they are cancelled and traced along with the request
+func (db Database) WithContext(ctx context.Context) *Database {
+	db.ctx = ctx
+	return &db
+}
+
+func (db Database) context() context.Context {
+	if db.ctx == nil {
+		return context.Background()
+	}
+	return db.ctx
+}
+
+func Create(user, password, host, port, name string, conn Conn) (*Database, error) {
 	db := NewDatabase(user, password, host, port, name, conn)
-	db.createSchema()
+	if err := db.createSchema(); err != nil {
+		return nil, err
+	}
 
-	return &db
+	return &db, nil
 }
 
-func (db Database) createSchema() {
+func (db Database) createSchema() error {
 	databaseInit := []string{
 		"DROP DATABASE IF EXISTS " + db.Name,
 		"CREATE DATABASE " + db.Name,
 		"USE " + db.Name,
-		"CREATE TABLE products (id STRING PRIMARY KEY, name STRING, price STRING, quantity STRING)",
+		"CREATE TABLE categories (id UUID PRIMARY KEY DEFAULT gen_random_uuid(), name STRING NOT NULL, parent_id UUID REFERENCES categories (id), created_at TIMESTAMPTZ NOT NULL DEFAULT now(), UNIQUE (parent_id, name))",
+		// UNIQUE (parent_id, name) treats every NULL parent as distinct
+		"CREATE UNIQUE INDEX categories_root_name ON categories (name) WHERE parent_id IS NULL",
+		// units is the quantity as counted by Units
+		"CREATE TABLE products (id STRING PRIMARY KEY, name STRING, price STRING, quantity STRING, deleted_at TIMESTAMPTZ, category_id UUID REFERENCES categories (id), reorder_threshold INT, units INT AS (CASE WHEN quantity ~ '^[0-9]{1,18}$' THEN quantity::INT END) STORED, INDEX (category_id))",
+		"CREATE TABLE product_search_terms (term STRING NOT NULL, product_id STRING NOT NULL, PRIMARY KEY (term, product_id), INDEX (product_id))",
+		"CREATE INVERTED INDEX product_search_terms_trigrams ON product_search_terms (term gin_trgm_ops)",
+		"CREATE TABLE product_tags (product_id STRING NOT NULL REFERENCES products (id) ON DELETE CASCADE, tag STRING NOT NULL, PRIMARY KEY (product_id, tag), INDEX (tag))",
+		"CREATE TABLE stock_movements (id UUID PRIMARY KEY DEFAULT gen_random_uuid(), product_id STRING NOT NULL REFERENCES products (id) ON DELETE CASCADE, type STRING NOT NULL, quantity INT NOT NULL, balance INT NOT NULL, reason STRING NOT NULL DEFAULT '', reference STRING, actor STRING NOT NULL, created_at TIMESTAMPTZ NOT NULL DEFAULT now(), INDEX (product_id, created_at), UNIQUE (product_id, reference))",
+		"CREATE TABLE reservations (id UUID PRIMARY KEY DEFAULT gen_random_uuid(), status STRING NOT NULL, reference STRING, actor STRING NOT NULL, created_at TIMESTAMPTZ NOT NULL DEFAULT now(), expires_at TIMESTAMPTZ NOT NULL, INDEX (status, expires_at))",
+		"CREATE TABLE reservation_items (reservation_id UUID NOT NULL REFERENCES reservations (id) ON DELETE CASCADE, product_id STRING NOT NULL REFERENCES products (id) ON DELETE CASCADE, quantity INT NOT NULL, PRIMARY KEY (reservation_id, product_id), INDEX (product_id))",
+		"CREATE TABLE orders (id UUID PRIMARY KEY DEFAULT gen_random_uuid(), status STRING NOT NULL, customer STRING NOT NULL, reference STRING, total STRING NOT NULL, actor STRING NOT NULL, created_at TIMESTAMPTZ NOT NULL DEFAULT now(), updated_at TIMESTAMPTZ NOT NULL DEFAULT now(), INDEX (status, created_at), INDEX (customer, created_at), INDEX (created_at))",
+		"CREATE TABLE order_lines (order_id UUID NOT NULL REFERENCES orders (id) ON DELETE CASCADE, product_id STRING NOT NULL, name STRING NOT NULL, price STRING NOT NULL, quantity INT NOT NULL, total STRING NOT NULL, PRIMARY KEY (order_id, product_id), INDEX (product_id))",
+		"CREATE TABLE stock_alerts (id INT PRIMARY KEY DEFAULT unique_rowid(), product_id STRING NOT NULL REFERENCES products (id) ON DELETE CASCADE, kind STRING NOT NULL, quantity STRING NOT NULL, threshold INT, created_at TIMESTAMPTZ NOT NULL DEFAULT now(), delivered_at TIMESTAMPTZ, attempts INT NOT NULL DEFAULT 0, INDEX (product_id, id), INDEX (delivered_at, id))",
+		"CREATE TABLE webhook_subscriptions (id UUID PRIMARY KEY DEFAULT gen_random_uuid(), url STRING NOT NULL, secret STRING NOT NULL, events STRING[] NOT NULL, active BOOL NOT NULL DEFAULT true, created_at TIMESTAMPTZ NOT NULL DEFAULT now(), updated_at TIMESTAMPTZ NOT NULL DEFAULT now())",
+		"CREATE TABLE webhook_events (id UUID PRIMARY KEY DEFAULT gen_random_uuid(), type STRING NOT NULL, data JSONB NOT NULL, created_at TIMESTAMPTZ NOT NULL DEFAULT now(), message_id INT UNIQUE)",
+		"CREATE TABLE webhook_deliveries (id UUID PRIMARY KEY DEFAULT gen_random_uuid(), subscription_id UUID NOT NULL REFERENCES webhook_subscriptions (id) ON DELETE CASCADE, event_id UUID NOT NULL REFERENCES webhook_events (id), status STRING NOT NULL DEFAULT 'pending', attempts INT NOT NULL DEFAULT 0, next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(), last_status INT, last_error STRING NOT NULL DEFAULT '', created_at TIMESTAMPTZ NOT NULL DEFAULT now(), delivered_at TIMESTAMPTZ, INDEX (status, next_attempt_at), INDEX (subscription_id, created_at), INDEX (created_at))",
+		"CREATE TABLE outbox (id INT PRIMARY KEY DEFAULT unique_rowid(), type STRING NOT NULL, key STRING NOT NULL, payload JSONB NOT NULL, created_at TIMESTAMPTZ NOT NULL DEFAULT now(), published_at TIMESTAMPTZ, attempts INT NOT NULL DEFAULT 0, last_error STRING NOT NULL DEFAULT '', next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(), INDEX (published_at, id), INDEX (created_at))",
+		"CREATE INDEX outbox_pending_key ON outbox (key, id) WHERE published_at IS NULL",
+		"CREATE TABLE product_history (id UUID PRIMARY KEY DEFAULT gen_random_uuid(), product_id STRING NOT NULL, operation STRING NOT NULL, name STRING, price STRING, quantity STRING, deleted_at TIMESTAMPTZ, changed_at TIMESTAMPTZ NOT NULL DEFAULT now(), seq INT NOT NULL DEFAULT unique_rowid(), INDEX (product_id, changed_at, seq))",
+		"CREATE TABLE audit_log (id UUID PRIMARY KEY DEFAULT gen_random_uuid(), occurred_at TIMESTAMPTZ NOT NULL DEFAULT now(), actor STRING NOT NULL, method STRING NOT NULL, route STRING NOT NULL, status INT NOT NULL, request_id STRING NOT NULL, client_ip STRING NOT NULL, resource STRING NOT NULL, resource_id STRING NOT NULL, before JSONB, after JSONB, diff JSONB, INDEX (actor, occurred_at), INDEX (resource, resource_id, occurred_at), INDEX (occurred_at))",
+		"CREATE TABLE api_keys (id UUID PRIMARY KEY DEFAULT gen_random_uuid(), name STRING NOT NULL UNIQUE, prefix STRING NOT NULL, hash STRING NOT NULL UNIQUE, created_at TIMESTAMPTZ NOT NULL DEFAULT now(), last_used_at TIMESTAMPTZ, revoked_at TIMESTAMPTZ)",
+		"CREATE TABLE role_assignments (actor STRING NOT NULL, role STRING NOT NULL, PRIMARY KEY (actor, role))",
+		"CREATE TABLE idempotency_keys (key STRING PRIMARY KEY, fingerprint STRING NOT NULL, completed BOOL NOT NULL DEFAULT false, status INT NOT NULL DEFAULT 0, content_type STRING NOT NULL DEFAULT '', body BYTES NOT NULL DEFAULT b'', expires_at TIMESTAMPTZ NOT NULL)",
 	}
 
-	db.ExecSQL(databaseInit)
+	return db.ExecSQL(databaseInit)
+}
 
+// Units reads a product quantity as a number of units. Only whole numbers of
+// up to 18 digits are tracked, so they always fit an INT; the units column of
+// products follows the same rule.
+func Units(quantity string) (int, bool) {
+	if quantity == "" || len(quantity) > 18 || strings.ContainsFunc(quantity, func(r rune) bool { return r < '0' || r > '9' }) {
+		return 0, false
+	}
+	n, err := strconv.Atoi(quantity)
+	return n, err == nil
 }
 
-func (db Database) ExecSQL(sql []string) {
+// Runs the statements in order, stopping at the first one that fails
+func (db Database) ExecSQL(sql []string) error {
 	for _, stmt := range sql {
-		if _, err := db.Conn.Exec(context.Background(), stmt); err != nil {
-			log.Fatal(err)
+		start := time.Now()
+		if _, err := db.Conn.Exec(db.context(), stmt); err != nil {
+			return fmt.Errorf("%s: %w", stmt, err)
 		}
+		slog.Debug("executed statement", "sql", stmt, "duration_ms", time.Since(start).Milliseconds())
 	}
+	return nil
 }
 
 func (db Database) Query(sql string, values ...any) (pgx.Rows, error) {
-	rows, err := db.Conn.Query(context.Background(), sql, values...)
+	rows, err := db.Conn.Query(db.context(), sql, values...)
 	return rows, err
 }
 
 func (db Database) ExecQuery(sql string, values ...any) error {
-	_, err := db.Conn.Exec(context.Background(), sql, values...)
+	_, err := db.Conn.Exec(db.context(), sql, values...)
 	return err
 }
 
 func (db Database) QueryRow(sql string, values ...any) pgx.Row {
-	row := db.Conn.QueryRow(context.Background(), sql, values...)
+	row := db.Conn.QueryRow(db.context(), sql, values...)
 	return row
 }
 
-func GetConnection(user, host, port, name string) (*pgx.Conn, error) {
+// SQLSTATE of a transaction CockroachDB aborted to keep it serializable, it
+// succeeds when it is run again
+const retryableError = "40001"
+
+// MaxAttempts is how often Transaction runs a transaction that keeps failing
+// with a retryable error
+const MaxAttempts = 5
+
+// Runs fn inside a transaction, the transaction is committed if fn returns nil
+// and rolled back otherwise. A transaction CockroachDB aborts with a retryable
+// error is run again, so fn must not change anything outside of it. Inside
+// another transaction fn runs once in a savepoint and the retry is left to the
+// outer transaction.
+func (db Database) Transaction(fn func(tx *Database) error) error {
+	_, nested := db.Conn.(pgx.Tx)
+	for attempt := 1; ; attempt++ {
+		err := pgx.BeginFunc(db.context(), db.Conn, func(tx pgx.Tx) error {
+			return fn(&Database{db.Name, tx, db.ctx})
+		})
+		var pgErr *pgconn.PgError
+		if nested || attempt == MaxAttempts || !errors.As(err, &pgErr) || pgErr.Code != retryableError {
+			return err
+		}
+
+		slog.Debug("retrying transaction", "attempt", attempt, "error", err)
+		select {
+		case <-time.After(time.Duration(attempt) * 10 * time.Millisecond):
+		case <-db.context().Done():
+			return err
+		}
+	}
+}
+
+// Opens a connection pool, tracers are called around every query
+func GetConnection(user, host, port, name string, tracers ...pgx.QueryTracer) (*pgxpool.Pool, error) {
 	connectionString := fmt.Sprintf("postgresql://%s:@%s:%s/%s", user, host, port, name)
-	conn, err := pgx.Connect(context.Background(), connectionString)
+	config, err := pgxpool.ParseConfig(connectionString)
+	if err != nil {
+		return nil, err
+	}
+	if len(tracers) > 0 {
+		config.ConnConfig.Tracer = multitracer.New(tracers...)
+	}
+
+	pool, err := pgxpool.NewWithConfig(context.Background(), config)
+	if err != nil {
+		return nil, err
+	}
 
-	return conn, err
+	// The pool connects lazily, make sure the database is reachable
+	if err := pool.Ping(context.Background()); err != nil {
+		pool.Close()
+		return nil, err
+	}
+
+	return pool, nil
 }
 
-func USE(user, password, host, port, name string, conn *pgx.Conn) *Database {
+func USE(user, password, host, port, name string, conn Conn) *Database {
 	db := NewDatabase(user, password, host, port, name, conn)
-	db.ExecQuery("USE " + name)
+	if err := db.ExecQuery("USE " + name); err != nil {
+		slog.Warn("failed to select database", "database", name, "error", err)
+	}
 
 	return &db
 }
@@ -0,0 +1,110 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeConn only begins transactions, which do nothing
+type fakeConn struct {
+	Conn
+	begun int
+}
+
+func (c *fakeConn) Begin(ctx context.Context) (pgx.Tx, error) {
+	c.begun++
+	return fakeTx{}, nil
+}
+
+type fakeTx struct{ pgx.Tx }
+
+func (fakeTx) Commit(ctx context.Context) error   { return nil }
+func (fakeTx) Rollback(ctx context.Context) error { return nil }
+
+func TestTransactionRetries(t *testing.T) {
+	retry := &pgconn.PgError{Code: retryableError}
+
+	testCases := []struct {
+		name     string
+		failures int
+		err      error
+		attempts int
+		fails    bool
+	}{
+		{"success", 0, nil, 1, false},
+		{"retried", 2, retry, 3, false},
+		{"gives up", MaxAttempts, retry, MaxAttempts, true},
+		{"other error", 1, errors.New("boom"), 1, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conn := &fakeConn{}
+			db := NewDatabase("", "", "", "", "test", conn)
+
+			calls := 0
+			err := db.Transaction(func(tx *Database) error {
+				calls++
+				if calls <= tc.failures {
+					return tc.err
+				}
+				return nil
+			})
+			assert.Equal(t, tc.fails, err != nil)
+			assert.Equal(t, tc.attempts, calls)
+			assert.Equal(t, tc.attempts, conn.begun)
+		})
+	}
+}
+
+// fakeSavepoint is an open transaction, beginning in it makes a savepoint
+type fakeSavepoint struct {
+	fakeTx
+	begun int
+}
+
+func (s *fakeSavepoint) Begin(ctx context.Context) (pgx.Tx, error) {
+	s.begun++
+	return fakeTx{}, nil
+}
+
+func TestNestedTransactionIsNotRetried(t *testing.T) {
+	conn := &fakeSavepoint{}
+	db := NewDatabase("", "", "", "", "test", conn)
+
+	err := db.Transaction(func(tx *Database) error {
+		return &pgconn.PgError{Code: retryableError}
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 1, conn.begun)
+}
+
+func TestUnits(t *testing.T) {
+	testCases := []struct {
+		quantity string
+		expected int
+		ok       bool
+	}{
+		{"150", 150, true},
+		{"0", 0, true},
+		{"999999999999999999", 999999999999999999, true},
+		{"9999999999999999999", 0, false},
+		{"99999999999999999999", 0, false},
+		{"", 0, false},
+		{"QUANTITY", 0, false},
+		{"1.5", 0, false},
+		{"-1", 0, false},
+		{"+1", 0, false},
+	}
+
+	for _, tc := range testCases {
+		n, ok := Units(tc.quantity)
+		assert.Equal(t, tc.ok, ok, tc.quantity)
+		assert.Equal(t, tc.expected, n, tc.quantity)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Like main_test.go these tests need the server running on :8888
+
+const serverURL = "http://localhost:8888"
+
+// Sends a request to the running server and returns the status and body
+func send(t *testing.T, method, path string, body any) (int, []byte) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		require.NoError(t, err)
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, serverURL+path, reader)
+	require.NoError(t, err)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, data
+}
+
+// Adds a product with an ID no other test uses
+func createProduct(t *testing.T, name, price, quantity string) string {
+	id := fmt.Sprintf("test-%d", time.Now().UnixNano())
+	status, body := send(t, http.MethodPost, "/products", map[string]string{"id": id, "name": name, "price": price, "quantity": quantity})
+	require.Equal(t, http.StatusCreated, status, string(body))
+	return id
+}
+
+func TestDeletedProduct(t *testing.T) {
+	id := createProduct(t, "Deleted", "10.00", "5")
+
+	status, _ := send(t, http.MethodDelete, "/product?id="+id, nil)
+	require.Equal(t, http.StatusNoContent, status)
+
+	testCases := []struct {
+		name           string
+		method         string
+		path           string
+		body           any
+		expectedStatus int
+	}{
+		{name: "get by query", method: http.MethodGet, path: "/product?id=" + id, expectedStatus: http.StatusNotFound},
+		{name: "get by path", method: http.MethodGet, path: "/products/" + id, expectedStatus: http.StatusNotFound},
+		{name: "get including deleted", method: http.MethodGet, path: "/products/" + id + "?include_deleted=true", expectedStatus: http.StatusOK},
+		{name: "update", method: http.MethodPut, path: "/product?id=" + id, body: map[string]string{"name": "Updated"}, expectedStatus: http.StatusNotFound},
+		{name: "delete again", method: http.MethodDelete, path: "/product?id=" + id, expectedStatus: http.StatusNotFound},
+		{name: "add with the same ID", method: http.MethodPost, path: "/products", body: map[string]string{"id": id, "name": "Again", "price": "1.00", "quantity": "1"}, expectedStatus: http.StatusConflict},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			status, body := send(t, tc.method, tc.path, tc.body)
+			assert.Equal(t, tc.expectedStatus, status, string(body))
+		})
+	}
+}
+
+func TestRestoreProduct(t *testing.T) {
+	id := createProduct(t, "Restored", "10.00", "5")
+
+	status, _ := send(t, http.MethodPost, "/products/"+id+"/restore", nil)
+	assert.Equal(t, http.StatusNotFound, status, "a product that is not deleted cannot be restored")
+
+	status, _ = send(t, http.MethodDelete, "/product?id="+id, nil)
+	require.Equal(t, http.StatusNoContent, status)
+
+	status, body := send(t, http.MethodPost, "/products/"+id+"/restore", nil)
+	require.Equal(t, http.StatusOK, status, string(body))
+
+	status, body = send(t, http.MethodGet, "/products/"+id, nil)
+	require.Equal(t, http.StatusOK, status)
+	var p Product
+	require.NoError(t, json.Unmarshal(body, &p))
+	assert.Equal(t, "Restored", p.Name)
+	assert.Nil(t, p.DeletedAt)
+
+	status, _ = send(t, http.MethodPost, "/products/does-not-exist/restore", nil)
+	assert.Equal(t, http.StatusNotFound, status)
+}
+
+func TestProductAsOf(t *testing.T) {
+	id := createProduct(t, "Before", "10.00", "5")
+
+	status, _ := send(t, http.MethodPut, "/product?id="+id, map[string]string{"name": "After"})
+	require.Equal(t, http.StatusOK, status)
+	status, _ = send(t, http.MethodDelete, "/product?id="+id, nil)
+	require.Equal(t, http.StatusNoContent, status)
+
+	// the times of the changes as the server recorded them
+	status, body := send(t, http.MethodGet, "/products/"+id+"/history", nil)
+	require.Equal(t, http.StatusOK, status)
+	var history []ProductHistory
+	require.NoError(t, json.Unmarshal(body, &history))
+	changed := map[string]time.Time{}
+	for _, h := range history {
+		changed[h.Operation] = h.ChangedAt
+	}
+	require.Contains(t, changed, "create")
+	require.Contains(t, changed, "update")
+	require.Contains(t, changed, "delete")
+
+	testCases := []struct {
+		name           string
+		at             time.Time
+		includeDeleted bool
+		expectedStatus int
+		expectedName   string
+	}{
+		{name: "before it was created", at: changed["create"].Add(-time.Millisecond), expectedStatus: http.StatusNotFound},
+		{name: "after it was created", at: changed["create"], expectedStatus: http.StatusOK, expectedName: "Before"},
+		{name: "after it was updated", at: changed["update"], expectedStatus: http.StatusOK, expectedName: "After"},
+		{name: "after it was deleted", at: changed["delete"], expectedStatus: http.StatusNotFound},
+		{name: "after it was deleted, including deleted", at: changed["delete"], includeDeleted: true, expectedStatus: http.StatusOK, expectedName: "After"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := fmt.Sprintf("/products/%s?as_of=%s&include_deleted=%t", id, url.QueryEscape(tc.at.Format(time.RFC3339Nano)), tc.includeDeleted)
+			status, body := send(t, http.MethodGet, path, nil)
+			assert.Equal(t, tc.expectedStatus, status, string(body))
+
+			if tc.expectedStatus == http.StatusOK {
+				var p Product
+				require.NoError(t, json.Unmarshal(body, &p))
+				assert.Equal(t, tc.expectedName, p.Name)
+			}
+		})
+	}
+
+	status, _ = send(t, http.MethodGet, "/products/"+id+"?as_of=yesterday", nil)
+	assert.Equal(t, http.StatusBadRequest, status)
+}
+
+func TestUpdateProductQuantity(t *testing.T) {
+	id := createProduct(t, "Counted", "10.00", "5")
+
+	status, body := send(t, http.MethodPost, "/reservations", map[string]any{"items": []map[string]any{{"product_id": id, "quantity": 3}}})
+	require.Equal(t, http.StatusCreated, status, string(body))
+
+	testCases := []struct {
+		name           string
+		quantity       string
+		expectedStatus int
+	}{
+		{"not a number", "many", http.StatusBadRequest},
+		{"fraction", "2.5", http.StatusBadRequest},
+		{"below reserved", "2", http.StatusConflict},
+		{"reserved", "3", http.StatusOK},
+		{"more", "8", http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			status, body := send(t, http.MethodPut, "/product?id="+id, map[string]string{"quantity": tc.quantity})
+			assert.Equal(t, tc.expectedStatus, status, string(body))
+		})
+	}
+
+	status, body = send(t, http.MethodGet, "/products/"+id+"/stock-movements", nil)
+	require.Equal(t, http.StatusOK, status, string(body))
+	var movements []struct {
+		Type     string `json:"type"`
+		Quantity int    `json:"quantity"`
+		Balance  int    `json:"balance"`
+	}
+	require.NoError(t, json.Unmarshal(body, &movements))
+	require.NotEmpty(t, movements)
+	assert.Equal(t, "adjustment", movements[0].Type)
+	assert.Equal(t, 5, movements[0].Quantity)
+	assert.Equal(t, 8, movements[0].Balance)
+}
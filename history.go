@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"rest/db"
+	"rest/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// A snapshot of a product taken after every change
+type ProductHistory struct {
+	Operation string     `json:"operation"`
+	ChangedAt time.Time  `json:"changed_at"`
+	Name      string     `json:"name"`
+	Price     string     `json:"price"`
+	Quantity  string     `json:"quantity"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+// Copies the current state of a product into its history, must be called in
+// the same transaction as the change itself
+func recordHistory(tx *db.Database, id, operation string) error {
+	return tx.ExecQuery(
+		"INSERT INTO product_history (product_id, operation, name, price, quantity, deleted_at) SELECT id, $2, name, price, quantity, deleted_at FROM products WHERE id = $1",
+		id, operation,
+	)
+}
+
+// Lists the changes of a product, newest first
+func getProductHistory(database *db.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		database := database.WithContext(c.Request.Context())
+
+		id := c.Param("id")
+		limit, offset := utils.Pagination(c)
+
+		var total int
+		err := database.QueryRow("SELECT COUNT(*) FROM product_history WHERE product_id = $1", id).Scan(&total)
+		if err != nil {
+			utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if total == 0 {
+			utils.ErrorResponse(c, http.StatusNotFound, "No history for this product")
+			return
+		}
+
+		// Check if the offset is out of range
+		if offset >= total {
+			utils.ErrorResponse(c, http.StatusNotFound, "Page out of range")
+			return
+		}
+
+		rows, err := database.Query(
+			"SELECT operation, changed_at, name, price, quantity, deleted_at FROM product_history WHERE product_id = $1 ORDER BY changed_at DESC, seq DESC LIMIT $2 OFFSET $3",
+			id, limit, offset,
+		)
+		if err != nil {
+			utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		defer rows.Close()
+
+		history := []ProductHistory{}
+		for rows.Next() {
+			var h ProductHistory
+			if err := rows.Scan(&h.Operation, &h.ChangedAt, &h.Name, &h.Price, &h.Quantity, &h.DeletedAt); err != nil {
+				utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+				return
+			}
+			history = append(history, h)
+		}
+
+		c.JSON(http.StatusOK, history)
+	}
+}
+
+// Answers GET /products/:id?as_of=<RFC 3339 timestamp> from the product history.
+// AS OF SYSTEM TIME is not used because CockroachDB only keeps old row
+// versions until gc.ttlseconds has passed, a few hours by default, and the
+// history has to outlive that.
+func getProductAsOf(c *gin.Context, database *db.Database, id, asOf string) {
+	at, err := time.Parse(time.RFC3339, asOf)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "as_of must be an RFC 3339 timestamp")
+		return
+	}
+
+	var p Product
+	err = database.QueryRow(
+		"SELECT product_id, name, price, quantity, deleted_at FROM product_history WHERE product_id = $1 AND changed_at <= $2 ORDER BY changed_at DESC, seq DESC LIMIT 1",
+		id, at,
+	).Scan(&p.ID, &p.Name, &p.Price, &p.Quantity, &p.DeletedAt)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && p.DeletedAt != nil && !includeDeleted(c)) {
+		utils.ErrorResponse(c, http.StatusNotFound, "Product did not exist at this time")
+		return
+	}
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, p)
+}
@@ -0,0 +1,184 @@
+package idempotency
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"rest/audit"
+	"rest/logging"
+	"rest/problem"
+
+	"github.com/gin-gonic/gin"
+)
+
+const Header = "Idempotency-Key"
+
+// Returned by Store.Claim when the key is already held by another request
+var ErrExists = errors.New("idempotency key already exists")
+
+// Returned by Store.Get when the key is not held, or expired
+var ErrNotFound = errors.New("idempotency key not found")
+
+// Record is the stored outcome of the first request made with a key
+type Record struct {
+	Key         string
+	Fingerprint string
+	Completed   bool
+	Status      int
+	ContentType string
+	Body        []byte
+	ExpiresAt   time.Time
+}
+
+type Store interface {
+	// Claim reserves the key for a new request, returning ErrExists if it is taken
+	Claim(key, fingerprint string, expiresAt time.Time) error
+	// Get returns ErrNotFound when the key is not held
+	Get(key string) (Record, error)
+	Complete(key string, status int, contentType string, body []byte) error
+	Release(key string) error
+}
+
+// Captures the response so it can be stored after the handler runs
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Hashes the parts of the request that must match on a replay
+func fingerprint(c *gin.Context, body []byte) string {
+	h := sha256.New()
+	// the path rather than the route, so the same body sent to two
+	// resources under one key is not taken for a retry
+	h.Write([]byte(c.Request.Method + " " + c.Request.URL.Path + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Keys are stored per caller, so one client cannot replay or block the
+// requests of another by guessing its keys. Without authentication every
+// caller is anonymous, so the client IP stands in for the caller.
+func scope(c *gin.Context, key string) string {
+	if actor := c.GetString(audit.ActorKey); actor != "" {
+		return actor + "\n" + key
+	}
+	return "ip:" + c.ClientIP() + "\n" + key
+}
+
+// Middleware makes the wrapped route safe to retry when the client sends an
+// Idempotency-Key header. Requests without the header pass through untouched.
+func Middleware(store Store, ttl time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(Header)
+		if key == "" {
+			c.Next()
+			return
+		}
+
+		if len(key) > 255 {
+			problem.Abort(c, http.StatusBadRequest, "idempotency-key-invalid", "Idempotency-Key must be at most 255 characters")
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			problem.Abort(c, http.StatusBadRequest, "invalid-body", err.Error())
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		fp := fingerprint(c, body)
+		key = scope(c, key)
+
+		// the key can be released or expire between Claim and Get, then it
+		// is claimed once more
+		expiresAt := time.Now().Add(ttl)
+		err = store.Claim(key, fp, expiresAt)
+		if errors.Is(err, ErrExists) && replay(c, store, key, fp) {
+			return
+		}
+		if errors.Is(err, ErrExists) {
+			err = store.Claim(key, fp, expiresAt)
+		}
+		if errors.Is(err, ErrExists) {
+			problem.Abort(c, http.StatusConflict, "idempotency-request-in-progress", "A request with this Idempotency-Key is still being processed")
+			return
+		}
+		if err != nil {
+			problem.Abort(c, http.StatusInternalServerError, "idempotency-store-error", err.Error())
+			return
+		}
+
+		// a panicking handler must not leave the key claimed until it expires
+		defer func() {
+			if p := recover(); p != nil {
+				release(c, store, key)
+				panic(p)
+			}
+		}()
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+
+		// Server errors are not cached so the client can retry them
+		if recorder.Status() >= http.StatusInternalServerError {
+			release(c, store, key)
+			return
+		}
+
+		err = store.Complete(key, recorder.Status(), recorder.Header().Get("Content-Type"), recorder.body.Bytes())
+		if err != nil {
+			// the key stays claimed, retries get 409 until it expires
+			logging.FromGin(c).Error("failed to store idempotent response", "error", err)
+		}
+	}
+}
+
+func release(c *gin.Context, store Store, key string) {
+	if err := store.Release(key); err != nil {
+		logging.FromGin(c).Error("failed to release idempotency key", "error", err)
+	}
+}
+
+// Answers a request whose key has been seen before, unless the key is gone
+// by now
+func replay(c *gin.Context, store Store, key, fp string) bool {
+	record, err := store.Get(key)
+	if errors.Is(err, ErrNotFound) {
+		return false
+	}
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "idempotency-store-error", err.Error())
+		return true
+	}
+
+	if record.Fingerprint != fp {
+		problem.Abort(c, http.StatusUnprocessableEntity, "idempotency-key-reused", "Idempotency-Key was already used with a different request")
+		return true
+	}
+
+	if !record.Completed {
+		problem.Abort(c, http.StatusConflict, "idempotency-request-in-progress", "A request with this Idempotency-Key is still being processed")
+		return true
+	}
+
+	c.Header("Idempotent-Replayed", "true")
+	c.Data(record.Status, record.ContentType, record.Body)
+	c.Abort()
+	return true
+}
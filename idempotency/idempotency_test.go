@@ -0,0 +1,263 @@
+package idempotency
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"rest/audit"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+type memoryStore struct {
+	mu      sync.Mutex
+	records map[string]Record
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{records: map[string]Record{}}
+}
+
+func (s *memoryStore) Claim(key, fingerprint string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r, ok := s.records[key]; ok && r.ExpiresAt.After(time.Now()) {
+		return ErrExists
+	}
+	s.records[key] = Record{Key: key, Fingerprint: fingerprint, ExpiresAt: expiresAt}
+	return nil
+}
+
+func (s *memoryStore) Get(key string) (Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.records[key]
+	if !ok || !r.ExpiresAt.After(time.Now()) {
+		return Record{}, ErrNotFound
+	}
+	return r, nil
+}
+
+func (s *memoryStore) Complete(key string, status int, contentType string, body []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := s.records[key]
+	r.Completed, r.Status, r.ContentType, r.Body = true, status, contentType, body
+	s.records[key] = r
+	return nil
+}
+
+func (s *memoryStore) Release(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.records, key)
+	return nil
+}
+
+func newRouter(store Store, handler gin.HandlerFunc) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/products", Middleware(store, time.Hour), handler)
+	return r
+}
+
+func post(r *gin.Engine, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/products", bytes.NewBufferString(body))
+	if key != "" {
+		req.Header.Set(Header, key)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestReplayReturnsStoredResponse(t *testing.T) {
+	calls := 0
+	r := newRouter(newMemoryStore(), func(c *gin.Context) {
+		calls++
+		c.JSON(http.StatusCreated, gin.H{"id": "1"})
+	})
+
+	first := post(r, "abc", `{"id":"1"}`)
+	second := post(r, "abc", `{"id":"1"}`)
+
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, http.StatusCreated, second.Code)
+	assert.Equal(t, first.Body.String(), second.Body.String())
+	assert.Equal(t, "true", second.Header().Get("Idempotent-Replayed"))
+}
+
+func TestKeyReusedWithDifferentBody(t *testing.T) {
+	r := newRouter(newMemoryStore(), func(c *gin.Context) {
+		c.JSON(http.StatusCreated, gin.H{})
+	})
+
+	post(r, "abc", `{"id":"1"}`)
+	w := post(r, "abc", `{"id":"2"}`)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), "idempotency-key-reused")
+}
+
+func TestConcurrentDuplicateConflicts(t *testing.T) {
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	r := newRouter(newMemoryStore(), func(c *gin.Context) {
+		close(entered)
+		<-release
+		c.JSON(http.StatusCreated, gin.H{})
+	})
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- post(r, "abc", `{}`) }()
+	<-entered
+
+	w := post(r, "abc", `{}`)
+	close(release)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), "idempotency-request-in-progress")
+	assert.Equal(t, http.StatusCreated, (<-done).Code)
+}
+
+// releasingStore lets the key go right after a claim of it failed
+type releasingStore struct {
+	*memoryStore
+}
+
+func (s releasingStore) Get(key string) (Record, error) {
+	s.Release(key)
+	return s.memoryStore.Get(key)
+}
+
+func TestKeyReleasedBeforeReplayIsClaimedAgain(t *testing.T) {
+	calls := 0
+	r := newRouter(releasingStore{newMemoryStore()}, func(c *gin.Context) {
+		calls++
+		c.JSON(http.StatusCreated, gin.H{})
+	})
+
+	post(r, "abc", `{}`)
+	w := post(r, "abc", `{}`)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Empty(t, w.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, 2, calls)
+}
+
+func TestServerErrorReleasesKey(t *testing.T) {
+	calls := 0
+	r := newRouter(newMemoryStore(), func(c *gin.Context) {
+		calls++
+		c.JSON(http.StatusInternalServerError, gin.H{})
+	})
+
+	post(r, "abc", `{}`)
+	post(r, "abc", `{}`)
+
+	assert.Equal(t, 2, calls)
+}
+
+func TestRequestWithoutKeyPassesThrough(t *testing.T) {
+	calls := 0
+	r := newRouter(newMemoryStore(), func(c *gin.Context) {
+		calls++
+		c.JSON(http.StatusCreated, gin.H{})
+	})
+
+	post(r, "", `{}`)
+	post(r, "", `{}`)
+
+	assert.Equal(t, 2, calls)
+}
+
+func TestKeysAreScopedToTheCaller(t *testing.T) {
+	calls := 0
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/products", func(c *gin.Context) {
+		c.Set(audit.ActorKey, c.GetHeader("X-Actor"))
+	}, Middleware(newMemoryStore(), time.Hour), func(c *gin.Context) {
+		calls++
+		c.JSON(http.StatusCreated, gin.H{"actor": c.GetString(audit.ActorKey)})
+	})
+
+	for _, actor := range []string{"apikey:1", "apikey:2"} {
+		req := httptest.NewRequest(http.MethodPost, "/products", bytes.NewBufferString(`{}`))
+		req.Header.Set(Header, "abc")
+		req.Header.Set("X-Actor", actor)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Empty(t, w.Header().Get("Idempotent-Replayed"))
+	}
+	assert.Equal(t, 2, calls)
+}
+
+func TestAnonymousKeysAreScopedToTheClientIP(t *testing.T) {
+	calls := 0
+	r := newRouter(newMemoryStore(), func(c *gin.Context) {
+		calls++
+		c.JSON(http.StatusCreated, gin.H{})
+	})
+
+	for _, addr := range []string{"192.0.2.1:1234", "192.0.2.2:1234", "192.0.2.1:5678"} {
+		req := httptest.NewRequest(http.MethodPost, "/products", bytes.NewBufferString(`{}`))
+		req.RemoteAddr = addr
+		req.Header.Set(Header, "abc")
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	assert.Equal(t, 2, calls)
+}
+
+func TestKeyReusedOnAnotherResource(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/products/:id/stock-movements", Middleware(newMemoryStore(), time.Hour), func(c *gin.Context) {
+		c.JSON(http.StatusCreated, gin.H{"product_id": c.Param("id")})
+	})
+
+	send := func(path string) int {
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(`{"type":"receipt","quantity":1}`))
+		req.Header.Set(Header, "abc")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusCreated, send("/products/1/stock-movements"))
+	assert.Equal(t, http.StatusUnprocessableEntity, send("/products/2/stock-movements"))
+}
+
+func TestPanicReleasesKey(t *testing.T) {
+	store := newMemoryStore()
+	panics := true
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(gin.CustomRecoveryWithWriter(io.Discard, func(c *gin.Context, err any) {
+		c.AbortWithStatus(http.StatusInternalServerError)
+	}))
+	r.POST("/products", Middleware(store, time.Hour), func(c *gin.Context) {
+		if panics {
+			panic("boom")
+		}
+		c.JSON(http.StatusCreated, gin.H{})
+	})
+
+	assert.Equal(t, http.StatusInternalServerError, post(r, "abc", `{}`).Code)
+	assert.Empty(t, store.records)
+
+	panics = false
+	assert.Equal(t, http.StatusCreated, post(r, "abc", `{}`).Code)
+}
@@ -0,0 +1,67 @@
+package idempotency
+
+import (
+	"errors"
+	"time"
+
+	"rest/db"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// DBStore keeps idempotency records in the idempotency_keys table
+type DBStore struct {
+	db *db.Database
+}
+
+func NewDBStore(db *db.Database) *DBStore {
+	return &DBStore{db}
+}
+
+func (s *DBStore) Claim(key, fingerprint string, expiresAt time.Time) error {
+	// An expired key is free to be used again
+	err := s.db.ExecQuery("DELETE FROM idempotency_keys WHERE key = $1 AND expires_at < now()", key)
+	if err != nil {
+		return err
+	}
+
+	var claimed string
+	err = s.db.QueryRow(
+		"INSERT INTO idempotency_keys (key, fingerprint, completed, expires_at) VALUES ($1, $2, false, $3) ON CONFLICT (key) DO NOTHING RETURNING key",
+		key, fingerprint, expiresAt,
+	).Scan(&claimed)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrExists
+	}
+
+	return err
+}
+
+func (s *DBStore) Get(key string) (Record, error) {
+	var r Record
+	err := s.db.QueryRow(
+		"SELECT key, fingerprint, completed, status, content_type, body, expires_at FROM idempotency_keys WHERE key = $1 AND expires_at >= now()",
+		key,
+	).Scan(&r.Key, &r.Fingerprint, &r.Completed, &r.Status, &r.ContentType, &r.Body, &r.ExpiresAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return r, ErrNotFound
+	}
+
+	return r, err
+}
+
+func (s *DBStore) Complete(key string, status int, contentType string, body []byte) error {
+	return s.db.ExecQuery(
+		"UPDATE idempotency_keys SET completed = true, status = $1, content_type = $2, body = $3 WHERE key = $4",
+		status, contentType, body, key,
+	)
+}
+
+func (s *DBStore) Release(key string) error {
+	return s.db.ExecQuery("DELETE FROM idempotency_keys WHERE key = $1", key)
+}
+
+// Removes every record whose TTL has passed
+func (s *DBStore) Purge() error {
+	return s.db.ExecQuery("DELETE FROM idempotency_keys WHERE expires_at < now()")
+}
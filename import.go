@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"rest/audit"
+	"rest/db"
+	"rest/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// maxImport is how many products one import request may carry
+const maxImport = 1000
+
+const foreignKeyViolation = "23503"
+
+// The outcome of one product of an import
+type ImportResult struct {
+	ID     string `json:"id"`
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Handles POST /products/import, creating many products at once. Unlike
+// POST /products it also takes their category and reorder threshold. Every
+// product is added in its own transaction, so one that fails does not stop
+// the rest, and the response lists the outcome of each.
+func importProducts(database *db.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		database := database.WithContext(c.Request.Context())
+
+		var input struct {
+			Products []Product `json:"products"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			utils.ErrorResponse(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		if len(input.Products) == 0 || len(input.Products) > maxImport {
+			utils.ErrorResponse(c, http.StatusBadRequest, fmt.Sprintf("products must hold between 1 and %d products", maxImport))
+			return
+		}
+
+		results := make([]ImportResult, len(input.Products))
+		var imported []Product
+		for i, product := range input.Products {
+			product.DeletedAt = nil
+			results[i] = ImportResult{ID: product.ID, Status: http.StatusCreated}
+
+			if product.ID == "" || product.Name == "" || product.Price == "" || product.Quantity == "" {
+				results[i].Status, results[i].Error = http.StatusBadRequest, "Empty field"
+				continue
+			}
+			if product.ReorderThreshold != nil && *product.ReorderThreshold < 0 {
+				results[i].Status, results[i].Error = http.StatusBadRequest, "reorder_threshold must not be negative"
+				continue
+			}
+
+			err := database.Transaction(func(tx *db.Database) error {
+				return insertProduct(tx, product, audit.Actor(c))
+			})
+			var pgErr *pgconn.PgError
+			switch {
+			case errors.As(err, &pgErr) && pgErr.Code == uniqueViolation:
+				results[i].Status, results[i].Error = http.StatusConflict, "A product with this ID already exists"
+			case errors.As(err, &pgErr) && pgErr.Code == foreignKeyViolation:
+				results[i].Status, results[i].Error = http.StatusUnprocessableEntity, "Category does not exist"
+			case err != nil:
+				results[i].Status, results[i].Error = http.StatusInternalServerError, err.Error()
+			default:
+				imported = append(imported, product)
+			}
+		}
+
+		audit.Record(c, "product", "", nil, imported)
+		c.JSON(http.StatusOK, gin.H{"imported": len(imported), "results": results})
+	}
+}
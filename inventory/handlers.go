@@ -0,0 +1,186 @@
+package inventory
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"rest/audit"
+	"rest/db"
+	"rest/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+type movementInput struct {
+	Type      string  `json:"type"`
+	Quantity  int     `json:"quantity"`
+	Reason    string  `json:"reason"`
+	Reference *string `json:"reference"`
+}
+
+// ErrorStatus is the response status for an error of this package
+func ErrorStatus(err error) int {
+	var insufficient *InsufficientStockError
+	var shortages Shortages
+	switch {
+	case errors.Is(err, ErrProductNotFound), errors.Is(err, ErrReservationNotFound):
+		return http.StatusNotFound
+	case errors.As(err, &insufficient), errors.As(err, &shortages), errors.Is(err, ErrDuplicateReference), errors.Is(err, ErrNotActive), errors.Is(err, ErrBelowReserved):
+		return http.StatusConflict
+	case errors.Is(err, ErrUntracked):
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
+
+func respondError(c *gin.Context, err error) {
+	utils.ErrorResponse(c, ErrorStatus(err), err.Error())
+}
+
+// CreateMovement handles POST /products/:id/stock-movements
+func CreateMovement(database *db.Database, ledger *Ledger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		database := database.WithContext(c.Request.Context())
+
+		var input movementInput
+		if err := c.ShouldBindJSON(&input); err != nil {
+			utils.ErrorResponse(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		change, err := Change(input.Type, input.Quantity)
+		if err != nil {
+			utils.ErrorResponse(c, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		m := Movement{
+			ProductID: c.Param("id"),
+			Type:      input.Type,
+			Quantity:  change,
+			Reason:    strings.TrimSpace(input.Reason),
+			Reference: input.Reference,
+			Actor:     audit.Actor(c),
+		}
+		err = database.Transaction(func(tx *db.Database) error {
+			// a retried attempt applies the movement from the request again
+			applied, err := ledger.Apply(tx, m)
+			if err != nil {
+				return err
+			}
+			m = applied
+			return nil
+		})
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		audit.Record(c, "stock_movement", m.ID, nil, m)
+		c.JSON(http.StatusCreated, m)
+	}
+}
+
+// ListMovements handles GET /products/:id/stock-movements, newest first,
+// optionally filtered by type and time
+func ListMovements(database *db.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		database := database.WithContext(c.Request.Context())
+
+		id := c.Param("id")
+		var exists bool
+		if err := database.QueryRow("SELECT EXISTS (SELECT 1 FROM products WHERE id = $1)", id).Scan(&exists); err != nil {
+			utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if !exists {
+			utils.ErrorResponse(c, http.StatusNotFound, ErrProductNotFound.Error())
+			return
+		}
+
+		conditions := []string{"product_id = $1"}
+		values := []any{id}
+		for _, filter := range []struct{ param, condition string }{
+			{"type", "type = $%d"},
+			{"from", "created_at >= $%d"},
+			{"to", "created_at < $%d"},
+		} {
+			if value := c.Query(filter.param); value != "" {
+				values = append(values, value)
+				conditions = append(conditions, fmt.Sprintf(filter.condition, len(values)))
+			}
+		}
+
+		limit, offset := utils.Pagination(c)
+		values = append(values, limit, offset)
+		rows, err := database.Query(
+			fmt.Sprintf("SELECT %s FROM stock_movements WHERE %s ORDER BY created_at DESC, id LIMIT $%d OFFSET $%d",
+				movementColumns, strings.Join(conditions, " AND "), len(values)-1, len(values)),
+			values...,
+		)
+		if err != nil {
+			utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		defer rows.Close()
+
+		movements := []Movement{}
+		for rows.Next() {
+			m, err := scanMovement(rows)
+			if err != nil {
+				utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+				return
+			}
+			movements = append(movements, m)
+		}
+
+		c.JSON(http.StatusOK, movements)
+	}
+}
+
+// Stock compares the quantity of a product with its ledger and shows how
+// much of it is held by active reservations
+type Stock struct {
+	ProductID  string `json:"product_id"`
+	Quantity   string `json:"quantity"`
+	Ledger     int    `json:"ledger"`
+	Reconciled bool   `json:"reconciled"`
+	Reserved   int    `json:"reserved"`
+	Available  int    `json:"available"`
+}
+
+// GetStock handles GET /products/:id/stock
+func GetStock(database *db.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		database := database.WithContext(c.Request.Context())
+
+		stock := Stock{ProductID: c.Param("id")}
+		err := database.QueryRow(
+			"SELECT p.quantity, COALESCE((SELECT SUM(m.quantity) FROM stock_movements m WHERE m.product_id = p.id), 0) FROM products p WHERE p.id = $1 AND p.deleted_at IS NULL",
+			stock.ProductID,
+		).Scan(&stock.Quantity, &stock.Ledger)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				err = ErrProductNotFound
+			}
+			respondError(c, err)
+			return
+		}
+
+		reserved, err := Reserved(database, []string{stock.ProductID})
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		stock.Reserved = reserved[stock.ProductID]
+
+		quantity, ok := parseQuantity(stock.Quantity)
+		stock.Reconciled = ok && quantity == stock.Ledger
+		if ok {
+			stock.Available = max(quantity-stock.Reserved, 0)
+		}
+		c.JSON(http.StatusOK, stock)
+	}
+}
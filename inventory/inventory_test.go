@@ -0,0 +1,244 @@
+package inventory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"rest/db"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChange(t *testing.T) {
+	testCases := []struct {
+		name     string
+		typ      string
+		quantity int
+		expected int
+		valid    bool
+	}{
+		{"receipt", Receipt, 10, 10, true},
+		{"return", Return, 2, 2, true},
+		{"sale", Sale, 3, -3, true},
+		{"adjustment up", Adjustment, 5, 5, true},
+		{"adjustment down", Adjustment, -5, -5, true},
+		{"zero receipt", Receipt, 0, 0, false},
+		{"negative sale", Sale, -3, 0, false},
+		{"zero adjustment", Adjustment, 0, 0, false},
+		{"unknown type", "theft", 1, 0, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			change, err := Change(tc.typ, tc.quantity)
+			if !tc.valid {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, change)
+		})
+	}
+}
+
+func TestParseQuantity(t *testing.T) {
+	n, ok := parseQuantity("150")
+	assert.True(t, ok)
+	assert.Equal(t, 150, n)
+
+	for _, quantity := range []string{"", "QUANTITY", "1.5", "-1", "+1", "99999999999999999999"} {
+		_, ok := parseQuantity(quantity)
+		assert.False(t, ok, quantity)
+	}
+}
+
+func TestInsufficientStockError(t *testing.T) {
+	err := &InsufficientStockError{ProductID: "1", Available: 2, Requested: 5}
+	assert.Equal(t, "Insufficient stock for product 1: 2 available, 5 requested", err.Error())
+}
+
+func TestMergeItems(t *testing.T) {
+	items, err := MergeItems([]Item{{"2", 1}, {"1", 2}, {"2", 3}})
+	require.NoError(t, err)
+	assert.Equal(t, []Item{{"1", 2}, {"2", 4}}, items)
+
+	for name, items := range map[string][]Item{
+		"no items":      nil,
+		"no product":    {{"", 1}},
+		"zero quantity": {{"1", 0}},
+		"negative":      {{"1", 2}, {"2", -1}},
+	} {
+		_, err := MergeItems(items)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestShortages(t *testing.T) {
+	var err error = Shortages{
+		{ProductID: "1", Available: 0, Requested: 2},
+		{ProductID: "3", Available: 1, Requested: 5},
+	}
+	assert.Equal(t, "Insufficient stock for product 1: 0 available, 2 requested; Insufficient stock for product 3: 1 available, 5 requested", err.Error())
+
+	var shortages Shortages
+	assert.ErrorAs(t, fmt.Errorf("reserve: %w", err), &shortages)
+	assert.Len(t, shortages, 2)
+}
+
+// fakeStock is a database holding product p1, transactions work on a copy of
+// its quantity that is kept when they commit
+type fakeStock struct {
+	db.Conn
+	quantity string
+	reserved int
+	// inserts into the ledger that CockroachDB aborts with a retryable error
+	aborts int
+	begun  int
+}
+
+func (s *fakeStock) Begin(ctx context.Context) (pgx.Tx, error) {
+	s.begun++
+	return &fakeStockTx{stock: s, quantity: s.quantity}, nil
+}
+
+type fakeStockTx struct {
+	pgx.Tx
+	stock    *fakeStock
+	quantity string
+}
+
+func (tx *fakeStockTx) Commit(ctx context.Context) error {
+	tx.stock.quantity = tx.quantity
+	return nil
+}
+
+func (tx *fakeStockTx) Rollback(ctx context.Context) error { return nil }
+
+func (tx *fakeStockTx) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	switch {
+	case strings.HasPrefix(sql, "SELECT quantity FROM products"):
+		if args[0] != "p1" {
+			return fakeRow{err: pgx.ErrNoRows}
+		}
+		return fakeRow{values: []any{tx.quantity}}
+	case strings.HasPrefix(sql, "INSERT INTO stock_movements"):
+		if tx.stock.aborts > 0 {
+			tx.stock.aborts--
+			return fakeRow{err: &pgconn.PgError{Code: "40001"}}
+		}
+		return fakeRow{values: []any{"m1", args[0], args[1], args[2], args[3], args[4], args[5], args[6], time.Now()}}
+	}
+	return fakeRow{err: fmt.Errorf("unexpected query %q", sql)}
+}
+
+func (tx *fakeStockTx) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	if !strings.HasPrefix(sql, "UPDATE products SET quantity") {
+		return pgconn.CommandTag{}, fmt.Errorf("unexpected statement %q", sql)
+	}
+	tx.quantity = args[0].(string)
+	return pgconn.NewCommandTag("UPDATE 1"), nil
+}
+
+// Query only answers the units reserved for p1
+func (tx *fakeStockTx) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	rows := &fakeRows{}
+	if tx.stock.reserved > 0 {
+		rows.values = [][]any{{"p1", tx.stock.reserved}}
+	}
+	return rows, nil
+}
+
+type fakeRow struct {
+	values []any
+	err    error
+}
+
+func (r fakeRow) Scan(dest ...any) error {
+	if r.err != nil {
+		return r.err
+	}
+	for i, value := range r.values {
+		reflect.ValueOf(dest[i]).Elem().Set(reflect.ValueOf(value))
+	}
+	return nil
+}
+
+type fakeRows struct {
+	pgx.Rows
+	values [][]any
+	next   int
+}
+
+func (r *fakeRows) Next() bool {
+	r.next++
+	return r.next <= len(r.values)
+}
+
+func (r *fakeRows) Scan(dest ...any) error { return fakeRow{values: r.values[r.next-1]}.Scan(dest...) }
+func (r *fakeRows) Close()                 {}
+func (r *fakeRows) Err() error             { return nil }
+
+// Posts a stock movement for p1 and returns the response
+func postMovement(stock *fakeStock, body string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	database := db.NewDatabase("", "", "", "", "test", stock)
+	r := gin.New()
+	r.POST("/products/:id/stock-movements", CreateMovement(&database, NewLedger(nil)))
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/products/p1/stock-movements", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestCreateMovementRetried(t *testing.T) {
+	stock := &fakeStock{quantity: "10", aborts: 1}
+
+	w := postMovement(stock, `{"type": "receipt", "quantity": 5}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.Equal(t, 2, stock.begun)
+	assert.Equal(t, "15", stock.quantity)
+
+	var m Movement
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &m))
+	assert.Equal(t, "p1", m.ProductID)
+	assert.Equal(t, 5, m.Quantity)
+	assert.Equal(t, 15, m.Balance)
+}
+
+func TestMovementsLeaveReservedUnits(t *testing.T) {
+	testCases := []struct {
+		name           string
+		body           string
+		expectedStatus int
+		expectedStock  string
+	}{
+		{name: "sale of unreserved units", body: `{"type": "sale", "quantity": 6}`, expectedStatus: http.StatusCreated, expectedStock: "4"},
+		{name: "sale of reserved units", body: `{"type": "sale", "quantity": 7}`, expectedStatus: http.StatusConflict, expectedStock: "10"},
+		{name: "adjustment down to the reserved units", body: `{"type": "adjustment", "quantity": -6}`, expectedStatus: http.StatusCreated, expectedStock: "4"},
+		{name: "adjustment below the reserved units", body: `{"type": "adjustment", "quantity": -8}`, expectedStatus: http.StatusConflict, expectedStock: "10"},
+		{name: "receipt", body: `{"type": "receipt", "quantity": 1}`, expectedStatus: http.StatusCreated, expectedStock: "11"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			stock := &fakeStock{quantity: "10", reserved: 4}
+
+			w := postMovement(stock, tc.body)
+			assert.Equal(t, tc.expectedStatus, w.Code, w.Body.String())
+			assert.Equal(t, tc.expectedStock, stock.quantity)
+		})
+	}
+}
@@ -0,0 +1,231 @@
+package inventory
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"rest/db"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Types of stock movement
+const (
+	Receipt    = "receipt"
+	Sale       = "sale"
+	Return     = "return"
+	Adjustment = "adjustment"
+)
+
+var (
+	ErrProductNotFound = errors.New("Product not found")
+	// the product quantity is not a whole number, so it cannot be counted
+	ErrUntracked          = errors.New("Product quantity is not a whole number, set it with PUT /product first")
+	ErrDuplicateReference = errors.New("A stock movement with this reference already exists for the product")
+	ErrBelowReserved      = errors.New("Quantity cannot be set below the units held by active reservations")
+)
+
+// InsufficientStockError is returned when a movement would take the stock of
+// a product below zero
+type InsufficientStockError struct {
+	ProductID string
+	Available int
+	Requested int
+}
+
+func (e *InsufficientStockError) Error() string {
+	return fmt.Sprintf("Insufficient stock for product %s: %d available, %d requested", e.ProductID, e.Available, e.Requested)
+}
+
+// Movement is one entry of the stock ledger. Quantity is the signed change
+// and Balance the stock of the product after it.
+type Movement struct {
+	ID        string    `json:"id"`
+	ProductID string    `json:"product_id"`
+	Type      string    `json:"type"`
+	Quantity  int       `json:"quantity"`
+	Balance   int       `json:"balance"`
+	Reason    string    `json:"reason,omitempty"`
+	Reference *string   `json:"reference,omitempty"`
+	Actor     string    `json:"actor"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+const movementColumns = "id::STRING, product_id, type, quantity, balance, reason, reference, actor, created_at"
+
+func scanMovement(row pgx.Row) (Movement, error) {
+	var m Movement
+	err := row.Scan(&m.ID, &m.ProductID, &m.Type, &m.Quantity, &m.Balance, &m.Reason, &m.Reference, &m.Actor, &m.CreatedAt)
+	return m, err
+}
+
+// Change turns the positive quantity of a movement into the signed change of
+// stock, adjustments carry their own sign
+func Change(typ string, quantity int) (int, error) {
+	switch typ {
+	case Receipt, Return:
+		if quantity <= 0 {
+			return 0, fmt.Errorf("quantity of a %s must be positive", typ)
+		}
+		return quantity, nil
+	case Sale:
+		if quantity <= 0 {
+			return 0, fmt.Errorf("quantity of a %s must be positive", typ)
+		}
+		return -quantity, nil
+	case Adjustment:
+		if quantity == 0 {
+			return 0, errors.New("quantity of an adjustment must not be zero")
+		}
+		return quantity, nil
+	}
+	return 0, fmt.Errorf("unknown movement type %q, use receipt, sale, return or adjustment", typ)
+}
+
+// Parses a product quantity, which is stored as a string
+func parseQuantity(quantity string) (int, bool) {
+	return db.Units(quantity)
+}
+
+// ChangeFunc is called in the same transaction after the stock of a product
+// changed, to snapshot its history and check its alerts
+type ChangeFunc func(tx *db.Database, productID, operation string) error
+
+// Ledger changes product stock, every change goes through a movement
+type Ledger struct {
+	changed ChangeFunc
+}
+
+func NewLedger(changed ChangeFunc) *Ledger {
+	return &Ledger{changed: changed}
+}
+
+// Apply locks the product, checks that its stock stays at zero or above and
+// that movements taking stock out, adjustments included, leave the units of
+// active reservations alone, updates its quantity and appends the movement
+// to the ledger. It must run in a transaction, m.Quantity is the signed
+// change.
+func (l *Ledger) Apply(tx *db.Database, m Movement) (Movement, error) {
+	var quantity string
+	err := tx.QueryRow("SELECT quantity FROM products WHERE id = $1 AND deleted_at IS NULL FOR UPDATE", m.ProductID).Scan(&quantity)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return m, ErrProductNotFound
+	}
+	if err != nil {
+		return m, err
+	}
+
+	current, ok := parseQuantity(quantity)
+	if !ok {
+		return m, ErrUntracked
+	}
+	available := current
+	if m.Quantity < 0 {
+		// units held for checkout cannot be sold to someone else or written off
+		reserved, err := Reserved(tx, []string{m.ProductID})
+		if err != nil {
+			return m, err
+		}
+		available -= reserved[m.ProductID]
+	}
+	if available+m.Quantity < 0 {
+		return m, &InsufficientStockError{ProductID: m.ProductID, Available: max(available, 0), Requested: -m.Quantity}
+	}
+	m.Balance = current + m.Quantity
+
+	if err := tx.ExecQuery("UPDATE products SET quantity = $1 WHERE id = $2", strconv.Itoa(m.Balance), m.ProductID); err != nil {
+		return m, err
+	}
+	m, err = insert(tx, m)
+	if err != nil {
+		return m, err
+	}
+	if l.changed != nil {
+		err = l.changed(tx, m.ProductID, "stock")
+	}
+	return m, err
+}
+
+// Set brings the stock of a product to quantity with an adjustment through
+// Apply, as PUT /product does. The stock cannot drop below the units held by
+// active reservations. A product whose quantity is not a whole number starts
+// being tracked at quantity. It must run in a transaction.
+func (l *Ledger) Set(tx *db.Database, productID string, quantity int, reason, actor string) error {
+	var stored string
+	err := tx.QueryRow("SELECT quantity FROM products WHERE id = $1 AND deleted_at IS NULL FOR UPDATE", productID).Scan(&stored)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrProductNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	current, ok := parseQuantity(stored)
+	if !ok {
+		if err := tx.ExecQuery("UPDATE products SET quantity = $1 WHERE id = $2", strconv.Itoa(quantity), productID); err != nil {
+			return err
+		}
+		if err := Reconcile(tx, productID, strconv.Itoa(quantity), reason, actor); err != nil {
+			return err
+		}
+		if l.changed != nil {
+			return l.changed(tx, productID, "stock")
+		}
+		return nil
+	}
+	if quantity == current {
+		return nil
+	}
+
+	reserved, err := Reserved(tx, []string{productID})
+	if err != nil {
+		return err
+	}
+	if quantity < reserved[productID] {
+		return fmt.Errorf("%w: %d reserved", ErrBelowReserved, reserved[productID])
+	}
+	_, err = l.Apply(tx, Movement{ProductID: productID, Type: Adjustment, Quantity: quantity - current, Reason: reason, Actor: actor})
+	return err
+}
+
+func insert(tx *db.Database, m Movement) (Movement, error) {
+	inserted, err := scanMovement(tx.QueryRow(
+		"INSERT INTO stock_movements (product_id, type, quantity, balance, reason, reference, actor) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING "+movementColumns,
+		m.ProductID, m.Type, m.Quantity, m.Balance, m.Reason, m.Reference, m.Actor,
+	))
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return m, ErrDuplicateReference
+	}
+	if err != nil {
+		return m, err
+	}
+	return inserted, nil
+}
+
+// Reconcile brings the ledger in line with a quantity set directly, by
+// POST /products or Set, with an adjustment for the difference.
+// Quantities that are not whole numbers are not tracked and left alone.
+func Reconcile(tx *db.Database, productID, quantity, reason, actor string) error {
+	target, ok := parseQuantity(quantity)
+	if !ok {
+		return nil
+	}
+
+	balance, err := Balance(tx, productID)
+	if err != nil || balance == target {
+		return err
+	}
+	_, err = insert(tx, Movement{ProductID: productID, Type: Adjustment, Quantity: target - balance, Balance: target, Reason: reason, Actor: actor})
+	return err
+}
+
+// Balance is the stock of a product according to its ledger
+func Balance(database *db.Database, productID string) (int, error) {
+	var balance int
+	err := database.QueryRow("SELECT COALESCE(SUM(quantity), 0) FROM stock_movements WHERE product_id = $1", productID).Scan(&balance)
+	return balance, err
+}
@@ -0,0 +1,373 @@
+package inventory
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"rest/audit"
+	"rest/db"
+	"rest/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// Reservation states, only active reservations hold stock
+const (
+	Active    = "active"
+	Confirmed = "confirmed"
+	Released  = "released"
+	Expired   = "expired"
+)
+
+// DefaultTTL is how long a reservation holds stock unless it asks otherwise
+const DefaultTTL = 15 * time.Minute
+
+// MaxTTL is the longest a reservation may hold stock
+const MaxTTL = 24 * time.Hour
+
+var (
+	ErrReservationNotFound = errors.New("Reservation not found")
+	ErrNotActive           = errors.New("Reservation is no longer active")
+)
+
+// Shortages lists every product without enough stock for a request
+type Shortages []*InsufficientStockError
+
+func (s Shortages) Error() string {
+	messages := make([]string, len(s))
+	for i, e := range s {
+		messages[i] = e.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+type Item struct {
+	ProductID string `json:"product_id"`
+	Quantity  int    `json:"quantity"`
+}
+
+// Reservation holds units of one or more products while a customer pays
+type Reservation struct {
+	ID        string    `json:"id"`
+	Status    string    `json:"status"`
+	Items     []Item    `json:"items"`
+	Reference *string   `json:"reference,omitempty"`
+	Actor     string    `json:"actor"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type reservationInput struct {
+	Items      []Item  `json:"items"`
+	TTLSeconds int     `json:"ttl_seconds"`
+	Reference  *string `json:"reference"`
+}
+
+// MergeItems adds up items of the same product and sorts them, so products
+// are always locked in the same order and two transactions cannot deadlock
+func MergeItems(items []Item) ([]Item, error) {
+	if len(items) == 0 {
+		return nil, errors.New("at least one item is required")
+	}
+
+	quantities := map[string]int{}
+	for _, item := range items {
+		if item.ProductID == "" {
+			return nil, errors.New("every item needs a product_id")
+		}
+		if item.Quantity <= 0 {
+			return nil, fmt.Errorf("quantity of product %s must be positive", item.ProductID)
+		}
+		quantities[item.ProductID] += item.Quantity
+	}
+
+	merged := make([]Item, 0, len(quantities))
+	for id, quantity := range quantities {
+		merged = append(merged, Item{ProductID: id, Quantity: quantity})
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].ProductID < merged[j].ProductID })
+	return merged, nil
+}
+
+// Reserved sums the units held by active reservations for each product
+func Reserved(database *db.Database, productIDs []string) (map[string]int, error) {
+	rows, err := database.Query(
+		"SELECT i.product_id, SUM(i.quantity) FROM reservation_items i JOIN reservations r ON r.id = i.reservation_id "+
+			"WHERE r.status = 'active' AND r.expires_at > now() AND i.product_id = ANY($1) GROUP BY i.product_id",
+		productIDs,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reserved := map[string]int{}
+	for rows.Next() {
+		var id string
+		var quantity int
+		if err := rows.Scan(&id, &quantity); err != nil {
+			return nil, err
+		}
+		reserved[id] = quantity
+	}
+	return reserved, rows.Err()
+}
+
+// Reserve locks the products and holds the items if every one of them is
+// available, it must run in a transaction
+func Reserve(tx *db.Database, r Reservation) (Reservation, error) {
+	ids := make([]string, len(r.Items))
+	for i, item := range r.Items {
+		ids[i] = item.ProductID
+	}
+
+	rows, err := tx.Query("SELECT id, quantity FROM products WHERE id = ANY($1) AND deleted_at IS NULL ORDER BY id FOR UPDATE", ids)
+	if err != nil {
+		return r, err
+	}
+	onHand := map[string]string{}
+	for rows.Next() {
+		var id, quantity string
+		if err := rows.Scan(&id, &quantity); err != nil {
+			rows.Close()
+			return r, err
+		}
+		onHand[id] = quantity
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return r, err
+	}
+
+	reserved, err := Reserved(tx, ids)
+	if err != nil {
+		return r, err
+	}
+
+	var shortages Shortages
+	for _, item := range r.Items {
+		quantity, found := onHand[item.ProductID]
+		if !found {
+			return r, fmt.Errorf("%w: %s", ErrProductNotFound, item.ProductID)
+		}
+		current, ok := parseQuantity(quantity)
+		if !ok {
+			return r, fmt.Errorf("%w: %s", ErrUntracked, item.ProductID)
+		}
+		if available := current - reserved[item.ProductID]; available < item.Quantity {
+			shortages = append(shortages, &InsufficientStockError{ProductID: item.ProductID, Available: max(available, 0), Requested: item.Quantity})
+		}
+	}
+	if len(shortages) > 0 {
+		return r, shortages
+	}
+
+	err = tx.QueryRow(
+		"INSERT INTO reservations (status, reference, actor, expires_at) VALUES ('active', $1, $2, $3) RETURNING id::STRING, status, created_at",
+		r.Reference, r.Actor, r.ExpiresAt,
+	).Scan(&r.ID, &r.Status, &r.CreatedAt)
+	if err != nil {
+		return r, err
+	}
+	for _, item := range r.Items {
+		if err := tx.ExecQuery("INSERT INTO reservation_items (reservation_id, product_id, quantity) VALUES ($1, $2, $3)", r.ID, item.ProductID, item.Quantity); err != nil {
+			return r, err
+		}
+	}
+	return r, nil
+}
+
+func getReservation(database *db.Database, id string, lock bool) (Reservation, error) {
+	sql := "SELECT id::STRING, status, reference, actor, created_at, expires_at FROM reservations WHERE id = $1"
+	if lock {
+		sql += " FOR UPDATE"
+	}
+
+	var r Reservation
+	err := database.QueryRow(sql, id).Scan(&r.ID, &r.Status, &r.Reference, &r.Actor, &r.CreatedAt, &r.ExpiresAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return r, ErrReservationNotFound
+	}
+	if err != nil {
+		return r, err
+	}
+
+	rows, err := database.Query("SELECT product_id, quantity FROM reservation_items WHERE reservation_id = $1 ORDER BY product_id", id)
+	if err != nil {
+		return r, err
+	}
+	defer rows.Close()
+
+	r.Items = []Item{}
+	for rows.Next() {
+		var item Item
+		if err := rows.Scan(&item.ProductID, &item.Quantity); err != nil {
+			return r, err
+		}
+		r.Items = append(r.Items, item)
+	}
+	return r, rows.Err()
+}
+
+// Ends an active reservation with status. A reservation past its expiry is
+// marked expired instead, callers treat that as not active once committed.
+func finish(tx *db.Database, id, status string) (Reservation, error) {
+	r, err := getReservation(tx, id, true)
+	if err != nil {
+		return r, err
+	}
+	if r.Status != Active {
+		return r, ErrNotActive
+	}
+	if !r.ExpiresAt.After(time.Now()) {
+		status = Expired
+	}
+
+	if err := tx.ExecQuery("UPDATE reservations SET status = $1 WHERE id = $2", status, id); err != nil {
+		return r, err
+	}
+	r.Status = status
+	return r, nil
+}
+
+// ExpireReservations marks active reservations past their expiry as expired,
+// returning how many there were
+func ExpireReservations(database *db.Database) (int, error) {
+	var expired int
+	err := database.QueryRow(
+		"WITH expired AS (UPDATE reservations SET status = 'expired' WHERE status = 'active' AND expires_at <= now() RETURNING 1) SELECT count(*) FROM expired",
+	).Scan(&expired)
+	return expired, err
+}
+
+// CreateReservation handles POST /reservations
+func CreateReservation(database *db.Database, defaultTTL time.Duration) gin.HandlerFunc {
+	if defaultTTL <= 0 {
+		defaultTTL = DefaultTTL
+	}
+
+	return func(c *gin.Context) {
+		database := database.WithContext(c.Request.Context())
+
+		var input reservationInput
+		if err := c.ShouldBindJSON(&input); err != nil {
+			utils.ErrorResponse(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		items, err := MergeItems(input.Items)
+		if err != nil {
+			utils.ErrorResponse(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		ttl := defaultTTL
+		if input.TTLSeconds != 0 {
+			ttl = time.Duration(input.TTLSeconds) * time.Second
+		}
+		if ttl <= 0 || ttl > MaxTTL {
+			utils.ErrorResponse(c, http.StatusBadRequest, fmt.Sprintf("ttl_seconds must be between 1 and %d", int(MaxTTL.Seconds())))
+			return
+		}
+
+		r := Reservation{Items: items, Reference: input.Reference, Actor: audit.Actor(c), ExpiresAt: time.Now().Add(ttl)}
+		err = database.Transaction(func(tx *db.Database) error {
+			// a retried attempt reserves the items from the request again
+			reserved, err := Reserve(tx, r)
+			if err != nil {
+				return err
+			}
+			r = reserved
+			return nil
+		})
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		audit.Record(c, "reservation", r.ID, nil, r)
+		c.JSON(http.StatusCreated, r)
+	}
+}
+
+// GetReservation handles GET /reservations/:id
+func GetReservation(database *db.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		database := database.WithContext(c.Request.Context())
+
+		r, err := getReservation(database, c.Param("id"), false)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		// the sweeper may not have caught up yet
+		if r.Status == Active && !r.ExpiresAt.After(time.Now()) {
+			r.Status = Expired
+		}
+		c.JSON(http.StatusOK, r)
+	}
+}
+
+// ConfirmReservation handles POST /reservations/:id/confirm, turning the held
+// units into sales
+func ConfirmReservation(database *db.Database, ledger *Ledger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		database := database.WithContext(c.Request.Context())
+
+		id := c.Param("id")
+		var r Reservation
+		err := database.Transaction(func(tx *db.Database) error {
+			var err error
+			if r, err = finish(tx, id, Confirmed); err != nil || r.Status != Confirmed {
+				return err
+			}
+			reference := "reservation:" + id
+			for _, item := range r.Items {
+				_, err := ledger.Apply(tx, Movement{ProductID: item.ProductID, Type: Sale, Quantity: -item.Quantity, Reference: &reference, Actor: audit.Actor(c)})
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err == nil && r.Status == Expired {
+			err = ErrNotActive
+		}
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		audit.Record(c, "reservation", id, gin.H{"status": Active}, gin.H{"status": r.Status})
+		c.JSON(http.StatusOK, r)
+	}
+}
+
+// ReleaseReservation handles POST /reservations/:id/release, giving the held
+// units back
+func ReleaseReservation(database *db.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		database := database.WithContext(c.Request.Context())
+
+		id := c.Param("id")
+		var r Reservation
+		err := database.Transaction(func(tx *db.Database) error {
+			var err error
+			r, err = finish(tx, id, Released)
+			return err
+		})
+		if err == nil && r.Status == Expired {
+			err = ErrNotActive
+		}
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		audit.Record(c, "reservation", id, gin.H{"status": Active}, gin.H{"status": r.Status})
+		c.JSON(http.StatusOK, r)
+	}
+}
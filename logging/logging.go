@@ -0,0 +1,138 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	Header       = "X-Request-ID"
+	RequestIDKey = "request_id"
+	TraceIDKey   = "trace_id"
+	loggerKey    = "logging.logger"
+)
+
+// Setup installs the default slog logger. level is debug, info, warn or
+// error and format is json or text.
+func Setup(w io.Writer, level, format string) (*slog.Logger, error) {
+	var l slog.Level
+	if level != "" {
+		if err := l.UnmarshalText([]byte(level)); err != nil {
+			return nil, fmt.Errorf("invalid log level %q", level)
+		}
+	}
+
+	options := &slog.HandlerOptions{Level: l}
+
+	var handler slog.Handler
+	switch format {
+	case "", "json":
+		handler = slog.NewJSONHandler(w, options)
+	case "text":
+		handler = slog.NewTextHandler(w, options)
+	default:
+		return nil, fmt.Errorf("invalid log format %q", format)
+	}
+
+	logger := slog.New(handler)
+	slog.SetDefault(logger)
+	return logger, nil
+}
+
+type contextKey struct{}
+
+// Accepts an incoming request ID when it looks sane, otherwise generates one
+func requestID(incoming string) string {
+	if incoming != "" && len(incoming) <= 128 && !strings.ContainsFunc(incoming, func(r rune) bool {
+		return r < 0x21 || r > 0x7e
+	}) {
+		return incoming
+	}
+
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// RequestID gives every request an ID, taken from X-Request-ID when the client
+// sent one, echoes it back and attaches it to the request's logger
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := requestID(c.GetHeader(Header))
+
+		c.Set(RequestIDKey, id)
+		With(c, RequestIDKey, id)
+		c.Header(Header, id)
+
+		c.Next()
+	}
+}
+
+// AccessLog writes one line per request, replacing gin's text logger
+func AccessLog() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		level := slog.LevelInfo
+		if c.Writer.Status() >= 500 {
+			level = slog.LevelError
+		}
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+
+		FromGin(c).Log(c.Request.Context(), level, "request",
+			"method", c.Request.Method,
+			"route", route,
+			"path", c.Request.URL.Path,
+			"status", c.Writer.Status(),
+			"duration_ms", float64(time.Since(start).Microseconds())/1000,
+			"client_ip", c.ClientIP(),
+			"bytes", c.Writer.Size(),
+		)
+	}
+}
+
+// With adds attributes to every following log line of the request
+func With(c *gin.Context, args ...any) {
+	logger := FromGin(c).With(args...)
+	c.Set(loggerKey, logger)
+	c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), contextKey{}, logger))
+}
+
+// RequestIDFrom returns the ID assigned by the RequestID middleware
+func RequestIDFrom(c *gin.Context) string {
+	return c.GetString(RequestIDKey)
+}
+
+// TraceIDFrom returns the trace ID set by the tracing middleware, if any
+func TraceIDFrom(c *gin.Context) string {
+	return c.GetString(TraceIDKey)
+}
+
+// FromGin returns the request's logger, or the default one outside a request
+func FromGin(c *gin.Context) *slog.Logger {
+	if logger, ok := c.Get(loggerKey); ok {
+		return logger.(*slog.Logger)
+	}
+	return slog.Default()
+}
+
+// FromContext is FromGin for code that only has the request context
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
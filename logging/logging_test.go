@@ -0,0 +1,72 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestID(t *testing.T) {
+	var out bytes.Buffer
+	_, err := Setup(&out, "info", "json")
+	require.NoError(t, err)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(RequestID(), AccessLog())
+	r.GET("/products", func(c *gin.Context) {
+		FromContext(c.Request.Context()).Info("handler")
+		c.String(http.StatusOK, RequestIDFrom(c))
+	})
+
+	testCases := []struct {
+		name     string
+		incoming string
+		keep     bool
+	}{
+		{name: "Accepts incoming ID", incoming: "abc-123", keep: true},
+		{name: "Generates missing ID", incoming: "", keep: false},
+		{name: "Replaces invalid ID", incoming: "has spaces", keep: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			out.Reset()
+			req := httptest.NewRequest(http.MethodGet, "/products", nil)
+			req.Header.Set(Header, tc.incoming)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			id := w.Header().Get(Header)
+			assert.Equal(t, id, w.Body.String())
+			if tc.keep {
+				assert.Equal(t, tc.incoming, id)
+			} else {
+				assert.Len(t, id, 32)
+			}
+
+			// Both the handler's line and the access log line carry the ID
+			lines := bytes.Split(bytes.TrimSpace(out.Bytes()), []byte("\n"))
+			require.Len(t, lines, 2)
+			for _, line := range lines {
+				var entry map[string]any
+				require.NoError(t, json.Unmarshal(line, &entry))
+				assert.Equal(t, id, entry[RequestIDKey])
+			}
+		})
+	}
+}
+
+func TestSetupRejectsUnknownSettings(t *testing.T) {
+	_, err := Setup(&bytes.Buffer{}, "loud", "json")
+	assert.Error(t, err)
+
+	_, err = Setup(&bytes.Buffer{}, "info", "xml")
+	assert.Error(t, err)
+}
@@ -2,89 +2,188 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
+	"rest/alerts"
+	"rest/audit"
+	"rest/auth"
+	"rest/catalog"
 	"rest/db"
+	"rest/idempotency"
+	"rest/inventory"
+	"rest/logging"
+	"rest/metrics"
+	"rest/outbox"
+	"rest/ratelimit"
+	"rest/rbac"
+	"rest/search"
+	"rest/stream"
+	"rest/tracing"
 	"rest/utils"
+	"rest/webhooks"
+	"rest/ws"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// Postgres error code for a duplicate primary key
+const uniqueViolation = "23505"
+
 type Product struct {
-	ID       string `json:"id"`
-	Name     string `json:"name"`
-	Price    string `json:"price"`
-	Quantity string `json:"quantity"`
+	ID               string     `json:"id"`
+	Name             string     `json:"name"`
+	Price            string     `json:"price"`
+	Quantity         string     `json:"quantity"`
+	CategoryID       *string    `json:"category_id,omitempty"`
+	ReorderThreshold *int       `json:"reorder_threshold,omitempty"`
+	DeletedAt        *time.Time `json:"deleted_at,omitempty"`
 }
 
-// Handles the get requests
-func getProducts(db *db.Database) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		pageStr := c.Query("page")
-		limitStr := c.Query("limit")
+// Soft deleted products are only listed when ?include_deleted=true is passed
+func includeDeleted(c *gin.Context) bool {
+	include, _ := strconv.ParseBool(c.Query("include_deleted"))
+	return include
+}
 
-		page, err := strconv.Atoi(pageStr)
-		if err != nil || page < 1 {
-			page = 1
-		}
+// Builds the WHERE clause of a product listing. A category includes its
+// descendants and every tag has to be present.
+func productFilter(c *gin.Context, category string) (string, []any) {
+	var conditions []string
+	var values []any
+
+	if !includeDeleted(c) {
+		conditions = append(conditions, "deleted_at IS NULL")
+	}
 
-		limit, err := strconv.Atoi(limitStr)
-		if err != nil || limit < 1 {
-			limit = 10
+	if category != "" {
+		values = append(values, category)
+		conditions = append(conditions, fmt.Sprintf("category_id IN (%s)", catalog.DescendantsSQL(len(values))))
+	}
+
+	for _, tag := range c.QueryArray("tag") {
+		if normalized, err := catalog.NormalizeTag(tag); err == nil {
+			tag = normalized
 		}
+		values = append(values, tag)
+		conditions = append(conditions, fmt.Sprintf("id IN (SELECT product_id FROM product_tags WHERE tag = $%d)", len(values)))
+	}
 
-		offset := (page - 1) * limit
+	if len(conditions) == 0 {
+		return "", values
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), values
+}
 
-		var totalProducts int
-		err = db.QueryRow("SELECT COUNT(*) FROM products").Scan(&totalProducts)
+// Handles the get requests
+func getProducts(database *db.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		listProducts(c, database, c.Query("category"))
+	}
+}
+
+// Lists the products of a category and its subcategories
+func getCategoryProducts(database *db.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		exists, err := catalog.CategoryExists(database.WithContext(c.Request.Context()), id)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
 			return
 		}
-
-		// Check if the offset is out of range
-		if offset >= totalProducts {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Page out of range"})
+		if !exists {
+			utils.ErrorResponse(c, http.StatusNotFound, "Category not found")
 			return
 		}
 
-		rows, err := db.Query("SELECT id, name, price, quantity FROM products LIMIT $1 OFFSET $2", limit, offset)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-		defer rows.Close()
+		listProducts(c, database, id)
+	}
+}
 
-		var products []Product
-		for rows.Next() {
-			var p Product
-			err := rows.Scan(&p.ID, &p.Name, &p.Price, &p.Quantity)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				return
-			}
-			products = append(products, p)
-		}
+func listProducts(c *gin.Context, database *db.Database, category string) {
+	database = database.WithContext(c.Request.Context())
+
+	limit, offset := utils.Pagination(c)
+
+	filter, values := productFilter(c, category)
+
+	var totalProducts int
+	err := database.QueryRow("SELECT COUNT(*) FROM products"+filter, values...).Scan(&totalProducts)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Check if the offset is out of range
+	if offset >= totalProducts {
+		utils.ErrorResponse(c, http.StatusNotFound, "Page out of range")
+		return
+	}
 
-		c.JSON(http.StatusOK, products)
+	values = append(values, limit, offset)
+	rows, err := database.Query(
+		fmt.Sprintf("SELECT id, name, price, quantity, category_id::STRING, reorder_threshold, deleted_at FROM products%s LIMIT $%d OFFSET $%d", filter, len(values)-1, len(values)),
+		values...,
+	)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
 
+	var products []Product
+	for rows.Next() {
+		var p Product
+		err := rows.Scan(&p.ID, &p.Name, &p.Price, &p.Quantity, &p.CategoryID, &p.ReorderThreshold, &p.DeletedAt)
+		if err != nil {
+			utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		products = append(products, p)
 	}
+
+	c.JSON(http.StatusOK, products)
 }
 
 // Get a specific product
-func getProduct(db *db.Database) gin.HandlerFunc {
+func getProduct(database *db.Database) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		id := c.Query("id")
+		database := database.WithContext(c.Request.Context())
+
+		id := c.Param("id")
+		if id == "" {
+			id = c.Query("id")
+		}
 		var p Product
 
-		err := db.QueryRow("SELECT * FROM products WHERE id = $1", id).Scan(&p.ID, &p.Name, &p.Price, &p.Quantity)
+		if asOf := c.Query("as_of"); asOf != "" {
+			getProductAsOf(c, database, id, asOf)
+			return
+		}
+
+		sql := "SELECT id, name, price, quantity, category_id::STRING, reorder_threshold, deleted_at FROM products WHERE id = $1"
+		if !includeDeleted(c) {
+			sql += " AND deleted_at IS NULL"
+		}
+
+		err := database.QueryRow(sql, id).Scan(&p.ID, &p.Name, &p.Price, &p.Quantity, &p.CategoryID, &p.ReorderThreshold, &p.DeletedAt)
+		if errors.Is(err, pgx.ErrNoRows) {
+			utils.ErrorResponse(c, http.StatusNotFound, "Product not found")
+			return
+		}
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
 			return
 		}
 
@@ -93,104 +192,344 @@ func getProduct(db *db.Database) gin.HandlerFunc {
 }
 
 // Handles POST requests
-func addProduct(db *db.Database) gin.HandlerFunc {
+func addProduct(database *db.Database) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		database := database.WithContext(c.Request.Context())
+
 		var product Product
 		if err := c.ShouldBindJSON(&product); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			utils.ErrorResponse(c, http.StatusBadRequest, err.Error())
 			return
 		}
 
 		if product.ID == "" || product.Name == "" || product.Price == "" || product.Quantity == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Empty field"})
+			utils.ErrorResponse(c, http.StatusBadRequest, "Empty field")
 			return
 		}
 
-		err := db.ExecQuery("INSERT INTO products (id, name, price, quantity) VALUES ($1, $2, $3, $4)", product.ID, product.Name, product.Price, product.Quantity)
+		// the category and threshold have their own routes, only an import sets them
+		product.CategoryID = nil
+		product.ReorderThreshold = nil
+		err := database.Transaction(func(tx *db.Database) error {
+			return insertProduct(tx, product, audit.Actor(c))
+		})
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+			utils.ErrorResponse(c, http.StatusConflict, "A product with this ID already exists")
+			return
+		}
 		if err != nil {
-			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
 			return
 		}
 
+		audit.Record(c, "product", product.ID, nil, product)
 		c.JSON(http.StatusCreated, product)
 	}
 }
 
+// Adds a product with its initial stock, search terms and history, it must
+// run in a transaction
+func insertProduct(tx *db.Database, product Product, actor string) error {
+	err := tx.ExecQuery(
+		"INSERT INTO products (id, name, price, quantity, category_id, reorder_threshold) VALUES ($1, $2, $3, $4, $5, $6)",
+		product.ID, product.Name, product.Price, product.Quantity, product.CategoryID, product.ReorderThreshold,
+	)
+	if err != nil {
+		return err
+	}
+	if err := search.Update(tx, product.ID, product.Name); err != nil {
+		return err
+	}
+	if err := inventory.Reconcile(tx, product.ID, product.Quantity, "initial stock", actor); err != nil {
+		return err
+	}
+	if err := productChanged(tx, product.ID, "create"); err != nil {
+		return err
+	}
+	if product.ReorderThreshold != nil {
+		return alerts.Check(tx, product.ID)
+	}
+	return nil
+}
+
 // Handles PUT requests
-func updatePruduct(db *db.Database) gin.HandlerFunc {
+func updatePruduct(database *db.Database, ledger *inventory.Ledger) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		database := database.WithContext(c.Request.Context())
 
 		var dbProduct Product
 		var newProduct Product
 		id := c.Query("id")
 		if id == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Product ID is not provided!"})
+			utils.ErrorResponse(c, http.StatusBadRequest, "Product ID is not provided!")
 			return
 		}
 
 		if err := c.ShouldBindJSON(&newProduct); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			utils.ErrorResponse(c, http.StatusBadRequest, err.Error())
 			return
 		}
 
-		err := db.QueryRow("SELECT * FROM products WHERE id = $1", id).Scan(&dbProduct.ID, &dbProduct.Name, &dbProduct.Price, &dbProduct.Quantity)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
+		// the stock is changed through the ledger, so it has to be counted
+		quantity := -1
+		if newProduct.Quantity != "" {
+			n, err := strconv.Atoi(newProduct.Quantity)
+			if err != nil || n < 0 {
+				utils.ErrorResponse(c, http.StatusBadRequest, "quantity must be a whole number")
+				return
+			}
+			quantity = n
+			newProduct.Quantity = strconv.Itoa(n)
 		}
 
-		if newProduct.Name == "" {
-			newProduct.Name = dbProduct.Name
-		}
+		var updated Product
+		err := database.Transaction(func(tx *db.Database) error {
+			err := tx.QueryRow("SELECT id, name, price, quantity FROM products WHERE id = $1 AND deleted_at IS NULL FOR UPDATE", id).Scan(&dbProduct.ID, &dbProduct.Name, &dbProduct.Price, &dbProduct.Quantity)
+			if err != nil {
+				return err
+			}
 
-		if newProduct.Price == "" {
-			newProduct.Price = dbProduct.Price
-		}
+			// a retried transaction starts again from the request, the blank
+			// fields are filled from the row read in this attempt
+			input := newProduct
+			if input.Name == "" {
+				input.Name = dbProduct.Name
+			}
 
-		if newProduct.Quantity == "" {
-			newProduct.Quantity = dbProduct.Quantity
-		}
+			if input.Price == "" {
+				input.Price = dbProduct.Price
+			}
+
+			if input.Quantity == "" {
+				input.Quantity = dbProduct.Quantity
+			}
+			updated = input
+
+			renamed := input.Name != dbProduct.Name || input.Price != dbProduct.Price
+			// a stored quantity that is not counted yet is always set, the ledger
+			// starts counting it
+			current, err := strconv.Atoi(dbProduct.Quantity)
+			stocked := quantity >= 0 && (err != nil || current < 0 || current != quantity)
+			if !renamed && !stocked {
+				return nil
+			}
 
-		err = db.ExecQuery("UPDATE products SET name = $1, price = $2, quantity = $3 WHERE id = $4", newProduct.Name, newProduct.Price, newProduct.Quantity, id)
+			if renamed {
+				err = tx.ExecQuery("UPDATE products SET name = $1, price = $2 WHERE id = $3", input.Name, input.Price, id)
+				if err != nil {
+					return err
+				}
+				if input.Name != dbProduct.Name {
+					if err := search.Update(tx, id, input.Name); err != nil {
+						return err
+					}
+				}
+			}
+
+			// the ledger records the change of the product, name and price
+			// included, so it is only recorded once
+			if stocked {
+				return ledger.Set(tx, id, quantity, "quantity set with PUT /product", audit.Actor(c))
+			}
+			return productChanged(tx, id, "update")
+		})
+		if errors.Is(err, pgx.ErrNoRows) {
+			utils.ErrorResponse(c, http.StatusNotFound, "Product not found")
+			return
+		}
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			utils.ErrorResponse(c, inventory.ErrorStatus(err), err.Error())
 			return
 		}
 
-		c.JSON(http.StatusOK, newProduct)
+		after := updated
+		after.ID = id
+		audit.Record(c, "product", id, dbProduct, after)
+		c.JSON(http.StatusOK, updated)
 	}
 }
 
-// Handles DELETE requests
-func deleteProduct(db *db.Database) gin.HandlerFunc {
+// Handles DELETE requests, the product is only marked as deleted
+func deleteProduct(database *db.Database) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		database := database.WithContext(c.Request.Context())
+
 		id := c.Query("id")
 
 		if id == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Product ID is not provided!"})
+			utils.ErrorResponse(c, http.StatusBadRequest, "Product ID is not provided!")
 			return
 		}
 
-		// Product does not exist
 		var p Product
-		err := db.QueryRow("SELECT * FROM products WHERE id = $1", id).Scan(&p.ID, &p.Name, &p.Price, &p.Quantity)
+		err := database.Transaction(func(tx *db.Database) error {
+			err := tx.QueryRow("SELECT id, name, price, quantity FROM products WHERE id = $1 AND deleted_at IS NULL FOR UPDATE", id).Scan(&p.ID, &p.Name, &p.Price, &p.Quantity)
+			if err != nil {
+				return err
+			}
+
+			err = tx.ExecQuery("UPDATE products SET deleted_at = now() WHERE id = $1", id)
+			if err != nil {
+				return err
+			}
+			return productChanged(tx, id, "delete")
+		})
+		if errors.Is(err, pgx.ErrNoRows) {
+			utils.ErrorResponse(c, http.StatusNotFound, "Product not found")
+			return
+		}
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
 			return
 		}
 
-		err = db.ExecQuery("DELETE FROM products WHERE id = $1", id)
+		audit.Record(c, "product", id, p, nil)
+		c.JSON(http.StatusNoContent, nil)
+	}
+
+}
+
+// Brings back a soft deleted product
+func restoreProduct(database *db.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		database := database.WithContext(c.Request.Context())
+
+		id := c.Param("id")
+		var p Product
+
+		err := database.Transaction(func(tx *db.Database) error {
+			err := tx.QueryRow("UPDATE products SET deleted_at = NULL WHERE id = $1 AND deleted_at IS NOT NULL RETURNING id, name, price, quantity", id).Scan(&p.ID, &p.Name, &p.Price, &p.Quantity)
+			if err != nil {
+				return err
+			}
+			return productChanged(tx, id, "restore")
+		})
+		if errors.Is(err, pgx.ErrNoRows) {
+			utils.ErrorResponse(c, http.StatusNotFound, "No deleted product with this ID")
+			return
+		}
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
 			return
 		}
 
-		c.JSON(http.StatusNoContent, nil)
+		audit.Record(c, "product", id, nil, p)
+		c.JSON(http.StatusOK, p)
+	}
+}
+
+// Runs in the transaction of every product change that is kept in the
+// product history
+func productChanged(tx *db.Database, id, operation string) error {
+	if err := recordHistory(tx, id, operation); err != nil {
+		return err
+	}
+	return publishProduct(tx, id, operation)
+}
+
+// Writes the event of a product change to the outbox in the transaction of
+// the change, webhooks and the change stream are fed from there. The other handlers changing products,
+// like setting a category, tags or threshold, call it directly.
+func publishProduct(tx *db.Database, id, operation string) error {
+	var p Product
+	err := tx.QueryRow("SELECT id, name, price, quantity, category_id::STRING, reorder_threshold, deleted_at FROM products WHERE id = $1", id).
+		Scan(&p.ID, &p.Name, &p.Price, &p.Quantity, &p.CategoryID, &p.ReorderThreshold, &p.DeletedAt)
+	if err != nil {
+		return err
+	}
+
+	event, ok := productEvents[operation]
+	if !ok {
+		event = webhooks.ProductUpdated
+	}
+	return outbox.Add(tx, event, id, p)
+}
+
+// Event of each kind of product change, anything not listed is an update
+var productEvents = map[string]string{
+	"create":  webhooks.ProductCreated,
+	"delete":  webhooks.ProductDeleted,
+	"restore": webhooks.ProductRestored,
+}
+
+// Runs in the transaction of every stock movement
+func stockChanged(tx *db.Database, id, operation string) error {
+	if err := productChanged(tx, id, operation); err != nil {
+		return err
+	}
+	return alerts.Check(tx, id)
+}
+
+// Hard deletes products that were soft deleted longer than the retention ago
+func purgeDeletedProducts(database *db.Database, retention time.Duration) error {
+	before := time.Now().Add(-retention)
+	return database.Transaction(func(tx *db.Database) error {
+		err := tx.ExecQuery("DELETE FROM product_search_terms WHERE product_id IN (SELECT id FROM products WHERE deleted_at < $1)", before)
+		if err != nil {
+			return err
+		}
+		return tx.ExecQuery("DELETE FROM products WHERE deleted_at < $1", before)
+	})
+}
+
+// Reads a Go duration from the environment, falling back to def
+func durationEnv(name string, def time.Duration) time.Duration {
+	if value := os.Getenv(name); value != "" {
+		if d, err := time.ParseDuration(value); err == nil {
+			return d
+		}
+	}
+	return def
+}
+
+// Reads a positive integer from the environment, falling back to def
+func intEnv(name string, def int) int {
+	if value := os.Getenv(name); value != "" {
+		if n, err := strconv.Atoi(value); err == nil && n > 0 {
+			return n
+		}
+	}
+	return def
+}
+
+// Reads a comma separated list from the environment, empty when unset
+func listEnv(name string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(name), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// Reads a rate limit such as 100/1m from the environment
+func limitEnv(name, def string) ratelimit.Limit {
+	value := os.Getenv(name)
+	if value == "" {
+		value = def
 	}
 
+	limit, err := ratelimit.ParseLimit(value)
+	if err != nil {
+		fatal("invalid rate limit", "variable", name, "error", err)
+	}
+	return limit
+}
+
+// Logs the error and stops the server, only main is allowed to give up
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
 }
 
 func main() {
+	if _, err := logging.Setup(os.Stderr, os.Getenv("LOG_LEVEL"), os.Getenv("LOG_FORMAT")); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
 	dbUser := "root"
 	dbPassword := "root"
 	dbHost := os.Getenv("DB_HOST")
@@ -201,36 +540,273 @@ func main() {
 		dbHost = "localhost"
 	}
 
+	shutdownTracing, err := tracing.Setup(context.Background(), os.Getenv("TRACING_EXPORTER"), os.Stdout)
+	if err != nil {
+		fatal("failed to set up tracing", "error", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	m := metrics.New()
+
+	var dbConn *pgxpool.Pool
 	for {
-		_, err := db.GetConnection(dbUser, dbHost, dbPort, dbName)
+		conn, err := db.GetConnection(dbUser, dbHost, dbPort, dbName, m.QueryTracer(), tracing.QueryTracer{})
 		if err == nil {
+			dbConn = conn
 			break
 		}
-		log.Println("Failed to connect to database. Retrying...")
+		slog.Warn("failed to connect to database, retrying", "host", dbHost, "error", err)
 		time.Sleep(1 * time.Second)
 	}
 
-	dbConn, _ := db.GetConnection(dbUser, dbHost, dbPort, dbName)
-	db.Create(dbUser, dbPassword, dbHost, dbPort, dbName, dbConn)
+	defer dbConn.Close()
+
+	// admin commands run against the existing database instead of starting the server
+	if len(os.Args) > 1 && (os.Args[1] == "apikey" || os.Args[1] == "role") {
+		database := db.USE(dbUser, dbPassword, dbHost, dbPort, dbName, dbConn)
+		code := 0
+		switch os.Args[1] {
+		case "apikey":
+			code = runAPIKeyCommand(database, os.Args[2:])
+		case "role":
+			code = runRoleCommand(database, os.Args[2:])
+		}
+		dbConn.Close()
+		os.Exit(code)
+	}
+
+	if _, err := db.Create(dbUser, dbPassword, dbHost, dbPort, dbName, dbConn); err != nil {
+		fatal("failed to create schema", "error", err)
+	}
 	database := db.USE(dbUser, dbPassword, dbHost, dbPort, dbName, dbConn)
-	defer dbConn.Close(context.Background())
 
-	r := gin.Default()
+	idempotencyStore := idempotency.NewDBStore(database)
+
+	m.RegisterPool(dbConn)
+	go m.RegisterInventory(database).Run(context.Background(), durationEnv("METRICS_INVENTORY_INTERVAL", time.Minute))
+
+	// the schema is recreated on every start, so BOOTSTRAP_API_KEY is how the first key gets in
+	seedHeader := http.Header{}
+	var bootstrapActor string
+	var authenticate gin.HandlerFunc
+	authMode := os.Getenv("AUTH_MODE")
+	switch authMode {
+	case "", "none":
+	case "apikey":
+		keyStore := auth.NewDBKeyStore(database)
+		if seedKey := os.Getenv("BOOTSTRAP_API_KEY"); seedKey != "" {
+			k, err := keyStore.Import("bootstrap", seedKey)
+			if err != nil {
+				fatal("failed to import bootstrap API key", "error", err)
+			}
+			bootstrapActor = auth.Actor(k)
+			seedHeader.Set(auth.Header, seedKey)
+		}
+		authenticate = auth.APIKeyMiddleware(keyStore)
+	case "jwt":
+		verifier, err := auth.NewJWTVerifier(auth.JWTConfig{
+			Issuer:    os.Getenv("JWT_ISSUER"),
+			Audience:  os.Getenv("JWT_AUDIENCE"),
+			ClockSkew: durationEnv("JWT_CLOCK_SKEW", time.Minute),
+			Keys:      auth.NewJWKS(os.Getenv("JWT_JWKS"), durationEnv("JWT_JWKS_TTL", 5*time.Minute)),
+		})
+		if err != nil {
+			fatal("invalid JWT configuration", "variables", "JWT_ISSUER, JWT_AUDIENCE", "error", err)
+		}
+		if token := os.Getenv("BOOTSTRAP_TOKEN"); token != "" {
+			seedHeader.Set("Authorization", "Bearer "+token)
+		}
+		authenticate = auth.JWTMiddleware(verifier)
+	default:
+		fatal("unknown AUTH_MODE", "auth_mode", authMode)
+	}
+
+	// roles are only enforced when requests are authenticated
+	var roleSource rbac.RoleSource
+	if authenticate != nil {
+		config := rbac.ConfigSource{}
+		if bootstrapActor != "" {
+			config[bootstrapActor] = []rbac.Role{rbac.Admin}
+		}
+		if path := os.Getenv("RBAC_CONFIG"); path != "" {
+			loaded, err := rbac.LoadConfig(path)
+			if err != nil {
+				fatal("failed to load RBAC config", "path", path, "error", err)
+			}
+			for actor, roles := range loaded {
+				config[actor] = roles
+			}
+		}
+		roleSource = rbac.Sources{config, rbac.NewDBSource(database)}
+	}
+
+	limits := ratelimit.NewMemoryStore()
 
-	r.GET("/products", getProducts(database))
-	r.GET("/product", getProduct(database))
-	r.POST("/products", addProduct(database))
-	r.PUT("/product", updatePruduct(database))
-	r.DELETE("/product", deleteProduct(database))
+	// product changes streamed to clients, fed by their own outbox tail
+	changes := stream.NewHub(intEnv("STREAM_REPLAY", 1000), 64)
+	changes.SetAncestors(stream.CategoryAncestors(database))
+
+	// behind a proxy that terminates TLS, only a trusted proxy tells the
+	// WebSocket origin check that pages on the server itself use https
+	sockets := ws.NewServer(changes, listEnv("WS_ALLOWED_ORIGINS"))
+	if err := sockets.TrustProxies(listEnv("TRUSTED_PROXIES")); err != nil {
+		fatal("invalid trusted proxies", "variable", "TRUSTED_PROXIES", "error", err)
+	}
+
+	srv := &server{
+		database:     database,
+		metrics:      m,
+		idempotency:  idempotencyStore,
+		limits:       limits,
+		searcher:     search.NewDBSearcher(database),
+		authenticate: authenticate,
+		roles:        roleSource,
+
+		trustedProxies: listEnv("TRUSTED_PROXIES"),
+
+		reservationTTL: durationEnv("RESERVATION_TTL", inventory.DefaultTTL),
+		changes:        changes,
+		sockets:        sockets,
+		heartbeat:      durationEnv("STREAM_HEARTBEAT", stream.DefaultHeartbeat),
+	}
+	r := srv.router()
+
+	// forget clients whose buckets are full again
+	go func() {
+		for now := range time.Tick(10 * time.Minute) {
+			limits.Cleanup(now)
+		}
+	}()
+
+	// drop idempotency keys whose TTL has passed
+	go func() {
+		for range time.Tick(time.Hour) {
+			if err := idempotencyStore.Purge(); err != nil {
+				slog.Error("failed to purge idempotency keys", "error", err)
+			}
+		}
+	}()
+
+	// give back the stock of reservations that were never confirmed
+	go func() {
+		for range time.Tick(time.Minute) {
+			expired, err := inventory.ExpireReservations(database)
+			if err != nil {
+				slog.Error("failed to expire reservations", "error", err)
+			} else if expired > 0 {
+				slog.Info("expired reservations", "count", expired)
+			}
+		}
+	}()
+
+	// deliver low stock alerts recorded by the update paths
+	sinkNames := os.Getenv("ALERT_SINKS")
+	if sinkNames == "" {
+		sinkNames = "log"
+	}
+	sinks, err := alerts.ParseSinks(sinkNames, os.Getenv("ALERT_WEBHOOK_URL"), os.Getenv("ALERT_FILE"))
+	if err != nil {
+		fatal("invalid alert sinks", "error", err)
+	}
+	go alerts.NewDispatcher(database, sinks).Run(context.Background(), durationEnv("ALERT_INTERVAL", 5*time.Second))
+
+	// send product events to webhook subscribers, retrying failed deliveries
+	go webhooks.NewWorker(database, nil).Run(context.Background(), durationEnv("WEBHOOK_INTERVAL", 5*time.Second))
+
+	// publish the outbox, written in the transaction of every product change
+	publishers, err := outbox.ParsePublishers(os.Getenv("OUTBOX_PUBLISHERS"), os.Getenv("OUTBOX_URL"), os.Getenv("OUTBOX_FILE"))
+	if err != nil {
+		fatal("invalid outbox publishers", "error", err)
+	}
+	publishers = append(publishers, webhooks.NewPublisher(database))
+	go outbox.NewRelay(database, publishers).Run(context.Background(), durationEnv("OUTBOX_INTERVAL", time.Second))
+
+	// the change stream follows the outbox on its own, so a publisher that
+	// keeps failing does not hold it up
+	go outbox.NewTail(database, changes).Run(context.Background(), durationEnv("OUTBOX_INTERVAL", time.Second))
+
+	// forget finished webhook deliveries after a while
+	webhookRetention := durationEnv("WEBHOOK_RETENTION", 30*24*time.Hour)
+	go func() {
+		for range time.Tick(time.Hour) {
+			if err := webhooks.Purge(database, time.Now().Add(-webhookRetention)); err != nil {
+				slog.Error("failed to purge webhook deliveries", "error", err)
+			}
+		}
+	}()
+
+	// forget published outbox messages after a while
+	outboxRetention := durationEnv("OUTBOX_RETENTION", 7*24*time.Hour)
+	go func() {
+		for range time.Tick(time.Hour) {
+			if err := outbox.Purge(database, time.Now().Add(-outboxRetention)); err != nil {
+				slog.Error("failed to purge the outbox", "error", err)
+			}
+		}
+	}()
+
+	// hard delete products past the retention window
+	retention := durationEnv("DELETED_RETENTION", 30*24*time.Hour)
+	go func() {
+		for range time.Tick(time.Hour) {
+			if err := purgeDeletedProducts(database, retention); err != nil {
+				slog.Error("failed to purge deleted products", "error", err)
+			}
+		}
+	}()
 
 	// add data to the database after server starts running
 	go func() {
 		time.Sleep(3 * time.Second)
-		utils.AddAllProductsToDB("data.json")
+		if err := utils.AddAllProductsToDB("data.json", seedHeader); err != nil {
+			slog.Error("failed to add data.json to the database", "error", err)
+		}
+	}()
+
+	httpServer := &http.Server{Addr: ":8888", Handler: r}
+
+	// metrics are served on a listener of their own, which is not exposed
+	// like the API and needs no authentication
+	metricsAddr := os.Getenv("METRICS_ADDR")
+	if metricsAddr == "" {
+		metricsAddr = ":9090"
+	}
+	metricsMux := http.NewServeMux()
+	metricsMux.Handle("GET /metrics", m.Handler())
+	metricsServer := &http.Server{Addr: metricsAddr, Handler: metricsMux}
+	go func() {
+		if err := metricsServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			fatal("failed to start metrics server", "address", metricsAddr, "error", err)
+		}
+	}()
+
+	// on SIGINT or SIGTERM, close the change streams and WebSockets, which
+	// would otherwise never finish, then wait for the other requests
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+		slog.Info("shutting down")
+
+		ctx, cancel := context.WithTimeout(context.Background(), durationEnv("SHUTDOWN_TIMEOUT", 10*time.Second))
+		defer cancel()
+		changes.Close()
+		if err := httpServer.Shutdown(ctx); err != nil {
+			slog.Error("failed to finish requests", "error", err)
+		}
+		if err := metricsServer.Shutdown(ctx); err != nil {
+			slog.Error("failed to stop metrics server", "error", err)
+		}
+		if err := srv.sockets.Wait(ctx); err != nil {
+			slog.Error("failed to close WebSockets", "error", err)
+		}
 	}()
 
-	fmt.Println("Server is running on http://localhost:8888")
-	if err := r.Run(":8888"); err != nil {
-		fmt.Println("Failed to start server:", err)
+	slog.Info("server is running", "address", "http://localhost:8888")
+	if err := httpServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		fatal("failed to start server", "error", err)
 	}
+	<-stopped
 }
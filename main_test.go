@@ -69,7 +69,7 @@ func TestGetProduct(t *testing.T) {
 		expectedStatus int
 	}{
 		{id: "1", name: "id 1", expectSuccess: true, expectedStatus: http.StatusOK},
-		{id: "ena", name: "id ena", expectSuccess: false, expectedStatus: http.StatusInternalServerError},
+		{id: "ena", name: "id ena", expectSuccess: false, expectedStatus: http.StatusNotFound},
 	}
 
 	for _, tc := range testCases {
@@ -104,17 +104,17 @@ func TestGetProduct(t *testing.T) {
 }
 
 func TestUpdateProduct(t *testing.T) {
-	p1 := utils.NewProduct("1", "updated", "updated", "updated")
+	p1 := utils.NewProduct("1", "updated", "1.99", "7")
 	p2 := utils.NewProduct("2", "updated", "", "")
-	p3 := utils.NewProduct("3", "", "updated", "")
-	p4 := utils.NewProduct("4", "", "", "updated")
-	p5 := utils.NewProduct("", "updated", "updated", "updated")
-	p6 := utils.NewProduct("DoesNotExist", "updated", "updated", "updated")
+	p3 := utils.NewProduct("3", "", "1.99", "")
+	p4 := utils.NewProduct("4", "", "", "7")
+	p5 := utils.NewProduct("", "updated", "1.99", "7")
+	p6 := utils.NewProduct("DoesNotExist", "updated", "1.99", "7")
 
-	expectedp1 := utils.NewProduct("1", "updated", "updated", "updated")
+	expectedp1 := utils.NewProduct("1", "updated", "1.99", "7")
 	expectedp2 := utils.NewProduct("2", "updated", "849.99", "200")
-	expectedp3 := utils.NewProduct("3", "Sony PlayStation 5", "updated", "75")
-	expectedp4 := utils.NewProduct("4", "Dell XPS 13 Laptop", "1199.99", "updated")
+	expectedp3 := utils.NewProduct("3", "Sony PlayStation 5", "1.99", "75")
+	expectedp4 := utils.NewProduct("4", "Dell XPS 13 Laptop", "1199.99", "7")
 
 	var expectedProduct []utils.Product = []utils.Product{
 		expectedp1,
@@ -135,7 +135,7 @@ func TestUpdateProduct(t *testing.T) {
 		{p: p3, name: "Update product with id 3", expectSuccess: true, expectedStatus: http.StatusOK},
 		{p: p4, name: "Update product with id 4", expectSuccess: true, expectedStatus: http.StatusOK},
 		{p: p5, name: "Fail update id not given", expectSuccess: false, expectedStatus: http.StatusBadRequest},
-		{p: p6, name: "Fail update id not exist", expectSuccess: false, expectedStatus: http.StatusInternalServerError},
+		{p: p6, name: "Fail update id not exist", expectSuccess: false, expectedStatus: http.StatusNotFound},
 	}
 
 	for i, tc := range testCases {
@@ -193,7 +193,7 @@ func TestDeleteProduct(t *testing.T) {
 	}{
 		{id: "1", name: "Delete id 1", expectSuccess: true, expectedStatus: http.StatusNoContent},
 		{id: "", name: "Delete, id not given", expectSuccess: false, expectedStatus: http.StatusBadRequest},
-		{id: "DoesNotExist", name: "Delete, id does not exist", expectSuccess: false, expectedStatus: http.StatusInternalServerError},
+		{id: "DoesNotExist", name: "Delete, id does not exist", expectSuccess: false, expectedStatus: http.StatusNotFound},
 	}
 
 	for _, tc := range testCases {
@@ -223,9 +223,9 @@ func TestDeleteProduct(t *testing.T) {
 
 func TestAddProduct(t *testing.T) {
 
-	p1 := utils.NewProduct("31", "NAME", "PRICE", "QUANTITY")
-	p2 := utils.NewProduct("", "NAME", "PRICE", "QUANTITY")
-	p3 := utils.NewProduct("31", "NAME", "PRICE", "QUANTITY")
+	p1 := utils.NewProduct("31", "NAME", "9.99", "3")
+	p2 := utils.NewProduct("", "NAME", "9.99", "3")
+	p3 := utils.NewProduct("31", "NAME", "9.99", "3")
 	type EmptyStruct struct{}
 	var p4 EmptyStruct
 
@@ -0,0 +1,156 @@
+package metrics
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+
+	"rest/db"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type queryStartKey struct{}
+
+type queryStart struct {
+	operation string
+	at        time.Time
+}
+
+// QueryTracer times every query run through the pool, pass it to db.GetConnection
+type QueryTracer struct {
+	m *Metrics
+}
+
+func (m *Metrics) QueryTracer() *QueryTracer {
+	return &QueryTracer{m}
+}
+
+func (t *QueryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	return context.WithValue(ctx, queryStartKey{}, queryStart{Operation(data.SQL), time.Now()})
+}
+
+func (t *QueryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	start, ok := ctx.Value(queryStartKey{}).(queryStart)
+	if !ok {
+		return
+	}
+
+	status := "ok"
+	if data.Err != nil && data.Err != pgx.ErrNoRows {
+		status = "error"
+	}
+	t.m.dbDuration.WithLabelValues(start.operation, status).Observe(time.Since(start.at).Seconds())
+}
+
+// Operation is the statement keyword of a query, e.g. SELECT
+func Operation(sql string) string {
+	fields := strings.Fields(sql)
+	if len(fields) == 0 {
+		return "OTHER"
+	}
+
+	switch keyword := strings.ToUpper(fields[0]); keyword {
+	case "SELECT", "INSERT", "UPDATE", "UPSERT", "DELETE", "WITH", "BEGIN", "COMMIT", "ROLLBACK", "CREATE", "DROP", "USE":
+		return keyword
+	}
+	return "OTHER"
+}
+
+var (
+	poolConns    = prometheus.NewDesc("db_pool_connections", "Connections in the pool by state.", []string{"state"}, nil)
+	poolMax      = prometheus.NewDesc("db_pool_max_connections", "Maximum size of the pool.", nil, nil)
+	poolAcquires = prometheus.NewDesc("db_pool_acquire_total", "Connections acquired from the pool.", nil, nil)
+	poolEmpty    = prometheus.NewDesc("db_pool_empty_acquire_total", "Acquires that had to wait for a connection.", nil, nil)
+	poolWait     = prometheus.NewDesc("db_pool_acquire_duration_seconds_total", "Time spent acquiring connections.", nil, nil)
+)
+
+type poolCollector struct {
+	pool *pgxpool.Pool
+}
+
+func (c poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- poolConns
+	ch <- poolMax
+	ch <- poolAcquires
+	ch <- poolEmpty
+	ch <- poolWait
+}
+
+func (c poolCollector) Collect(ch chan<- prometheus.Metric) {
+	stat := c.pool.Stat()
+	ch <- prometheus.MustNewConstMetric(poolConns, prometheus.GaugeValue, float64(stat.AcquiredConns()), "acquired")
+	ch <- prometheus.MustNewConstMetric(poolConns, prometheus.GaugeValue, float64(stat.IdleConns()), "idle")
+	ch <- prometheus.MustNewConstMetric(poolConns, prometheus.GaugeValue, float64(stat.ConstructingConns()), "constructing")
+	ch <- prometheus.MustNewConstMetric(poolMax, prometheus.GaugeValue, float64(stat.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(poolAcquires, prometheus.CounterValue, float64(stat.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolEmpty, prometheus.CounterValue, float64(stat.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolWait, prometheus.CounterValue, stat.AcquireDuration().Seconds())
+}
+
+// RegisterPool exposes the pool statistics
+func (m *Metrics) RegisterPool(pool *pgxpool.Pool) {
+	m.Registry.MustRegister(poolCollector{pool})
+}
+
+// Inventory holds the business gauges. Counting them scans the products
+// table, so they are refreshed on a ticker instead of on every scrape.
+type Inventory struct {
+	database *db.Database
+	products prometheus.Gauge
+	units    prometheus.Gauge
+}
+
+// RegisterInventory exposes the business gauges, they stay at zero until the
+// first Refresh
+func (m *Metrics) RegisterInventory(database *db.Database) *Inventory {
+	inv := &Inventory{
+		database: database,
+		products: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "products",
+			Help: "Products that are not deleted.",
+		}),
+		units: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "inventory_units",
+			Help: "Units in stock across all products with a numeric quantity.",
+		}),
+	}
+	m.Registry.MustRegister(inv.products, inv.units)
+	return inv
+}
+
+// Refresh counts the products and units in stock
+func (inv *Inventory) Refresh(ctx context.Context) error {
+	var count, units float64
+	err := inv.database.WithContext(ctx).QueryRow(
+		"SELECT COUNT(*)::FLOAT8, COALESCE(SUM(units), 0)::FLOAT8 FROM products WHERE deleted_at IS NULL",
+	).Scan(&count, &units)
+	if err != nil {
+		return err
+	}
+
+	inv.products.Set(count)
+	inv.units.Set(units)
+	return nil
+}
+
+// Run refreshes the gauges every interval until ctx is done
+func (inv *Inventory) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := inv.Refresh(ctx); err != nil {
+			slog.Error("failed to count inventory for metrics", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddlewareLabelsByRoute(t *testing.T) {
+	m := New()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(m.Middleware())
+	r.GET("/products/:id", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	r.GET("/metrics", gin.WrapH(m.Handler()))
+
+	for _, path := range []string{"/products/1", "/products/2", "/missing"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.requests.WithLabelValues("GET", "/products/:id", "200")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.requests.WithLabelValues("GET", "unmatched", "404")))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Contains(t, w.Body.String(), `http_request_duration_seconds_count{method="GET",route="/products/:id",status="200"} 2`)
+	assert.Contains(t, w.Body.String(), "http_requests_in_flight")
+}
+
+func TestOperation(t *testing.T) {
+	testCases := map[string]string{
+		"SELECT COUNT(*) FROM products":      "SELECT",
+		"  insert INTO products VALUES (1)":  "INSERT",
+		"UPDATE products SET name = $1":      "UPDATE",
+		"EXPLAIN SELECT 1":                   "OTHER",
+		"":                                   "OTHER",
+		"DELETE FROM products WHERE id = $1": "DELETE",
+	}
+
+	for sql, expected := range testCases {
+		assert.Equal(t, expected, Operation(sql), sql)
+	}
+}
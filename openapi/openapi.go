@@ -0,0 +1,38 @@
+package openapi
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+//go:embed openapi.json
+var spec []byte
+
+//go:embed docs.html
+var docs []byte
+
+// Document is the subset of an OpenAPI document needed to look up operations
+type Document struct {
+	OpenAPI string                                `json:"openapi"`
+	Paths   map[string]map[string]json.RawMessage `json:"paths"`
+}
+
+// Load parses the embedded specification
+func Load() (Document, error) {
+	var d Document
+	err := json.Unmarshal(spec, &d)
+	return d, err
+}
+
+// Spec serves the specification at GET /openapi.json
+func Spec(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json", spec)
+}
+
+// Docs serves a self contained page at GET /docs that renders /openapi.json
+func Docs(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", docs)
+}
@@ -0,0 +1,65 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpecIsValid(t *testing.T) {
+	d, err := Load()
+	require.NoError(t, err)
+	assert.Equal(t, "3.1.0", d.OpenAPI)
+
+	var raw map[string]any
+	require.NoError(t, json.Unmarshal(spec, &raw))
+
+	// every $ref must point at something in the document
+	for _, match := range regexp.MustCompile(`"\$ref": "#/([^"]+)"`).FindAllStringSubmatch(string(spec), -1) {
+		var node any = raw
+		for _, key := range strings.Split(match[1], "/") {
+			m, ok := node.(map[string]any)
+			require.True(t, ok, match[1])
+			node, ok = m[key]
+			require.True(t, ok, "unresolved $ref #/%s", match[1])
+		}
+	}
+
+	for path, item := range d.Paths {
+		for method, op := range item {
+			var operation struct {
+				OperationID string                     `json:"operationId"`
+				Responses   map[string]json.RawMessage `json:"responses"`
+			}
+			require.NoError(t, json.Unmarshal(op, &operation))
+			assert.NotEmpty(t, operation.OperationID, "%s %s", method, path)
+			assert.NotEmpty(t, operation.Responses, "%s %s", method, path)
+		}
+	}
+}
+
+func TestHandlers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/openapi.json", Spec)
+	r.GET("/docs", Docs)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.JSONEq(t, string(spec), w.Body.String())
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/docs", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/html")
+	assert.Contains(t, w.Body.String(), "/openapi.json")
+}
@@ -0,0 +1,222 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"rest/problem"
+)
+
+// Schema is the subset of JSON Schema the specification uses
+type Schema struct {
+	Ref                  string             `json:"$ref"`
+	Type                 string             `json:"type"`
+	Format               string             `json:"format"`
+	Enum                 []any              `json:"enum"`
+	Minimum              *float64           `json:"minimum"`
+	Maximum              *float64           `json:"maximum"`
+	MinLength            *int               `json:"minLength"`
+	MaxLength            *int               `json:"maxLength"`
+	Pattern              string             `json:"pattern"`
+	Required             []string           `json:"required"`
+	Properties           map[string]*Schema `json:"properties"`
+	AdditionalProperties json.RawMessage    `json:"additionalProperties"`
+	Items                *Schema            `json:"items"`
+	ReadOnly             bool               `json:"readOnly"`
+
+	pattern *regexp.Regexp
+}
+
+// Follows $ref through the components and compiles patterns, once per schema
+func (s *Schema) resolve(schemas map[string]*Schema) (*Schema, error) {
+	if s == nil {
+		return nil, nil
+	}
+	if s.Ref != "" {
+		target, ok := schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")]
+		if !ok {
+			return nil, fmt.Errorf("unresolved $ref %s", s.Ref)
+		}
+		return target.resolve(schemas)
+	}
+
+	if s.Pattern != "" && s.pattern == nil {
+		pattern, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", s.Pattern, err)
+		}
+		s.pattern = pattern
+	}
+
+	var err error
+	for name, property := range s.Properties {
+		if s.Properties[name], err = property.resolve(schemas); err != nil {
+			return nil, err
+		}
+	}
+	if s.Items, err = s.Items.resolve(schemas); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Validate checks a decoded JSON value of a request, numbers must be
+// json.Number. Read-only properties are rejected, as the server would
+// otherwise drop them silently. Every violation is returned, not only the
+// first one.
+func (s *Schema) Validate(field string, value any) []problem.Violation {
+	var violations []problem.Violation
+	fail := func(format string, args ...any) {
+		violations = append(violations, problem.Violation{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if s.Type != "" && !hasType(value, s.Type) {
+		fail("must be %s %s", article(s.Type), s.Type)
+		return violations
+	}
+
+	if len(s.Enum) > 0 && !inEnum(value, s.Enum) {
+		fail("must be one of %s", enumList(s.Enum))
+	}
+
+	switch v := value.(type) {
+	case string:
+		length := utf8.RuneCountInString(v)
+		if s.MinLength != nil && length < *s.MinLength {
+			if *s.MinLength == 1 {
+				fail("must not be empty")
+			} else {
+				fail("must be at least %d characters", *s.MinLength)
+			}
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			fail("must be at most %d characters", *s.MaxLength)
+		}
+		if s.pattern != nil && length > 0 && !s.pattern.MatchString(v) {
+			fail("must match %s", s.Pattern)
+		}
+		if msg := checkFormat(s.Format, v); msg != "" {
+			fail("%s", msg)
+		}
+
+	case json.Number:
+		n, _ := v.Float64()
+		if s.Minimum != nil && n < *s.Minimum {
+			fail("must be at least %v", *s.Minimum)
+		}
+		if s.Maximum != nil && n > *s.Maximum {
+			fail("must be at most %v", *s.Maximum)
+		}
+
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				violations = append(violations, problem.Violation{Field: join(field, name), Message: "is required"})
+			}
+		}
+
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			property, ok := s.Properties[name]
+			if !ok {
+				if string(s.AdditionalProperties) == "false" {
+					violations = append(violations, problem.Violation{Field: join(field, name), Message: "is not allowed"})
+				}
+				continue
+			}
+			if property.ReadOnly {
+				violations = append(violations, problem.Violation{Field: join(field, name), Message: "is read-only"})
+				continue
+			}
+			violations = append(violations, property.Validate(join(field, name), v[name])...)
+		}
+
+	case []any:
+		if s.Items != nil {
+			for i, item := range v {
+				violations = append(violations, s.Items.Validate(fmt.Sprintf("%s[%d]", field, i), item)...)
+			}
+		}
+	}
+
+	return violations
+}
+
+func hasType(value any, typ string) bool {
+	switch v := value.(type) {
+	case nil:
+		return typ == "null"
+	case string:
+		return typ == "string"
+	case bool:
+		return typ == "boolean"
+	case json.Number:
+		if typ == "integer" {
+			_, err := v.Int64()
+			return err == nil
+		}
+		return typ == "number"
+	case map[string]any:
+		return typ == "object"
+	case []any:
+		return typ == "array"
+	}
+	return false
+}
+
+func checkFormat(format, value string) string {
+	switch format {
+	case "date-time":
+		if _, err := time.Parse(time.RFC3339, value); err != nil {
+			return "must be an RFC 3339 timestamp"
+		}
+	case "uuid":
+		if !uuid.MatchString(value) {
+			return "must be a UUID"
+		}
+	}
+	return ""
+}
+
+var uuid = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+func inEnum(value any, enum []any) bool {
+	for _, allowed := range enum {
+		if fmt.Sprint(allowed) == fmt.Sprint(value) {
+			return true
+		}
+	}
+	return false
+}
+
+func enumList(enum []any) string {
+	values := make([]string, len(enum))
+	for i, v := range enum {
+		values[i] = fmt.Sprint(v)
+	}
+	return strings.Join(values, ", ")
+}
+
+func article(typ string) string {
+	if typ == "integer" || typ == "object" || typ == "array" {
+		return "an"
+	}
+	return "a"
+}
+
+func join(field, name string) string {
+	if field == "" {
+		return name
+	}
+	return field + "." + name
+}
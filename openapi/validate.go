@@ -0,0 +1,190 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"rest/problem"
+
+	"github.com/gin-gonic/gin"
+)
+
+type parameter struct {
+	Ref      string  `json:"$ref"`
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required"`
+	Schema   *Schema `json:"schema"`
+}
+
+type operation struct {
+	Parameters  []parameter `json:"parameters"`
+	RequestBody *struct {
+		Required bool `json:"required"`
+		Content  map[string]struct {
+			Schema *Schema `json:"schema"`
+		} `json:"content"`
+	} `json:"requestBody"`
+
+	body *Schema
+}
+
+// Operations of the specification by gin route, e.g. "GET /products/:id"
+var operations = mustParse(spec)
+
+var templateParam = regexp.MustCompile(`\{(\w+)\}`)
+
+func mustParse(spec []byte) map[string]*operation {
+	operations, err := parse(spec)
+	if err != nil {
+		panic("openapi: " + err.Error())
+	}
+	return operations
+}
+
+func parse(spec []byte) (map[string]*operation, error) {
+	var document struct {
+		Paths      map[string]map[string]*operation `json:"paths"`
+		Components struct {
+			Parameters map[string]parameter `json:"parameters"`
+			Schemas    map[string]*Schema   `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(spec, &document); err != nil {
+		return nil, err
+	}
+	schemas := document.Components.Schemas
+
+	operations := map[string]*operation{}
+	for path, item := range document.Paths {
+		route := templateParam.ReplaceAllString(path, ":$1")
+
+		for method, op := range item {
+			for i, p := range op.Parameters {
+				if ref := p.Ref; ref != "" {
+					var ok bool
+					if p, ok = document.Components.Parameters[strings.TrimPrefix(ref, "#/components/parameters/")]; !ok {
+						return nil, fmt.Errorf("unresolved $ref %s", ref)
+					}
+				}
+
+				var err error
+				if p.Schema, err = p.Schema.resolve(schemas); err != nil {
+					return nil, err
+				}
+				op.Parameters[i] = p
+			}
+
+			if op.RequestBody != nil {
+				var err error
+				if op.body, err = op.RequestBody.Content["application/json"].Schema.resolve(schemas); err != nil {
+					return nil, err
+				}
+			}
+
+			operations[strings.ToUpper(method)+" "+route] = op
+		}
+	}
+	return operations, nil
+}
+
+// Validate checks the parameters and JSON body of a request against its
+// operation in the specification. All violations are reported together in a
+// single 400 problem; requests to routes the specification does not describe
+// are passed through.
+func Validate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		op, ok := operations[c.Request.Method+" "+c.FullPath()]
+		if !ok {
+			c.Next()
+			return
+		}
+
+		var violations []problem.Violation
+		for _, p := range op.Parameters {
+			var value string
+			switch p.In {
+			case "path":
+				value = c.Param(p.Name)
+			case "query":
+				value = c.Query(p.Name)
+			case "header":
+				value = c.GetHeader(p.Name)
+			}
+
+			field := p.In + "." + p.Name
+			if value == "" {
+				if p.Required {
+					violations = append(violations, problem.Violation{Field: field, Message: "is required"})
+				}
+				continue
+			}
+			if p.Schema != nil {
+				violations = append(violations, p.Schema.Validate(field, convert(value, p.Schema.Type))...)
+			}
+		}
+
+		if op.body != nil {
+			violations = append(violations, validateBody(c, op)...)
+		}
+
+		if len(violations) > 0 {
+			p := problem.New(http.StatusBadRequest, "validation-failed", "The request does not match the API schema")
+			p.Errors = violations
+			problem.AbortWith(c, p)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// Reads and checks the body, leaving it in place for the handler
+func validateBody(c *gin.Context, op *operation) []problem.Violation {
+	var body []byte
+	if c.Request.Body != nil {
+		var err error
+		if body, err = io.ReadAll(c.Request.Body); err != nil {
+			return []problem.Violation{{Field: "body", Message: err.Error()}}
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	if len(bytes.TrimSpace(body)) == 0 {
+		if op.RequestBody.Required {
+			return []problem.Violation{{Field: "body", Message: "is required"}}
+		}
+		return nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return []problem.Violation{{Field: "body", Message: "must be valid JSON"}}
+	}
+	return op.body.Validate("body", value)
+}
+
+// Turns a parameter into the JSON value it stands for, leaving it a string
+// when it does not parse so that the type check reports it
+func convert(value, typ string) any {
+	switch typ {
+	case "integer", "number":
+		if _, err := strconv.ParseFloat(value, 64); err == nil {
+			return json.Number(value)
+		}
+	case "boolean":
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return value
+}
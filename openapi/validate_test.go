@@ -0,0 +1,121 @@
+package openapi
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"rest/problem"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+
+	// echoes the body to show the handler still gets it
+	echo := func(c *gin.Context) {
+		body, _ := io.ReadAll(c.Request.Body)
+		c.Data(http.StatusOK, "application/json", body)
+	}
+	r.GET("/products", Validate(), echo)
+	r.POST("/products", Validate(), echo)
+	r.PUT("/product", Validate(), echo)
+	r.GET("/products/:id", Validate(), echo)
+	r.GET("/unknown", Validate(), echo)
+
+	testCases := []struct {
+		name       string
+		method     string
+		url        string
+		body       string
+		violations []problem.Violation
+	}{
+		{name: "Valid product", method: http.MethodPost, url: "/products", body: `{"id":"31","name":"Laptop","price":"999.99","quantity":"10"}`},
+		{name: "Price and quantity that are not numbers", method: http.MethodPost, url: "/products", body: `{"id":"31","name":"NAME","price":"PRICE","quantity":"QUANTITY"}`, violations: []problem.Violation{
+			{Field: "body.price", Message: "must match ^[0-9]+(\\.[0-9]+)?$"},
+			{Field: "body.quantity", Message: "must match ^[0-9]{1,18}$"},
+		}},
+		{name: "Negative quantity and price with a comma", method: http.MethodPost, url: "/products", body: `{"id":"31","name":"Laptop","price":"9,99","quantity":"-5"}`, violations: []problem.Violation{
+			{Field: "body.price", Message: "must match ^[0-9]+(\\.[0-9]+)?$"},
+			{Field: "body.quantity", Message: "must match ^[0-9]{1,18}$"},
+		}},
+		{name: "Quantity that does not fit an INT", method: http.MethodPost, url: "/products", body: `{"id":"31","name":"Laptop","price":"9.99","quantity":"99999999999999999999"}`, violations: []problem.Violation{
+			{Field: "body.quantity", Message: "must match ^[0-9]{1,18}$"},
+		}},
+		{name: "Whole price", method: http.MethodPost, url: "/products", body: `{"id":"31","name":"Laptop","price":"1000","quantity":"0"}`},
+		{name: "Empty fields", method: http.MethodPost, url: "/products", body: `{"id":"","name":"","price":"","quantity":""}`, violations: []problem.Violation{
+			{Field: "body.id", Message: "must not be empty"},
+			{Field: "body.name", Message: "must not be empty"},
+			{Field: "body.price", Message: "must not be empty"},
+			{Field: "body.quantity", Message: "must not be empty"},
+		}},
+		{name: "Wrong types and unknown fields", method: http.MethodPost, url: "/products", body: `{"id":"a b","name":"Laptop","price":9.99,"qty":"1"}`, violations: []problem.Violation{
+			{Field: "body.quantity", Message: "is required"},
+			{Field: "body.id", Message: "must match ^[A-Za-z0-9._~-]+$"},
+			{Field: "body.price", Message: "must be a string"},
+			{Field: "body.qty", Message: "is not allowed"},
+		}},
+		{name: "Read-only fields", method: http.MethodPost, url: "/products", body: `{"id":"31","name":"Laptop","price":"1","quantity":"1","category_id":"6f1c2a4e-8b0d-4c3a-9f2e-1d5b7a9c0e3f","deleted_at":"2024-01-01T00:00:00Z"}`, violations: []problem.Violation{
+			{Field: "body.category_id", Message: "is read-only"},
+			{Field: "body.deleted_at", Message: "is read-only"},
+		}},
+		{name: "Too long", method: http.MethodPost, url: "/products", body: `{"id":"1","name":"` + strings.Repeat("x", 256) + `","price":"1","quantity":"1"}`, violations: []problem.Violation{
+			{Field: "body.name", Message: "must be at most 255 characters"},
+		}},
+		{name: "Invalid JSON", method: http.MethodPost, url: "/products", body: `{"id":`, violations: []problem.Violation{
+			{Field: "body", Message: "must be valid JSON"},
+		}},
+		{name: "Missing body", method: http.MethodPost, url: "/products", violations: []problem.Violation{
+			{Field: "body", Message: "is required"},
+		}},
+		{name: "Valid pagination", method: http.MethodGet, url: "/products?page=2&limit=30&include_deleted=true"},
+		{name: "Invalid pagination", method: http.MethodGet, url: "/products?page=0&limit=abc&include_deleted=maybe", violations: []problem.Violation{
+			{Field: "query.page", Message: "must be at least 1"},
+			{Field: "query.limit", Message: "must be an integer"},
+			{Field: "query.include_deleted", Message: "must be a boolean"},
+		}},
+		{name: "Limit too high", method: http.MethodGet, url: "/products?limit=1000", violations: []problem.Violation{
+			{Field: "query.limit", Message: "must be at most 100"},
+		}},
+		{name: "Partial update", method: http.MethodPut, url: "/product?id=1", body: `{"price":""}`},
+		{name: "Update with invalid numbers", method: http.MethodPut, url: "/product?id=1", body: `{"price":"1.","quantity":"FULL"}`, violations: []problem.Violation{
+			{Field: "body.price", Message: "must match ^[0-9]+(\\.[0-9]+)?$"},
+			{Field: "body.quantity", Message: "must match ^[0-9]{1,18}$"},
+		}},
+		{name: "Update without id", method: http.MethodPut, url: "/product?id=", body: `{"name":"updated"}`, violations: []problem.Violation{
+			{Field: "query.id", Message: "is required"},
+		}},
+		{name: "Invalid timestamp", method: http.MethodGet, url: "/products/1?as_of=yesterday", violations: []problem.Violation{
+			{Field: "query.as_of", Message: "must be an RFC 3339 timestamp"},
+		}},
+		{name: "Undocumented route", method: http.MethodGet, url: "/unknown?page=abc"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(tc.method, tc.url, strings.NewReader(tc.body)))
+
+			if tc.violations == nil {
+				assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+				assert.Equal(t, tc.body, w.Body.String())
+				return
+			}
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.Equal(t, problem.ContentType, w.Header().Get("Content-Type"))
+
+			var p problem.Problem
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &p))
+			assert.Equal(t, "/problems/validation-failed", p.Type)
+			assert.Equal(t, tc.violations, p.Errors)
+		})
+	}
+}
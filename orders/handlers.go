@@ -0,0 +1,186 @@
+package orders
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"rest/audit"
+	"rest/db"
+	"rest/inventory"
+	"rest/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type orderInput struct {
+	Customer  string           `json:"customer"`
+	Items     []inventory.Item `json:"items"`
+	Reference *string          `json:"reference"`
+}
+
+func respondError(c *gin.Context, err error) {
+	var transition *TransitionError
+	switch {
+	case errors.Is(err, ErrOrderNotFound):
+		utils.ErrorResponse(c, http.StatusNotFound, err.Error())
+	case errors.As(err, &transition):
+		utils.ErrorResponse(c, http.StatusConflict, err.Error())
+	case errors.Is(err, ErrInvalidPrice):
+		utils.ErrorResponse(c, http.StatusUnprocessableEntity, err.Error())
+	default:
+		utils.ErrorResponse(c, inventory.ErrorStatus(err), err.Error())
+	}
+}
+
+// CreateOrder handles POST /orders
+func CreateOrder(database *db.Database, ledger *inventory.Ledger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		database := database.WithContext(c.Request.Context())
+
+		var input orderInput
+		if err := c.ShouldBindJSON(&input); err != nil {
+			utils.ErrorResponse(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		input.Customer = strings.TrimSpace(input.Customer)
+		if input.Customer == "" {
+			utils.ErrorResponse(c, http.StatusBadRequest, "Empty field")
+			return
+		}
+		items, err := inventory.MergeItems(input.Items)
+		if err != nil {
+			utils.ErrorResponse(c, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		o := Order{Customer: input.Customer, Reference: input.Reference, Actor: audit.Actor(c)}
+		err = database.Transaction(func(tx *db.Database) error {
+			// a retried attempt places the order from the request again
+			placed, err := Place(tx, ledger, o, items)
+			if err != nil {
+				return err
+			}
+			o = placed
+			return nil
+		})
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		audit.Record(c, "order", o.ID, nil, o)
+		c.JSON(http.StatusCreated, o)
+	}
+}
+
+// GetOrder handles GET /orders/:id
+func GetOrder(database *db.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		database := database.WithContext(c.Request.Context())
+
+		o, err := Get(database, c.Param("id"), false)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, o)
+	}
+}
+
+// ListOrders handles GET /orders, newest first, filtered by status, customer,
+// product and time
+func ListOrders(database *db.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		database := database.WithContext(c.Request.Context())
+
+		var conditions []string
+		var values []any
+		for _, filter := range []struct{ param, condition string }{
+			{"status", "status = $%d"},
+			{"customer", "customer = $%d"},
+			{"product_id", "id IN (SELECT order_id FROM order_lines WHERE product_id = $%d)"},
+			{"from", "created_at >= $%d"},
+			{"to", "created_at < $%d"},
+		} {
+			if value := c.Query(filter.param); value != "" {
+				values = append(values, value)
+				conditions = append(conditions, fmt.Sprintf(filter.condition, len(values)))
+			}
+		}
+		where := ""
+		if len(conditions) > 0 {
+			where = " WHERE " + strings.Join(conditions, " AND ")
+		}
+
+		limit, offset := utils.Pagination(c)
+		values = append(values, limit, offset)
+		rows, err := database.Query(
+			fmt.Sprintf("SELECT %s FROM orders%s ORDER BY created_at DESC, id LIMIT $%d OFFSET $%d", orderColumns, where, len(values)-1, len(values)),
+			values...,
+		)
+		if err != nil {
+			utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		defer rows.Close()
+
+		orders := []Order{}
+		var ids []string
+		for rows.Next() {
+			o, err := scanOrder(rows)
+			if err != nil {
+				utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+				return
+			}
+			orders = append(orders, o)
+			ids = append(ids, o.ID)
+		}
+		rows.Close()
+
+		lines, err := orderLines(database, ids)
+		if err != nil {
+			utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		for i := range orders {
+			orders[i].Lines = lines[orders[i].ID]
+			if orders[i].Lines == nil {
+				orders[i].Lines = []Line{}
+			}
+		}
+
+		c.JSON(http.StatusOK, orders)
+	}
+}
+
+// UpdateOrderStatus handles PUT /orders/:id/status
+func UpdateOrderStatus(database *db.Database, ledger *inventory.Ledger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		database := database.WithContext(c.Request.Context())
+
+		id := c.Param("id")
+		var input struct {
+			Status string `json:"status"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			utils.ErrorResponse(c, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		var before, after Order
+		err := database.Transaction(func(tx *db.Database) error {
+			var err error
+			before, after, err = SetStatus(tx, ledger, id, input.Status, audit.Actor(c))
+			return err
+		})
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		audit.Record(c, "order", id, gin.H{"status": before.Status}, gin.H{"status": after.Status})
+		c.JSON(http.StatusOK, after)
+	}
+}
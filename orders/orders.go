@@ -0,0 +1,256 @@
+package orders
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"time"
+
+	"rest/db"
+	"rest/inventory"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Order statuses
+const (
+	Pending   = "pending"
+	Paid      = "paid"
+	Shipped   = "shipped"
+	Cancelled = "cancelled"
+)
+
+// The statuses an order can move to from each status, shipped and cancelled
+// orders are final
+var transitions = map[string][]string{
+	Pending: {Paid, Cancelled},
+	Paid:    {Shipped, Cancelled},
+}
+
+var (
+	ErrOrderNotFound = errors.New("Order not found")
+	ErrInvalidPrice  = errors.New("Product price is not a number")
+)
+
+// TransitionError is returned for a status change the order does not allow
+type TransitionError struct {
+	From, To string
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("An order cannot go from %s to %s", e.From, e.To)
+}
+
+// CanTransition reports whether an order may move from one status to another
+func CanTransition(from, to string) bool {
+	return slices.Contains(transitions[from], to)
+}
+
+// Line is a product of an order, with its name and price when it was ordered
+type Line struct {
+	ProductID string `json:"product_id"`
+	Name      string `json:"name"`
+	Price     string `json:"price"`
+	Quantity  int    `json:"quantity"`
+	Total     string `json:"total"`
+}
+
+type Order struct {
+	ID        string    `json:"id"`
+	Status    string    `json:"status"`
+	Customer  string    `json:"customer"`
+	Reference *string   `json:"reference,omitempty"`
+	Lines     []Line    `json:"lines"`
+	Total     string    `json:"total"`
+	Actor     string    `json:"actor"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+const orderColumns = "id::STRING, status, customer, reference, total, actor, created_at, updated_at"
+
+func scanOrder(row pgx.Row) (Order, error) {
+	var o Order
+	err := row.Scan(&o.ID, &o.Status, &o.Customer, &o.Reference, &o.Total, &o.Actor, &o.CreatedAt, &o.UpdatedAt)
+	return o, err
+}
+
+// Prices are stored as strings, totals are worked out exactly and rounded to cents
+func parsePrice(price string) (*big.Rat, bool) {
+	r, ok := new(big.Rat).SetString(price)
+	return r, ok && r.Sign() >= 0
+}
+
+// The name and price of a product when it was ordered
+type snapshot struct {
+	name, price string
+}
+
+// Prices the items and works out the line and order totals
+func price(items []inventory.Item, products map[string]snapshot) ([]Line, string, error) {
+	lines := make([]Line, 0, len(items))
+	total := new(big.Rat)
+	for _, item := range items {
+		product, ok := products[item.ProductID]
+		if !ok {
+			return nil, "", fmt.Errorf("%w: %s", inventory.ErrProductNotFound, item.ProductID)
+		}
+		unit, ok := parsePrice(product.price)
+		if !ok {
+			return nil, "", fmt.Errorf("%w: %s", ErrInvalidPrice, item.ProductID)
+		}
+
+		lineTotal := new(big.Rat).Mul(unit, big.NewRat(int64(item.Quantity), 1))
+		total.Add(total, lineTotal)
+		lines = append(lines, Line{
+			ProductID: item.ProductID,
+			Name:      product.name,
+			Price:     product.price,
+			Quantity:  item.Quantity,
+			Total:     lineTotal.FloatString(2),
+		})
+	}
+	return lines, total.FloatString(2), nil
+}
+
+// Place prices the items, takes them out of stock and stores the order, it
+// must run in a transaction. Every item short of stock is reported together.
+func Place(tx *db.Database, ledger *inventory.Ledger, o Order, items []inventory.Item) (Order, error) {
+	ids := make([]string, len(items))
+	for i, item := range items {
+		ids[i] = item.ProductID
+	}
+
+	// the products stay locked until the ledger takes their stock, so the
+	// order is charged the price they are sold at
+	rows, err := tx.Query("SELECT id, name, price FROM products WHERE id = ANY($1) AND deleted_at IS NULL ORDER BY id FOR UPDATE", ids)
+	if err != nil {
+		return o, err
+	}
+	products := map[string]snapshot{}
+	for rows.Next() {
+		var id string
+		var p snapshot
+		if err := rows.Scan(&id, &p.name, &p.price); err != nil {
+			rows.Close()
+			return o, err
+		}
+		products[id] = p
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return o, err
+	}
+
+	lines, total, err := price(items, products)
+	if err != nil {
+		return o, err
+	}
+
+	o, err = scanOrder(tx.QueryRow(
+		"INSERT INTO orders (status, customer, reference, total, actor) VALUES ($1, $2, $3, $4, $5) RETURNING "+orderColumns,
+		Pending, o.Customer, o.Reference, total, o.Actor,
+	))
+	if err != nil {
+		return o, err
+	}
+	o.Lines = lines
+
+	// the ledger locks each product in ID order, items are sorted
+	reference := "order:" + o.ID
+	var shortages inventory.Shortages
+	for _, line := range o.Lines {
+		_, err := ledger.Apply(tx, inventory.Movement{ProductID: line.ProductID, Type: inventory.Sale, Quantity: -line.Quantity, Reference: &reference, Actor: o.Actor})
+		var insufficient *inventory.InsufficientStockError
+		if errors.As(err, &insufficient) {
+			shortages = append(shortages, insufficient)
+			continue
+		}
+		if err != nil {
+			return o, err
+		}
+
+		err = tx.ExecQuery(
+			"INSERT INTO order_lines (order_id, product_id, name, price, quantity, total) VALUES ($1, $2, $3, $4, $5, $6)",
+			o.ID, line.ProductID, line.Name, line.Price, line.Quantity, line.Total,
+		)
+		if err != nil {
+			return o, err
+		}
+	}
+	if len(shortages) > 0 {
+		return o, shortages
+	}
+	return o, nil
+}
+
+// SetStatus moves an order to a new status, a cancelled order puts its
+// products back in stock. It must run in a transaction.
+func SetStatus(tx *db.Database, ledger *inventory.Ledger, id, status, actor string) (before, after Order, err error) {
+	before, err = Get(tx, id, true)
+	if err != nil {
+		return before, after, err
+	}
+	if !CanTransition(before.Status, status) {
+		return before, after, &TransitionError{From: before.Status, To: status}
+	}
+
+	if status == Cancelled {
+		reference := "order:" + id + ":cancelled"
+		for _, line := range before.Lines {
+			_, err := ledger.Apply(tx, inventory.Movement{ProductID: line.ProductID, Type: inventory.Return, Quantity: line.Quantity, Reason: "order cancelled", Reference: &reference, Actor: actor})
+			// a product deleted since it was ordered is not restocked
+			if err != nil && !errors.Is(err, inventory.ErrProductNotFound) {
+				return before, after, err
+			}
+		}
+	}
+
+	after, err = scanOrder(tx.QueryRow("UPDATE orders SET status = $1, updated_at = now() WHERE id = $2 RETURNING "+orderColumns, status, id))
+	after.Lines = before.Lines
+	return before, after, err
+}
+
+// Get reads an order with its lines, locking it for a status change
+func Get(database *db.Database, id string, lock bool) (Order, error) {
+	sql := "SELECT " + orderColumns + " FROM orders WHERE id = $1"
+	if lock {
+		sql += " FOR UPDATE"
+	}
+
+	o, err := scanOrder(database.QueryRow(sql, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return o, ErrOrderNotFound
+	}
+	if err != nil {
+		return o, err
+	}
+
+	lines, err := orderLines(database, []string{id})
+	o.Lines = lines[id]
+	if o.Lines == nil {
+		o.Lines = []Line{}
+	}
+	return o, err
+}
+
+// Reads the lines of the orders, by order ID
+func orderLines(database *db.Database, ids []string) (map[string][]Line, error) {
+	rows, err := database.Query("SELECT order_id::STRING, product_id, name, price, quantity, total FROM order_lines WHERE order_id = ANY($1::UUID[]) ORDER BY product_id", ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lines := map[string][]Line{}
+	for rows.Next() {
+		var id string
+		var l Line
+		if err := rows.Scan(&id, &l.ProductID, &l.Name, &l.Price, &l.Quantity, &l.Total); err != nil {
+			return nil, err
+		}
+		lines[id] = append(lines[id], l)
+	}
+	return lines, rows.Err()
+}
@@ -0,0 +1,61 @@
+package orders
+
+import (
+	"errors"
+	"testing"
+
+	"rest/inventory"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanTransition(t *testing.T) {
+	testCases := []struct {
+		from, to string
+		allowed  bool
+	}{
+		{Pending, Paid, true},
+		{Pending, Cancelled, true},
+		{Pending, Shipped, false},
+		{Paid, Shipped, true},
+		{Paid, Cancelled, true},
+		{Paid, Pending, false},
+		{Shipped, Cancelled, false},
+		{Cancelled, Paid, false},
+		{Pending, "refunded", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.from+" to "+tc.to, func(t *testing.T) {
+			assert.Equal(t, tc.allowed, CanTransition(tc.from, tc.to))
+		})
+	}
+}
+
+func TestPrice(t *testing.T) {
+	products := map[string]snapshot{
+		"1": {"Apple iPhone 15", "999.99"},
+		"2": {"USB cable", "0.1"},
+		"3": {"Test product", "PRICE"},
+	}
+
+	lines, total, err := price([]inventory.Item{{ProductID: "1", Quantity: 2}, {ProductID: "2", Quantity: 3}}, products)
+	require.NoError(t, err)
+	assert.Equal(t, []Line{
+		{ProductID: "1", Name: "Apple iPhone 15", Price: "999.99", Quantity: 2, Total: "1999.98"},
+		{ProductID: "2", Name: "USB cable", Price: "0.1", Quantity: 3, Total: "0.30"},
+	}, lines)
+	assert.Equal(t, "2000.28", total)
+
+	_, _, err = price([]inventory.Item{{ProductID: "3", Quantity: 1}}, products)
+	assert.True(t, errors.Is(err, ErrInvalidPrice))
+
+	_, _, err = price([]inventory.Item{{ProductID: "4", Quantity: 1}}, products)
+	assert.True(t, errors.Is(err, inventory.ErrProductNotFound))
+}
+
+func TestTransitionError(t *testing.T) {
+	err := &TransitionError{From: Shipped, To: Cancelled}
+	assert.Equal(t, "An order cannot go from shipped to cancelled", err.Error())
+}
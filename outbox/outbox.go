@@ -0,0 +1,205 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"rest/db"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Message is a change waiting in the outbox to be published. Key is the ID
+// of what changed, e.g. the product, so consumers can keep per-key order.
+type Message struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	Key       string          `json:"key"`
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+const messageColumns = "id::STRING, type, key, payload, created_at"
+
+func scanMessage(row pgx.Row) (Message, error) {
+	var m Message
+	err := row.Scan(&m.ID, &m.Type, &m.Key, &m.Payload, &m.CreatedAt)
+	return m, err
+}
+
+// Add writes a message to the outbox. It must run in the transaction of the
+// change it describes, so the message exists exactly when the change was
+// committed and a crash cannot lose it.
+func Add(tx *db.Database, eventType, key string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return tx.ExecQuery("INSERT INTO outbox (type, key, payload) VALUES ($1, $2, $3)", eventType, key, body)
+}
+
+// MaxAttempts is how often a message is tried before it is parked. Parked
+// messages stay in the outbox with their last error and are not published
+// until their attempts are reset, holding back the later messages of their
+// key meanwhile.
+const MaxAttempts = 10
+
+// Backoff is how long to wait before trying a message again after a failed
+// attempt, doubling from a second up to 5 minutes
+func Backoff(attempts int) time.Duration {
+	wait := time.Second
+	for i := 1; i < attempts && wait < 5*time.Minute; i++ {
+		wait *= 2
+	}
+	return min(wait, 5*time.Minute)
+}
+
+// Relay publishes the outbox in order of message ID. IDs come from
+// unique_rowid, which follows the time a message was written rather than when
+// its transaction committed, so a message can be published after a later
+// one of another transaction. Messages of the same key are kept in order: only
+// the oldest unpublished message of a key is sent, so one that fails or is
+// parked holds back the later ones with its key until it is published, while
+// the other keys go ahead.
+//
+// A message is marked published once every publisher accepted it, so
+// publishers may see it more than once when one of them fails or the server
+// stops halfway.
+type Relay struct {
+	database   *db.Database
+	publishers []Publisher
+}
+
+func NewRelay(database *db.Database, publishers []Publisher) *Relay {
+	return &Relay{database: database, publishers: publishers}
+}
+
+// Run publishes pending messages every interval until ctx is done
+func (r *Relay) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := r.Publish(ctx); err != nil {
+			slog.Error("failed to publish outbox", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// pending is a message waiting to be published
+type pending struct {
+	Message
+	attempts int
+}
+
+// result is the outcome of sending one message
+type result struct {
+	id       string
+	attempts int
+	err      error
+}
+
+// BatchSize is how many keys are published at once
+const BatchSize = 100
+
+// The oldest unpublished message of each key, unless it is parked or its
+// backoff has not passed. A parked message keeps its key blocked.
+const pendingQuery = "SELECT " + messageColumns + ", attempts FROM (" +
+	"SELECT DISTINCT ON (key) id, type, key, payload, created_at, attempts, next_attempt_at FROM outbox " +
+	"WHERE published_at IS NULL ORDER BY key, id" +
+	") AS heads WHERE attempts < $1 AND next_attempt_at <= now() ORDER BY id LIMIT $2"
+
+// Publish sends pending messages oldest first and records how each attempt
+// went, returning how many were published. A key whose message was published
+// goes on with its next message until nothing more is sent.
+func (r *Relay) Publish(ctx context.Context) (int, error) {
+	published := 0
+	for ctx.Err() == nil {
+		n, err := r.publishBatch(ctx)
+		published += n
+		if err != nil || n == 0 {
+			return published, err
+		}
+	}
+	return published, nil
+}
+
+func (r *Relay) publishBatch(ctx context.Context) (int, error) {
+	database := r.database.WithContext(ctx)
+
+	rows, err := database.Query(pendingQuery, MaxAttempts, BatchSize)
+	if err != nil {
+		return 0, err
+	}
+	var batch []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.ID, &p.Type, &p.Key, &p.Payload, &p.CreatedAt, &p.attempts); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		batch = append(batch, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	published := 0
+	for _, res := range r.sendBatch(ctx, batch) {
+		if res.err == nil {
+			if err := database.ExecQuery("UPDATE outbox SET attempts = $1, last_error = '', published_at = now() WHERE id = $2", res.attempts, res.id); err != nil {
+				return published, err
+			}
+			published++
+			continue
+		}
+
+		if res.attempts >= MaxAttempts {
+			slog.Error("parked outbox message after too many failed attempts", "message", res.id, "attempts", res.attempts, "error", res.err)
+		} else {
+			slog.Warn("failed to publish outbox message", "message", res.id, "attempt", res.attempts, "error", res.err)
+		}
+		err := database.ExecQuery(
+			"UPDATE outbox SET attempts = $1, last_error = $2, next_attempt_at = now() + $3::INTERVAL WHERE id = $4",
+			res.attempts, res.err.Error(), Backoff(res.attempts).String(), res.id,
+		)
+		if err != nil {
+			return published, err
+		}
+	}
+	return published, nil
+}
+
+// Sends a batch in order, it holds one message per key
+func (r *Relay) sendBatch(ctx context.Context, batch []pending) []result {
+	var results []result
+	for _, p := range batch {
+		err := r.send(ctx, p.Message)
+		results = append(results, result{id: p.ID, attempts: p.attempts + 1, err: err})
+	}
+	return results
+}
+
+func (r *Relay) send(ctx context.Context, m Message) error {
+	for _, p := range r.publishers {
+		if err := p.Publish(ctx, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Purge deletes messages published before the given time, parked messages
+// are kept
+func Purge(database *db.Database, before time.Time) error {
+	return database.ExecQuery("DELETE FROM outbox WHERE published_at < $1", before)
+}
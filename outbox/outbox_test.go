@@ -0,0 +1,251 @@
+package outbox
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"rest/db"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func message(id string) Message {
+	return Message{ID: id, Type: "product.updated", Key: "3", Payload: json.RawMessage(`{"id":"3"}`), CreatedAt: time.Unix(1700000000, 0).UTC()}
+}
+
+func TestStdoutPublisher(t *testing.T) {
+	var out bytes.Buffer
+	p := &StdoutPublisher{out: &out}
+
+	require.NoError(t, p.Publish(context.Background(), message("1")))
+	require.NoError(t, p.Publish(context.Background(), message("2")))
+
+	var ids []string
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		var m Message
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &m))
+		ids = append(ids, m.ID)
+	}
+	assert.Equal(t, []string{"1", "2"}, ids)
+}
+
+func TestFilePublisher(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.jsonl")
+	p := &FilePublisher{Path: path}
+
+	require.NoError(t, p.Publish(context.Background(), message("1")))
+	require.NoError(t, p.Publish(context.Background(), message("2")))
+
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	var received []Message
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var m Message
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &m))
+		received = append(received, m)
+	}
+	assert.Equal(t, []Message{message("1"), message("2")}, received)
+}
+
+func TestHTTPPublisher(t *testing.T) {
+	var received Message
+	status := http.StatusAccepted
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Equal(t, "7", r.Header.Get("Idempotency-Key"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	p := NewHTTPPublisher(server.URL)
+	require.NoError(t, p.Publish(context.Background(), message("7")))
+	assert.Equal(t, message("7"), received)
+
+	status = http.StatusServiceUnavailable
+	assert.Error(t, p.Publish(context.Background(), message("7")))
+}
+
+func TestParsePublishers(t *testing.T) {
+	publishers, err := ParsePublishers("stdout, http,file", "http://localhost/events", "/tmp/outbox.jsonl")
+	require.NoError(t, err)
+	var names []string
+	for _, p := range publishers {
+		names = append(names, p.Name())
+	}
+	assert.Equal(t, []string{"stdout", "http", "file"}, names)
+
+	publishers, err = ParsePublishers("", "", "")
+	require.NoError(t, err)
+	assert.Empty(t, publishers)
+
+	for _, names := range []string{"http", "file", "kafka"} {
+		_, err := ParsePublishers(names, "", "")
+		assert.Error(t, err, names)
+	}
+}
+
+// failingPublisher rejects the messages with the listed IDs
+type failingPublisher struct {
+	failing   map[string]bool
+	published []string
+}
+
+func (p *failingPublisher) Name() string { return "failing" }
+
+func (p *failingPublisher) Publish(ctx context.Context, m Message) error {
+	if p.failing[m.ID] {
+		return errors.New("rejected")
+	}
+	p.published = append(p.published, m.ID)
+	return nil
+}
+
+// fakeOutbox keeps the outbox in memory. Its query returns what pendingQuery
+// selects: the oldest unpublished message of each key, if it is neither
+// parked nor waiting for its backoff.
+type fakeOutbox struct {
+	db.Conn
+	rows []*outboxRow
+	now  time.Time
+}
+
+type outboxRow struct {
+	pending
+	published bool
+	due       time.Time
+}
+
+func (o *fakeOutbox) add(id, key string) {
+	o.rows = append(o.rows, &outboxRow{pending: pending{Message: Message{ID: id, Key: key}}, due: o.now})
+}
+
+func (o *fakeOutbox) row(id string) *outboxRow {
+	for _, r := range o.rows {
+		if r.ID == id {
+			return r
+		}
+	}
+	return nil
+}
+
+func (o *fakeOutbox) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	heads := map[string]bool{}
+	var due []pending
+	for _, r := range o.rows {
+		if r.published || heads[r.Key] {
+			continue
+		}
+		heads[r.Key] = true
+		if r.attempts < args[0].(int) && !r.due.After(o.now) && len(due) < args[1].(int) {
+			due = append(due, r.pending)
+		}
+	}
+	return &fakeRows{rows: due, next: -1}, nil
+}
+
+func (o *fakeOutbox) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	if strings.Contains(sql, "published_at = now()") {
+		r := o.row(args[1].(string))
+		r.attempts, r.published = args[0].(int), true
+		return pgconn.CommandTag{}, nil
+	}
+	wait, err := time.ParseDuration(args[2].(string))
+	if err != nil {
+		return pgconn.CommandTag{}, err
+	}
+	r := o.row(args[3].(string))
+	r.attempts, r.due = args[0].(int), o.now.Add(wait)
+	return pgconn.CommandTag{}, nil
+}
+
+type fakeRows struct {
+	pgx.Rows
+	rows []pending
+	next int
+}
+
+func (r *fakeRows) Next() bool { r.next++; return r.next < len(r.rows) }
+func (r *fakeRows) Close()     {}
+func (r *fakeRows) Err() error { return nil }
+
+func (r *fakeRows) Scan(dest ...any) error {
+	p := r.rows[r.next]
+	*dest[0].(*string), *dest[1].(*string), *dest[2].(*string) = p.ID, p.Type, p.Key
+	*dest[3].(*json.RawMessage), *dest[4].(*time.Time), *dest[5].(*int) = p.Payload, p.CreatedAt, p.attempts
+	return nil
+}
+
+func TestFailingKeyDoesNotHoldBackOthers(t *testing.T) {
+	outbox := &fakeOutbox{now: time.Unix(1700000000, 0)}
+	publisher := &failingPublisher{failing: map[string]bool{}}
+	for i := 1; i <= 2*BatchSize; i++ {
+		id := strconv.Itoa(i)
+		outbox.add(id, "a")
+		publisher.failing[id] = true
+	}
+	outbox.add("201", "b")
+	outbox.add("202", "b")
+	outbox.add("203", "c")
+	database := db.NewDatabase("", "", "", "", "test", outbox)
+	r := NewRelay(&database, []Publisher{publisher})
+
+	published, err := r.Publish(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 3, published)
+	assert.Equal(t, []string{"201", "203", "202"}, publisher.published)
+	assert.Equal(t, 1, outbox.row("1").attempts)
+	assert.Zero(t, outbox.row("2").attempts)
+
+	// the failed message is not due yet and still holds back its key
+	outbox.add("204", "b")
+	published, err = r.Publish(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, published)
+	assert.Equal(t, 1, outbox.row("1").attempts)
+
+	// a parked message keeps holding back its key
+	outbox.row("1").attempts = MaxAttempts
+	outbox.row("1").due = outbox.now
+	for id := range publisher.failing {
+		delete(publisher.failing, id)
+	}
+	publisher.published = nil
+	published, err = r.Publish(context.Background())
+	require.NoError(t, err)
+	assert.Zero(t, published)
+	assert.Empty(t, publisher.published)
+
+	// once its attempts are reset it goes out ahead of the rest of its key
+	outbox.row("1").attempts = 0
+	published, err = r.Publish(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 2*BatchSize, published)
+	assert.Equal(t, []string{"1", "2", "3"}, publisher.published[:3])
+}
+
+func TestBackoff(t *testing.T) {
+	assert.Equal(t, time.Second, Backoff(1))
+	assert.Equal(t, 2*time.Second, Backoff(2))
+	assert.Equal(t, 256*time.Second, Backoff(9))
+	assert.Equal(t, 5*time.Minute, Backoff(10))
+	assert.Equal(t, 5*time.Minute, Backoff(30))
+}
@@ -0,0 +1,136 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Publisher is somewhere outbox messages are published to
+type Publisher interface {
+	Name() string
+	Publish(ctx context.Context, m Message) error
+}
+
+// StdoutPublisher writes every message to standard output as a line of JSON
+type StdoutPublisher struct {
+	out io.Writer
+	mu  sync.Mutex
+}
+
+func NewStdoutPublisher() *StdoutPublisher {
+	return &StdoutPublisher{out: os.Stdout}
+}
+
+func (p *StdoutPublisher) Name() string { return "stdout" }
+
+func (p *StdoutPublisher) Publish(ctx context.Context, m Message) error {
+	line, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, err = p.out.Write(append(line, '\n'))
+	return err
+}
+
+// FilePublisher appends every message to a file as a line of JSON
+type FilePublisher struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+func (p *FilePublisher) Name() string { return "file" }
+
+func (p *FilePublisher) Publish(ctx context.Context, m Message) error {
+	line, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	f, err := os.OpenFile(p.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// HTTPPublisher posts every message as JSON to a URL. The message ID is sent
+// as the Idempotency-Key so the receiver can drop messages it has seen.
+type HTTPPublisher struct {
+	URL    string
+	Client *http.Client
+}
+
+func NewHTTPPublisher(url string) *HTTPPublisher {
+	return &HTTPPublisher{URL: url, Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (p *HTTPPublisher) Name() string { return "http" }
+
+func (p *HTTPPublisher) Publish(ctx context.Context, m Message) error {
+	body, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", m.ID)
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("publisher endpoint responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// ParsePublishers builds the publishers named in a comma separated list such
+// as "stdout,http", the http and file publishers need their URL and path
+func ParsePublishers(names, url, filePath string) ([]Publisher, error) {
+	var publishers []Publisher
+	for _, name := range strings.Split(names, ",") {
+		switch strings.TrimSpace(name) {
+		case "":
+		case "stdout":
+			publishers = append(publishers, NewStdoutPublisher())
+		case "http":
+			if url == "" {
+				return nil, fmt.Errorf("the http outbox publisher needs a URL")
+			}
+			publishers = append(publishers, NewHTTPPublisher(url))
+		case "file":
+			if filePath == "" {
+				return nil, fmt.Errorf("the file outbox publisher needs a path")
+			}
+			publishers = append(publishers, &FilePublisher{Path: filePath})
+		default:
+			return nil, fmt.Errorf("unknown outbox publisher %q, use stdout, http or file", name)
+		}
+	}
+	return publishers, nil
+}
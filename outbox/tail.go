@@ -0,0 +1,121 @@
+package outbox
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"rest/db"
+)
+
+// TailWindow is how far back the tail looks for messages. IDs follow the time
+// a message was written rather than when it was committed, so a message can
+// turn up after ones the tail already handed over. Messages committed later
+// than this after they were written are missed.
+const TailWindow = 30 * time.Second
+
+// Resetter is a publisher whose consumers can be told to start over, because
+// messages may have been missed
+type Resetter interface {
+	Reset()
+}
+
+// Tail hands new outbox messages to a publisher that keeps no state, like the
+// change stream. It follows the outbox with a cursor of its own instead of
+// marking messages published, so it does not wait for the relay and a
+// failing relay publisher cannot hold it up. Messages written before it
+// started are skipped. When it could not poll for longer than TailWindow,
+// e.g. while the database was down, it resets a publisher that is a Resetter.
+type Tail struct {
+	database  *db.Database
+	publisher Publisher
+	seen      map[string]time.Time
+	started   bool
+	polled    time.Time
+}
+
+func NewTail(database *db.Database, publisher Publisher) *Tail {
+	return &Tail{database: database, publisher: publisher, seen: map[string]time.Time{}}
+}
+
+// Run hands over new messages every interval until ctx is done
+func (t *Tail) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := t.Poll(ctx); err != nil {
+			slog.Error("failed to follow the outbox", "publisher", t.publisher.Name(), "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll hands over the messages of the last TailWindow it has not handed over
+// yet, oldest first, returning how many
+func (t *Tail) Poll(ctx context.Context) (int, error) {
+	database := t.database.WithContext(ctx)
+
+	rows, err := database.Query("SELECT "+messageColumns+" FROM outbox WHERE created_at > now() - $1::INTERVAL ORDER BY id", TailWindow.String())
+	if err != nil {
+		return 0, err
+	}
+	var messages []Message
+	for rows.Next() {
+		m, err := scanMessage(rows)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		messages = append(messages, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	return t.handOver(ctx, messages, time.Now()), nil
+}
+
+// Hands over the messages not seen before. One the publisher rejects is
+// tried again on the next poll, as long as it is in the window.
+func (t *Tail) handOver(ctx context.Context, messages []Message, now time.Time) int {
+	for id, at := range t.seen {
+		if now.Sub(at) > 2*TailWindow {
+			delete(t.seen, id)
+		}
+	}
+
+	// messages of the time in between may have left the window unseen
+	if t.started && now.Sub(t.polled) > TailWindow {
+		slog.Warn("outbox tail fell behind, messages may be missed", "publisher", t.publisher.Name(), "since", t.polled)
+		if r, ok := t.publisher.(Resetter); ok {
+			r.Reset()
+		}
+	}
+	t.polled = now
+
+	handed := 0
+	for _, m := range messages {
+		if _, ok := t.seen[m.ID]; ok {
+			continue
+		}
+		if !t.started {
+			t.seen[m.ID] = now
+			continue
+		}
+		if err := t.publisher.Publish(ctx, m); err != nil {
+			slog.Warn("failed to hand over outbox message", "publisher", t.publisher.Name(), "message", m.ID, "error", err)
+			continue
+		}
+		t.seen[m.ID] = now
+		handed++
+	}
+	t.started = true
+	return handed
+}
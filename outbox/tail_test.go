@@ -0,0 +1,55 @@
+package outbox
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTailHandsOverNewMessagesOnce(t *testing.T) {
+	publisher := &failingPublisher{failing: map[string]bool{"4": true}}
+	tail := NewTail(nil, publisher)
+	now := time.Now()
+
+	// messages already there when it starts are skipped
+	assert.Equal(t, 0, tail.handOver(context.Background(), []Message{message("1")}, now))
+
+	// 2 committed after 3 although it was written first
+	assert.Equal(t, 1, tail.handOver(context.Background(), []Message{message("1"), message("3")}, now))
+	assert.Equal(t, 2, tail.handOver(context.Background(), []Message{message("1"), message("2"), message("3"), message("4"), message("5")}, now))
+	assert.Equal(t, []string{"3", "2", "5"}, publisher.published)
+
+	// a rejected message is tried again
+	publisher.failing = nil
+	assert.Equal(t, 1, tail.handOver(context.Background(), []Message{message("2"), message("3"), message("4"), message("5")}, now))
+	assert.Equal(t, []string{"3", "2", "5", "4"}, publisher.published)
+
+	// and forgotten once it is out of the window
+	tail.handOver(context.Background(), nil, now.Add(3*TailWindow))
+	assert.Empty(t, tail.seen)
+}
+
+// resettingPublisher counts how often it was reset
+type resettingPublisher struct {
+	failingPublisher
+	resets int
+}
+
+func (p *resettingPublisher) Reset() { p.resets++ }
+
+func TestTailResetsAfterGap(t *testing.T) {
+	publisher := &resettingPublisher{}
+	tail := NewTail(nil, publisher)
+	now := time.Now()
+
+	tail.handOver(context.Background(), nil, now)
+	tail.handOver(context.Background(), []Message{message("1")}, now.Add(TailWindow))
+	assert.Equal(t, 0, publisher.resets)
+
+	// the polls in between failed for longer than the window
+	assert.Equal(t, 1, tail.handOver(context.Background(), []Message{message("1"), message("2")}, now.Add(3*TailWindow)))
+	assert.Equal(t, 1, publisher.resets)
+	assert.Equal(t, []string{"1", "2"}, publisher.published)
+}
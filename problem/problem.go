@@ -0,0 +1,55 @@
+package problem
+
+import (
+	"net/http"
+
+	"rest/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+const ContentType = "application/problem+json"
+
+// Problem is an RFC 9457 problem details object
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+
+	RequestID string `json:"request_id,omitempty"`
+	TraceID   string `json:"trace_id,omitempty"`
+
+	// Errors lists every reason a request failed validation
+	Errors []Violation `json:"errors,omitempty"`
+}
+
+// Violation is one part of a request that does not match the schema, Field
+// is prefixed with where it was found, e.g. query.page or body.price
+type Violation struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func New(status int, typ, detail string) Problem {
+	return Problem{
+		Type:   "/problems/" + typ,
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	}
+}
+
+// Writes a problem response and aborts the rest of the handler chain
+func Abort(c *gin.Context, status int, typ, detail string) {
+	AbortWith(c, New(status, typ, detail))
+}
+
+// AbortWith is Abort for a problem with extra members such as Errors
+func AbortWith(c *gin.Context, p Problem) {
+	p.RequestID = logging.RequestIDFrom(c)
+	p.TraceID = logging.TraceIDFrom(c)
+
+	c.Header("Content-Type", ContentType)
+	c.AbortWithStatusJSON(p.Status, p)
+}
@@ -0,0 +1,112 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"rest/audit"
+	"rest/problem"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Limit allows Requests per Period, refilled continuously like a token bucket.
+// A client that has been idle can burst up to Requests at once.
+type Limit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// Parses limits written as "<requests>/<period>", e.g. "100/1m"
+func ParseLimit(s string) (Limit, error) {
+	requests, period, found := strings.Cut(s, "/")
+	if !found {
+		return Limit{}, fmt.Errorf("rate limit %q must look like 100/1m", s)
+	}
+
+	n, err := strconv.Atoi(requests)
+	if err != nil || n < 1 {
+		return Limit{}, fmt.Errorf("rate limit %q has an invalid request count", s)
+	}
+
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return Limit{}, fmt.Errorf("rate limit %q has an invalid period", s)
+	}
+
+	return Limit{n, d}, nil
+}
+
+// Seconds it takes to earn back one request
+func (l Limit) interval() time.Duration {
+	return l.Period / time.Duration(l.Requests)
+}
+
+// Result is the state of a bucket after taking a token from it
+type Result struct {
+	Allowed    bool
+	Remaining  int
+	Reset      time.Duration // until the bucket is full again
+	RetryAfter time.Duration // until the next request is allowed
+}
+
+// Store keeps the buckets. The in-memory store is enough for a single
+// instance; a shared store lets several instances enforce one limit.
+type Store interface {
+	Take(key string, limit Limit, now time.Time) (Result, error)
+}
+
+// Identifies the caller by the authenticated actor, falling back to the client IP
+func clientKey(c *gin.Context) string {
+	if actor := c.GetString(audit.ActorKey); actor != "" {
+		return actor
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// Middleware limits each client separately on the route it is attached to
+func Middleware(store Store, limit Limit) gin.HandlerFunc {
+	return limiter(store, limit, func(c *gin.Context) string {
+		return c.Request.Method + " " + c.FullPath() + " " + clientKey(c)
+	})
+}
+
+// PerIP limits each client IP across every route. It goes before
+// authentication, so requests with wrong credentials are counted too and
+// cannot be used to guess them faster than the limit.
+func PerIP(store Store, limit Limit) gin.HandlerFunc {
+	return limiter(store, limit, func(c *gin.Context) string {
+		return "ip " + c.ClientIP()
+	})
+}
+
+func limiter(store Store, limit Limit, key func(*gin.Context) string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		result, err := store.Take(key(c), limit, time.Now())
+		if err != nil {
+			// Don't turn a store outage into an API outage
+			c.Next()
+			return
+		}
+
+		c.Header("RateLimit-Limit", strconv.Itoa(limit.Requests))
+		c.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		c.Header("RateLimit-Reset", strconv.Itoa(seconds(result.Reset)))
+
+		if !result.Allowed {
+			c.Header("Retry-After", strconv.Itoa(seconds(result.RetryAfter)))
+			problem.Abort(c, http.StatusTooManyRequests, "rate-limited", fmt.Sprintf("Rate limit of %d requests per %s exceeded", limit.Requests, limit.Period))
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
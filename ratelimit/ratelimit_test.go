@@ -0,0 +1,110 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLimit(t *testing.T) {
+	limit, err := ParseLimit("100/1m")
+	assert.NoError(t, err)
+	assert.Equal(t, Limit{100, time.Minute}, limit)
+
+	for _, invalid := range []string{"100", "0/1m", "abc/1m", "100/abc", "100/-1s"} {
+		_, err := ParseLimit(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestMemoryStoreRefills(t *testing.T) {
+	store := NewMemoryStore()
+	limit := Limit{2, 2 * time.Second}
+	now := time.Now()
+
+	first, _ := store.Take("key", limit, now)
+	second, _ := store.Take("key", limit, now)
+	third, _ := store.Take("key", limit, now)
+
+	assert.True(t, first.Allowed)
+	assert.True(t, second.Allowed)
+	assert.Equal(t, 0, second.Remaining)
+	assert.False(t, third.Allowed)
+	assert.Equal(t, time.Second, third.RetryAfter)
+
+	// One token is earned back every second
+	later, _ := store.Take("key", limit, now.Add(time.Second))
+	assert.True(t, later.Allowed)
+
+	// Other keys have their own bucket
+	other, _ := store.Take("other", limit, now)
+	assert.True(t, other.Allowed)
+}
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/products", Middleware(NewMemoryStore(), Limit{2, time.Minute}), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	var w *httptest.ResponseRecorder
+	for i := 0; i < 3; i++ {
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/products", nil))
+	}
+
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "2", w.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "30", w.Header().Get("Retry-After"))
+	assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+}
+
+func TestMemoryStoreCleanup(t *testing.T) {
+	store := NewMemoryStore()
+	limit := Limit{2, 2 * time.Second}
+	now := time.Now()
+
+	store.Take("busy", limit, now)
+	store.Take("busy", limit, now)
+	store.Take("idle", limit, now.Add(-time.Minute))
+
+	// busy needs two seconds to be full again
+	store.Cleanup(now.Add(time.Second))
+	assert.Contains(t, store.buckets, "busy")
+	assert.NotContains(t, store.buckets, "idle")
+
+	store.Cleanup(now.Add(2 * time.Second))
+	assert.Empty(t, store.buckets)
+}
+
+func TestPerIP(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	// stands in for authentication turning every request away
+	r.Use(PerIP(NewMemoryStore(), Limit{2, time.Minute}), func(c *gin.Context) {
+		c.AbortWithStatus(http.StatusUnauthorized)
+	})
+	r.GET("/products", func(c *gin.Context) {})
+	r.GET("/orders", func(c *gin.Context) {})
+
+	var codes []int
+	for _, path := range []string{"/products", "/orders", "/products"} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		codes = append(codes, w.Code)
+	}
+	assert.Equal(t, []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests}, codes)
+
+	// another IP has its own bucket
+	req := httptest.NewRequest(http.MethodGet, "/products", nil)
+	req.RemoteAddr = "198.51.100.7:1234"
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
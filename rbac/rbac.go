@@ -0,0 +1,104 @@
+package rbac
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+
+	"rest/audit"
+	"rest/problem"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Permission string
+
+const (
+	Read     Permission = "read"
+	Write    Permission = "write"
+	Delete   Permission = "delete"
+	Import   Permission = "import"
+	Audit    Permission = "audit"
+	Webhooks Permission = "webhooks"
+)
+
+type Role string
+
+const (
+	Viewer Role = "viewer"
+	Editor Role = "editor"
+	Admin  Role = "admin"
+)
+
+var rolePermissions = map[Role][]Permission{
+	Viewer: {Read},
+	Editor: {Read, Write, Import},
+	Admin:  {Read, Write, Delete, Import, Audit, Webhooks},
+}
+
+func ParseRole(s string) (Role, error) {
+	role := Role(s)
+	if _, ok := rolePermissions[role]; !ok {
+		return "", fmt.Errorf("unknown role %q", s)
+	}
+	return role, nil
+}
+
+// Allows reports whether any of the roles grants the permission
+func Allows(roles []Role, permission Permission) bool {
+	for _, role := range roles {
+		if slices.Contains(rolePermissions[role], permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// RoleSource looks up the roles assigned to an actor, as set by the
+// authentication middleware
+type RoleSource interface {
+	Roles(actor string) ([]Role, error)
+}
+
+// Authorizer builds per-route middleware. A nil source disables the checks,
+// which is how the API runs when authentication is off.
+type Authorizer struct {
+	source RoleSource
+}
+
+func New(source RoleSource) *Authorizer {
+	return &Authorizer{source}
+}
+
+func (a *Authorizer) Require(permission Permission) gin.HandlerFunc {
+	return a.RequireIf(func(*gin.Context) bool { return true }, permission)
+}
+
+// RequireIf only checks the permission for requests matching cond
+func (a *Authorizer) RequireIf(cond func(*gin.Context) bool, permission Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if a.source == nil || !cond(c) {
+			c.Next()
+			return
+		}
+
+		actor := c.GetString(audit.ActorKey)
+		if actor == "" {
+			problem.Abort(c, http.StatusUnauthorized, "missing-credentials", "The request is not authenticated")
+			return
+		}
+
+		roles, err := a.source.Roles(actor)
+		if err != nil {
+			problem.Abort(c, http.StatusInternalServerError, "authorization-error", err.Error())
+			return
+		}
+
+		if !Allows(roles, permission) {
+			problem.Abort(c, http.StatusForbidden, "forbidden", fmt.Sprintf("%s does not have the %s permission", actor, permission))
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,70 @@
+package rbac
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"rest/audit"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequire(t *testing.T) {
+	source := ConfigSource{
+		"apikey:viewer": {Viewer},
+		"apikey:editor": {Editor},
+		"apikey:admin":  {Admin},
+	}
+
+	testCases := []struct {
+		name           string
+		actor          string
+		permission     Permission
+		expectedStatus int
+	}{
+		{name: "Viewer can read", actor: "apikey:viewer", permission: Read, expectedStatus: http.StatusOK},
+		{name: "Viewer cannot write", actor: "apikey:viewer", permission: Write, expectedStatus: http.StatusForbidden},
+		{name: "Editor can write", actor: "apikey:editor", permission: Write, expectedStatus: http.StatusOK},
+		{name: "Editor cannot delete", actor: "apikey:editor", permission: Delete, expectedStatus: http.StatusForbidden},
+		{name: "Editor can import", actor: "apikey:editor", permission: Import, expectedStatus: http.StatusOK},
+		{name: "Viewer cannot import", actor: "apikey:viewer", permission: Import, expectedStatus: http.StatusForbidden},
+		{name: "Admin can read the audit log", actor: "apikey:admin", permission: Audit, expectedStatus: http.StatusOK},
+		{name: "Unknown actor has no roles", actor: "apikey:nobody", permission: Read, expectedStatus: http.StatusForbidden},
+		{name: "Unauthenticated", actor: "", permission: Read, expectedStatus: http.StatusUnauthorized},
+	}
+
+	gin.SetMode(gin.TestMode)
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := gin.New()
+			r.GET("/products", func(c *gin.Context) {
+				if tc.actor != "" {
+					c.Set(audit.ActorKey, tc.actor)
+				}
+			}, New(source).Require(tc.permission), func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/products", nil))
+
+			assert.Equal(t, tc.expectedStatus, w.Code)
+		})
+	}
+}
+
+func TestDisabledAuthorizerAllowsEverything(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.DELETE("/product", New(nil).Require(Delete), func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/product", nil))
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+}
@@ -0,0 +1,90 @@
+package rbac
+
+import (
+	"encoding/json"
+	"os"
+
+	"rest/db"
+)
+
+// ConfigSource holds role assignments keyed by actor, e.g. "jwt:alice"
+type ConfigSource map[string][]Role
+
+// Reads a JSON file of the form {"apikey:<key ID>": ["editor"], "jwt:alice": ["admin"]}
+func LoadConfig(path string) (ConfigSource, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string][]string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	source := ConfigSource{}
+	for actor, names := range raw {
+		for _, name := range names {
+			role, err := ParseRole(name)
+			if err != nil {
+				return nil, err
+			}
+			source[actor] = append(source[actor], role)
+		}
+	}
+	return source, nil
+}
+
+func (s ConfigSource) Roles(actor string) ([]Role, error) {
+	return s[actor], nil
+}
+
+// DBSource reads role assignments from the role_assignments table
+type DBSource struct {
+	db *db.Database
+}
+
+func NewDBSource(db *db.Database) *DBSource {
+	return &DBSource{db}
+}
+
+func (s *DBSource) Roles(actor string) ([]Role, error) {
+	rows, err := s.db.Query("SELECT role FROM role_assignments WHERE actor = $1", actor)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roles []Role
+	for rows.Next() {
+		var role Role
+		if err := rows.Scan(&role); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+func (s *DBSource) Grant(actor string, role Role) error {
+	return s.db.ExecQuery("UPSERT INTO role_assignments (actor, role) VALUES ($1, $2)", actor, role)
+}
+
+func (s *DBSource) Revoke(actor string, role Role) error {
+	return s.db.ExecQuery("DELETE FROM role_assignments WHERE actor = $1 AND role = $2", actor, role)
+}
+
+// Sources combines the roles of several sources
+type Sources []RoleSource
+
+func (s Sources) Roles(actor string) ([]Role, error) {
+	var roles []Role
+	for _, source := range s {
+		r, err := source.Roles(actor)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, r...)
+	}
+	return roles, nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"time"
+
+	"rest/alerts"
+	"rest/audit"
+	"rest/catalog"
+	"rest/db"
+	"rest/idempotency"
+	"rest/inventory"
+	"rest/logging"
+	"rest/metrics"
+	"rest/openapi"
+	"rest/orders"
+	"rest/ratelimit"
+	"rest/rbac"
+	"rest/search"
+	"rest/stream"
+	"rest/tracing"
+	"rest/webhooks"
+	"rest/ws"
+
+	"github.com/gin-gonic/gin"
+)
+
+// server holds what the routes need, it is built once in main
+type server struct {
+	database     *db.Database
+	metrics      *metrics.Metrics
+	idempotency  idempotency.Store
+	limits       ratelimit.Store
+	searcher     search.Searcher
+	authenticate gin.HandlerFunc // nil when authentication is off
+	roles        rbac.RoleSource // nil when authentication is off
+
+	// proxies whose X-Forwarded-For is believed, none when empty
+	trustedProxies []string
+
+	reservationTTL time.Duration // inventory.DefaultTTL when zero
+	changes        *stream.Hub
+	heartbeat      time.Duration // stream.DefaultHeartbeat when zero
+	sockets        *ws.Server
+}
+
+// Registers every route, each one must be described in openapi/openapi.json
+func (s *server) router() *gin.Engine {
+	database := s.database
+
+	r := gin.New()
+	// the client IP is used for rate limits and the audit log, so it may only
+	// come from X-Forwarded-For when a trusted proxy set the header
+	if err := r.SetTrustedProxies(s.trustedProxies); err != nil {
+		fatal("invalid trusted proxies", "variable", "TRUSTED_PROXIES", "error", err)
+	}
+	r.Use(logging.RequestID(), tracing.Middleware(), logging.AccessLog(), s.metrics.Middleware(), gin.Recovery())
+	r.Use(audit.Middleware(database))
+
+	// operational endpoints stay outside authentication
+	r.GET("/openapi.json", openapi.Spec)
+	r.GET("/docs", openapi.Docs)
+
+	// every IP is limited before authentication, so failed attempts count
+	// too, and after it every client gets its own bucket per route
+	authenticate := []gin.HandlerFunc{}
+	if s.authenticate != nil {
+		authenticate = append(authenticate, ratelimit.PerIP(s.limits, limitEnv("RATE_LIMIT_IP", "1200/1m")), s.authenticate)
+	}
+
+	api := r.Group("")
+	api.Use(authenticate...)
+
+	authz := rbac.New(s.roles)
+	read := authz.Require(rbac.Read)
+	write := authz.Require(rbac.Write)
+	readDeleted := authz.RequireIf(includeDeleted, rbac.Delete)
+
+	// requests are checked against openapi/openapi.json once the caller is allowed to make them
+	valid := openapi.Validate()
+
+	// listing is cheaper to abuse because of the COUNT(*)
+	listLimit := ratelimit.Middleware(s.limits, limitEnv("RATE_LIMIT_LIST", "120/1m"))
+	readLimit := ratelimit.Middleware(s.limits, limitEnv("RATE_LIMIT_READ", "600/1m"))
+	writeLimit := ratelimit.Middleware(s.limits, limitEnv("RATE_LIMIT_WRITE", "120/1m"))
+
+	// POSTs creating a resource can be retried with an Idempotency-Key
+	idempotent := idempotency.Middleware(s.idempotency, 24*time.Hour)
+
+	// browsers cannot set headers on a WebSocket, so /ws also takes its
+	// credentials from the Sec-WebSocket-Protocol header before authenticating
+	socket := append([]gin.HandlerFunc{ws.Credentials()}, authenticate...)
+	r.GET("/ws", append(socket, readLimit, read, valid, s.sockets.Handler())...)
+
+	api.GET("/products", listLimit, read, readDeleted, valid, getProducts(database))
+	api.GET("/products/search", listLimit, read, valid, search.Handler(s.searcher))
+	api.GET("/products/stream", listLimit, read, valid, stream.Handler(s.changes, database, s.heartbeat))
+	api.GET("/product", readLimit, read, readDeleted, valid, getProduct(database))
+	api.GET("/products/:id", readLimit, read, readDeleted, valid, getProduct(database))
+	api.GET("/products/:id/history", readLimit, read, valid, getProductHistory(database))
+	api.POST("/products", writeLimit, write, valid, idempotent, addProduct(database))
+	api.POST("/products/import", writeLimit, authz.Require(rbac.Import), valid, idempotent, importProducts(database))
+	ledger := inventory.NewLedger(stockChanged)
+	api.PUT("/product", writeLimit, write, valid, updatePruduct(database, ledger))
+	api.DELETE("/product", writeLimit, authz.Require(rbac.Delete), valid, deleteProduct(database))
+	api.POST("/products/:id/restore", writeLimit, authz.Require(rbac.Delete), valid, restoreProduct(database))
+	api.GET("/categories", readLimit, read, valid, catalog.ListCategories(database))
+	api.GET("/categories/:id", readLimit, read, valid, catalog.GetCategory(database))
+	api.GET("/categories/:id/products", listLimit, read, readDeleted, valid, getCategoryProducts(database))
+	api.POST("/categories", writeLimit, write, valid, idempotent, catalog.CreateCategory(database))
+	api.PUT("/categories/:id", writeLimit, write, valid, catalog.UpdateCategory(database))
+	api.DELETE("/categories/:id", writeLimit, authz.Require(rbac.Delete), valid, catalog.DeleteCategory(database, publishProduct))
+	api.PUT("/products/:id/category", writeLimit, write, valid, catalog.SetProductCategory(database, publishProduct))
+	api.GET("/products/low-stock", readLimit, read, valid, alerts.ListLowStock(database))
+	api.PUT("/products/:id/threshold", writeLimit, write, valid, alerts.SetThreshold(database, publishProduct))
+	api.GET("/alerts", readLimit, read, valid, alerts.ListAlerts(database))
+	api.GET("/products/:id/stock", readLimit, read, valid, inventory.GetStock(database))
+	api.GET("/products/:id/stock-movements", readLimit, read, valid, inventory.ListMovements(database))
+	api.POST("/products/:id/stock-movements", writeLimit, write, valid, idempotent, inventory.CreateMovement(database, ledger))
+	api.POST("/reservations", writeLimit, write, valid, idempotent, inventory.CreateReservation(database, s.reservationTTL))
+	api.GET("/reservations/:id", readLimit, read, valid, inventory.GetReservation(database))
+	api.POST("/reservations/:id/confirm", writeLimit, write, valid, inventory.ConfirmReservation(database, ledger))
+	api.POST("/reservations/:id/release", writeLimit, write, valid, inventory.ReleaseReservation(database))
+	api.GET("/orders", readLimit, read, valid, orders.ListOrders(database))
+	api.GET("/orders/:id", readLimit, read, valid, orders.GetOrder(database))
+	api.POST("/orders", writeLimit, write, valid, idempotent, orders.CreateOrder(database, ledger))
+	api.PUT("/orders/:id/status", writeLimit, write, valid, orders.UpdateOrderStatus(database, ledger))
+	api.GET("/tags", readLimit, read, valid, catalog.ListTags(database))
+	api.GET("/products/:id/tags", readLimit, read, valid, catalog.GetProductTags(database))
+	api.PUT("/products/:id/tags", writeLimit, write, valid, catalog.SetProductTags(database, publishProduct))
+	api.DELETE("/products/:id/tags/:tag", writeLimit, write, valid, catalog.RemoveProductTag(database, publishProduct))
+	hooks := authz.Require(rbac.Webhooks)
+	api.GET("/webhooks", readLimit, hooks, valid, webhooks.ListSubscriptions(database))
+	api.GET("/webhooks/:id", readLimit, hooks, valid, webhooks.GetSubscription(database))
+	api.POST("/webhooks", writeLimit, hooks, valid, idempotent, webhooks.CreateSubscription(database))
+	api.PUT("/webhooks/:id", writeLimit, hooks, valid, webhooks.UpdateSubscription(database))
+	api.DELETE("/webhooks/:id", writeLimit, hooks, valid, webhooks.DeleteSubscription(database))
+	api.GET("/webhooks/:id/deliveries", listLimit, hooks, valid, webhooks.ListDeliveries(database))
+	api.POST("/webhooks/:id/deliveries/:delivery_id/redeliver", writeLimit, hooks, valid, webhooks.Redeliver(database))
+	api.GET("/audit", readLimit, authz.Require(rbac.Audit), valid, audit.List(database))
+
+	return r
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"rest/db"
+	"rest/metrics"
+	"rest/openapi"
+	"rest/ratelimit"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var pathParam = regexp.MustCompile(`:(\w+)`)
+
+func TestRoutesAreDocumented(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	srv := &server{
+		database: &db.Database{},
+		metrics:  metrics.New(),
+		limits:   ratelimit.NewMemoryStore(),
+	}
+
+	spec, err := openapi.Load()
+	require.NoError(t, err)
+
+	for _, route := range srv.router().Routes() {
+		path := pathParam.ReplaceAllString(route.Path, "{$1}")
+		_, ok := spec.Paths[path][strings.ToLower(route.Method)]
+		assert.True(t, ok, "%s %s is missing from openapi/openapi.json", route.Method, path)
+	}
+}
+
+// Sends GET /products from remoteAddr and returns how many requests its bucket has left
+func remaining(t *testing.T, r *gin.Engine, remoteAddr, forwardedFor string) string {
+	// an invalid page is rejected after the rate limit, before the database
+	req := httptest.NewRequest(http.MethodGet, "/products?page=first", nil)
+	req.RemoteAddr = remoteAddr
+	req.Header.Set("X-Forwarded-For", forwardedFor)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	return w.Header().Get("RateLimit-Remaining")
+}
+
+func TestForwardedForNeedsTrustedProxy(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	srv := &server{
+		database: &db.Database{},
+		metrics:  metrics.New(),
+		limits:   ratelimit.NewMemoryStore(),
+	}
+	r := srv.router()
+
+	assert.Equal(t, "119", remaining(t, r, "192.0.2.1:1234", "198.51.100.1"))
+	assert.Equal(t, "118", remaining(t, r, "192.0.2.1:1234", "198.51.100.2"), "a spoofed X-Forwarded-For must not get a new bucket")
+
+	srv.limits = ratelimit.NewMemoryStore()
+	srv.trustedProxies = []string{"192.0.2.1"}
+	r = srv.router()
+
+	assert.Equal(t, "119", remaining(t, r, "192.0.2.1:1234", "198.51.100.1"))
+	assert.Equal(t, "119", remaining(t, r, "192.0.2.1:1234", "198.51.100.2"))
+	assert.Equal(t, "118", remaining(t, r, "192.0.2.1:1234", "198.51.100.1"))
+}
+
+func TestFailedAuthenticationIsRateLimited(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("RATE_LIMIT_IP", "2/1m")
+	srv := &server{
+		database: &db.Database{},
+		metrics:  metrics.New(),
+		limits:   ratelimit.NewMemoryStore(),
+		authenticate: func(c *gin.Context) {
+			c.AbortWithStatus(http.StatusUnauthorized)
+		},
+	}
+	r := srv.router()
+
+	var codes []int
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/products", nil))
+		codes = append(codes, w.Code)
+	}
+	assert.Equal(t, []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests}, codes)
+}
+
+func TestCategoryIDsMustBeUUIDs(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	srv := &server{
+		database: &db.Database{},
+		metrics:  metrics.New(),
+		limits:   ratelimit.NewMemoryStore(),
+	}
+	r := srv.router()
+
+	// rejected by the OpenAPI validation before the database is asked
+	for _, path := range []string{"/categories/phones", "/categories/phones/products", "/products?category=phones", "/products/stream?category_id=phones"} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, http.StatusBadRequest, w.Code, path)
+	}
+}
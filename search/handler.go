@@ -0,0 +1,29 @@
+package search
+
+import (
+	"net/http"
+
+	"rest/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Handler handles GET /products/search?q=, paginated with page and limit
+func Handler(searcher Searcher) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query := c.Query("q")
+		if query == "" {
+			utils.ErrorResponse(c, http.StatusBadRequest, "q is required")
+			return
+		}
+
+		limit, offset := utils.Pagination(c)
+		hits, err := searcher.Search(c.Request.Context(), query, limit, offset)
+		if err != nil {
+			utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, hits)
+	}
+}
@@ -0,0 +1,40 @@
+package search
+
+import (
+	"context"
+	"sync"
+)
+
+// Memory keeps the documents in a map and scans them all on every search.
+// It is meant for tests and small catalogs.
+type Memory struct {
+	mu   sync.RWMutex
+	docs map[string]Document
+}
+
+func NewMemory() *Memory {
+	return &Memory{docs: map[string]Document{}}
+}
+
+func (m *Memory) Add(doc Document) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.docs[doc.ID] = doc
+}
+
+func (m *Memory) Remove(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.docs, id)
+}
+
+func (m *Memory) Search(_ context.Context, query string, limit, offset int) ([]Hit, error) {
+	m.mu.RLock()
+	docs := make([]Document, 0, len(m.docs))
+	for _, doc := range m.docs {
+		docs = append(docs, doc)
+	}
+	m.mu.RUnlock()
+
+	return Rank(query, docs, limit, offset), nil
+}
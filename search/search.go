@@ -0,0 +1,231 @@
+package search
+
+import (
+	"context"
+	"html"
+	"sort"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Document is a product as far as search is concerned
+type Document struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Price    string `json:"price"`
+	Quantity string `json:"quantity"`
+}
+
+// Hit is a matching product with its relevance, from 0 to 1, and its name
+// with the matched words wrapped in <mark>, HTML escaped
+type Hit struct {
+	Document
+	Score     float64 `json:"score"`
+	Highlight string  `json:"highlight"`
+}
+
+// Searcher finds products by name, best matches first
+type Searcher interface {
+	Search(ctx context.Context, query string, limit, offset int) ([]Hit, error)
+}
+
+// Normalize lowercases a word and strips its accents, so that Café matches cafe
+func Normalize(s string) string {
+	t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	normalized, _, err := transform.String(t, s)
+	if err != nil {
+		normalized = s
+	}
+	return strings.ToLower(normalized)
+}
+
+type token struct {
+	term       string // normalized
+	start, end int    // byte offsets in the original text
+}
+
+// Splits text into words of letters and digits
+func tokenize(text string) []token {
+	var tokens []token
+	start := -1
+	for i, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			tokens = append(tokens, token{Normalize(text[start:i]), start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, token{Normalize(text[start:]), start, len(text)})
+	}
+	return tokens
+}
+
+// Terms returns the distinct normalized words of a text, as they are indexed
+func Terms(text string) []string {
+	seen := map[string]bool{}
+	var terms []string
+	for _, t := range tokenize(text) {
+		if !seen[t.term] {
+			seen[t.term] = true
+			terms = append(terms, t.term)
+		}
+	}
+	return terms
+}
+
+// Typos allowed in a query word of this many characters
+func maxEdits(length int) int {
+	switch {
+	case length < 4:
+		return 0
+	case length < 8:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// How well a query word matches a word of the name: exactly, as a prefix
+// while the user is still typing, or with a typo or two
+func matchScore(query, word string) float64 {
+	switch {
+	case query == word:
+		return 1
+	case strings.HasPrefix(word, query):
+		return 0.8
+	}
+
+	q, w := []rune(query), []rune(word)
+	allowed := maxEdits(len(q))
+	if allowed == 0 {
+		return 0
+	}
+	// a typo in a prefix, e.g. iphn for iphone
+	if len(w) > len(q) {
+		w = w[:min(len(q)+allowed, len(w))]
+	}
+	if d := distance(q, w); d <= allowed {
+		return 0.6 - 0.1*float64(d-1)
+	}
+	return 0
+}
+
+// Edit distance counting a swap of two neighbouring letters as one edit
+func distance(a, b []rune) int {
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(a)][len(b)]
+}
+
+// Scores a document against the query words, every word has to match. The
+// second value is false when the document does not match.
+func score(query []token, doc Document) (Hit, bool) {
+	words := tokenize(doc.Name)
+	matched := make([]bool, len(words))
+
+	total := 0.0
+	for _, q := range query {
+		best, bestWord := 0.0, -1
+		for i, w := range words {
+			if s := matchScore(q.term, w.term); s > best {
+				best, bestWord = s, i
+			}
+		}
+		if bestWord < 0 {
+			return Hit{}, false
+		}
+		matched[bestWord] = true
+		total += best
+	}
+
+	// among equal matches, names with fewer other words rank higher
+	coverage := float64(len(query)) / float64(max(len(words), len(query)))
+	s := 0.9*total/float64(len(query)) + 0.1*coverage
+
+	return Hit{Document: doc, Score: float64(int(s*1000+0.5)) / 1000, Highlight: highlight(doc.Name, words, matched)}, true
+}
+
+func highlight(name string, words []token, matched []bool) string {
+	var b strings.Builder
+	last := 0
+	for i, w := range words {
+		if !matched[i] {
+			continue
+		}
+		b.WriteString(html.EscapeString(name[last:w.start]))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(name[w.start:w.end]))
+		b.WriteString("</mark>")
+		last = w.end
+	}
+	b.WriteString(html.EscapeString(name[last:]))
+	return b.String()
+}
+
+// Rank scores candidates against the query and returns the requested page of
+// the matches, best first
+func Rank(query string, candidates []Document, limit, offset int) []Hit {
+	return page(best(tokenize(query), candidates, nil, len(candidates)), limit, offset)
+}
+
+// Scores the candidates and merges the matches into hits, keeping the n best.
+// Ties are broken by ID, so the result does not depend on the order in which
+// candidates come in. A negative n keeps none.
+func best(query []token, candidates []Document, hits []Hit, n int) []Hit {
+	if n < 0 {
+		return []Hit{}
+	}
+	if len(query) == 0 {
+		return hits
+	}
+
+	for _, doc := range candidates {
+		if hit, ok := score(query, doc); ok {
+			hits = append(hits, hit)
+		}
+	}
+
+	sort.SliceStable(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].ID < hits[j].ID
+	})
+	return hits[:min(n, len(hits))]
+}
+
+func page(hits []Hit, limit, offset int) []Hit {
+	if offset < 0 || limit < 0 || offset >= len(hits) {
+		return []Hit{}
+	}
+	return hits[offset:min(offset+limit, len(hits))]
+}
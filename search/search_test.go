@@ -0,0 +1,145 @@
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func catalog() *Memory {
+	m := NewMemory()
+	for _, doc := range []Document{
+		{ID: "1", Name: "Apple iPhone 15", Price: "999.99", Quantity: "150"},
+		{ID: "2", Name: "Samsung Galaxy S23", Price: "849.99", Quantity: "200"},
+		{ID: "3", Name: "Sony PlayStation 5", Price: "499.99", Quantity: "75"},
+		{ID: "4", Name: "Apple iPhone 15 Pro Max", Price: "1199.99", Quantity: "40"},
+		{ID: "5", Name: "Crème Brûlée Torch", Price: "29.99", Quantity: "12"},
+		{ID: "6", Name: "<b>Bold</b> Headphones", Price: "9.99", Quantity: "1"},
+	} {
+		m.Add(doc)
+	}
+	return m
+}
+
+func ids(hits []Hit) []string {
+	result := []string{}
+	for _, h := range hits {
+		result = append(result, h.ID)
+	}
+	return result
+}
+
+func TestSearch(t *testing.T) {
+	testCases := []struct {
+		name      string
+		query     string
+		expected  []string
+		highlight string
+	}{
+		{name: "Exact word, shorter name first", query: "iphone", expected: []string{"1", "4"}, highlight: "Apple <mark>iPhone</mark> 15"},
+		{name: "Case insensitive, every word must match", query: "APPLE pro", expected: []string{"4"}, highlight: "<mark>Apple</mark> iPhone 15 <mark>Pro</mark> Max"},
+		{name: "Prefix", query: "play", expected: []string{"3"}, highlight: "Sony <mark>PlayStation</mark> 5"},
+		{name: "Typo", query: "samsnug", expected: []string{"2"}, highlight: "<mark>Samsung</mark> Galaxy S23"},
+		{name: "Typo in a prefix", query: "galxy s23", expected: []string{"2"}},
+		{name: "Accent insensitive", query: "creme brulee", expected: []string{"5"}, highlight: "<mark>Crème</mark> <mark>Brûlée</mark> Torch"},
+		{name: "Highlight is escaped", query: "bold", expected: []string{"6"}, highlight: "&lt;b&gt;<mark>Bold</mark>&lt;/b&gt; Headphones"},
+		{name: "Short words need an exact prefix", query: "sxy", expected: []string{}},
+		{name: "No words", query: "!!", expected: []string{}},
+	}
+
+	m := catalog()
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			hits, err := m.Search(context.Background(), tc.query, 10, 0)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, ids(hits))
+			if tc.highlight != "" {
+				assert.Equal(t, tc.highlight, hits[0].Highlight)
+			}
+		})
+	}
+}
+
+func TestRanking(t *testing.T) {
+	m := catalog()
+	m.Add(Document{ID: "7", Name: "iPhone"})
+	m.Add(Document{ID: "8", Name: "iPhome Case"})
+
+	hits, err := m.Search(context.Background(), "iphone", 10, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"7", "1", "4", "8"}, ids(hits), "exact before longer names before typos")
+	assert.Equal(t, 1.0, hits[0].Score)
+
+	page, err := m.Search(context.Background(), "iphone", 2, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"4", "8"}, ids(page))
+
+	m.Remove("7")
+	hits, err = m.Search(context.Background(), "iphone", 10, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1", "4", "8"}, ids(hits))
+}
+
+func TestRankingInBatches(t *testing.T) {
+	var docs []Document
+	for i := 0; i < 50; i++ {
+		docs = append(docs, Document{ID: fmt.Sprintf("%02d", i), Name: fmt.Sprintf("Case %d for iPhone", i%3)})
+	}
+	query := tokenize("iphone case")
+	expected := Rank("iphone case", docs, 5, 10)
+
+	// as the database searcher reads candidates, keeping the best offset+limit
+	var hits []Hit
+	for start := 0; start < len(docs); start += 7 {
+		hits = best(query, docs[start:min(start+7, len(docs))], hits, 15)
+	}
+	assert.Equal(t, expected, page(hits, 5, 10))
+}
+
+func TestHugePage(t *testing.T) {
+	// an offset+limit that overflowed keeps no hits instead of panicking
+	docs := []Document{{ID: "1", Name: "Apple iPhone 15"}}
+	assert.Empty(t, best(tokenize("iphone"), docs, nil, math.MinInt+99))
+	assert.Empty(t, page(Rank("iphone", docs, 10, 0), 100, math.MinInt))
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/products/search", Handler(catalog()))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/products/search?q=iphone&page=100000000000000000&limit=100", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, "[]", w.Body.String())
+}
+
+func TestTerms(t *testing.T) {
+	assert.Equal(t, []string{"apple", "iphone", "15"}, Terms("Apple iPhone-15, apple"))
+	assert.Equal(t, []string{"creme", "brulee"}, Terms("Crème Brûlée"))
+}
+
+func TestHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/products/search", Handler(catalog()))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/products/search?q=sony", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var hits []Hit
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &hits))
+	assert.Equal(t, []string{"3"}, ids(hits))
+	assert.Equal(t, "499.99", hits[0].Price)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/products/search", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,99 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"rest/db"
+)
+
+// Candidates fetched from the database at a time
+const batchSize = 1000
+
+// Update replaces the indexed terms of a product, call it in the same
+// transaction that writes the name
+func Update(tx *db.Database, id, name string) error {
+	if err := tx.ExecQuery("DELETE FROM product_search_terms WHERE product_id = $1", id); err != nil {
+		return err
+	}
+
+	terms := Terms(name)
+	if len(terms) == 0 {
+		return nil
+	}
+	return tx.ExecQuery("INSERT INTO product_search_terms (term, product_id) SELECT unnest($1::STRING[]), $2", terms, id)
+}
+
+// DBSearcher finds candidates through the product_search_terms table, whose
+// primary key serves prefix matches and whose trigram index serves typos, and
+// ranks them with Rank. Candidates are read in batches in order of ID and
+// every one of them is ranked, so a page never depends on which candidates
+// the database happened to return first.
+type DBSearcher struct {
+	database *db.Database
+}
+
+func NewDBSearcher(database *db.Database) *DBSearcher {
+	return &DBSearcher{database}
+}
+
+func (s *DBSearcher) Search(ctx context.Context, query string, limit, offset int) ([]Hit, error) {
+	terms := Terms(query)
+	if len(terms) == 0 {
+		return []Hit{}, nil
+	}
+
+	var conditions []string
+	var values []any
+	for _, term := range terms {
+		runes := []rune(term)
+		if maxEdits(len(runes)) == 0 {
+			values = append(values, term+"%")
+			conditions = append(conditions, fmt.Sprintf("term LIKE $%d", len(values)))
+			continue
+		}
+
+		// a typo after the second letter is still found by the prefix, others by trigram similarity
+		values = append(values, string(runes[:2])+"%", term)
+		conditions = append(conditions, fmt.Sprintf("term LIKE $%d OR term %% $%d", len(values)-1, len(values)))
+	}
+	sql := fmt.Sprintf(
+		"SELECT id, name, price, quantity FROM products WHERE deleted_at IS NULL AND id IN (SELECT product_id FROM product_search_terms WHERE %s) AND id > $%d ORDER BY id LIMIT $%d",
+		strings.Join(conditions, " OR "), len(values)+1, len(values)+2,
+	)
+
+	database := s.database.WithContext(ctx)
+	tokens := tokenize(query)
+	var hits []Hit
+	after := ""
+	for {
+		candidates, err := s.candidates(database, sql, append(values, after, batchSize))
+		if err != nil {
+			return nil, err
+		}
+		hits = best(tokens, candidates, hits, offset+limit)
+		if len(candidates) < batchSize {
+			return page(hits, limit, offset), nil
+		}
+		after = candidates[len(candidates)-1].ID
+	}
+}
+
+func (s *DBSearcher) candidates(database *db.Database, sql string, values []any) ([]Document, error) {
+	rows, err := database.Query(sql, values...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var candidates []Document
+	for rows.Next() {
+		var doc Document
+		if err := rows.Scan(&doc.ID, &doc.Name, &doc.Price, &doc.Quantity); err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, doc)
+	}
+	return candidates, rows.Err()
+}
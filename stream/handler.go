@@ -0,0 +1,152 @@
+package stream
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"rest/catalog"
+	"rest/db"
+	"rest/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Clients that fail to take a write for this long are disconnected
+const writeTimeout = 10 * time.Second
+
+// DefaultHeartbeat is how often an idle stream gets a comment, which keeps
+// proxies from closing it
+const DefaultHeartbeat = 15 * time.Second
+
+// Handler handles GET /products/stream, sending product changes as
+// Server-Sent Events. ?product_id=a,b only streams those products and
+// ?category_id=<id> the products in that category or its subcategories.
+// A client reconnecting with Last-Event-ID gets the events it missed, or a
+// reset event when they are no longer buffered and it should reload.
+func Handler(hub *Hub, database *db.Database, heartbeat time.Duration) gin.HandlerFunc {
+	if heartbeat <= 0 {
+		heartbeat = DefaultHeartbeat
+	}
+
+	return func(c *gin.Context) {
+		database := database.WithContext(c.Request.Context())
+
+		var filter Filter
+		if ids := c.Query("product_id"); ids != "" {
+			filter.Products = map[string]bool{}
+			for _, id := range strings.Split(ids, ",") {
+				if id = strings.TrimSpace(id); id != "" {
+					filter.Products[id] = true
+				}
+			}
+		}
+		if category := c.Query("category_id"); category != "" {
+			exists, err := catalog.CategoryExists(database, category)
+			if err != nil {
+				utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+				return
+			}
+			if !exists {
+				utils.ErrorResponse(c, http.StatusNotFound, "Category not found")
+				return
+			}
+			filter.Category = category
+		}
+
+		lastID := c.GetHeader("Last-Event-ID")
+		sub, replay, ok := hub.Subscribe(filter, lastID)
+		defer hub.Unsubscribe(sub)
+
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		c.Header("X-Accel-Buffering", "no")
+		c.Status(http.StatusOK)
+
+		w := &writer{c: c, controller: http.NewResponseController(c.Writer)}
+		w.printf("retry: 3000\n\n")
+		if !ok {
+			w.printf("event: reset\ndata: {\"last_event_id\":%q}\n\n", lastID)
+		}
+		for _, e := range replay {
+			w.event(e)
+		}
+		w.flush()
+
+		ticker := time.NewTicker(heartbeat)
+		defer ticker.Stop()
+
+		for w.err == nil {
+			select {
+			case <-c.Request.Context().Done():
+				return
+			case e, open := <-sub.Events:
+				if !open {
+					// too slow to keep up, the client reconnects and replays
+					return
+				}
+				w.event(e)
+			case <-ticker.C:
+				w.printf(": heartbeat\n\n")
+			}
+			w.flush()
+		}
+	}
+}
+
+// CategoryAncestors looks up the categories above a category in the database,
+// for Hub.SetAncestors
+func CategoryAncestors(database *db.Database) Ancestors {
+	return func(ctx context.Context, category string) ([]string, error) {
+		rows, err := database.WithContext(ctx).Query(
+			"WITH RECURSIVE path (id, parent_id, depth) AS (SELECT id, parent_id, 0 FROM categories WHERE id = $1 "+
+				"UNION ALL SELECT c.id, c.parent_id, path.depth + 1 FROM categories c JOIN path ON c.id = path.parent_id) "+
+				"SELECT id::STRING FROM path ORDER BY depth",
+			category,
+		)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		var categories []string
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				return nil, err
+			}
+			categories = append(categories, id)
+		}
+		return categories, rows.Err()
+	}
+}
+
+// writer remembers the first failed write, every write gets a deadline so a
+// client that stopped reading cannot hold the handler forever
+type writer struct {
+	c          *gin.Context
+	controller *http.ResponseController
+	err        error
+}
+
+func (w *writer) printf(format string, args ...any) {
+	if w.err != nil {
+		return
+	}
+	// not every ResponseWriter supports deadlines, e.g. in tests
+	w.controller.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, w.err = fmt.Fprintf(w.c.Writer, format, args...)
+}
+
+func (w *writer) event(e Event) {
+	w.printf("id: %s\nevent: %s\ndata: %s\n\n", e.ID, e.Type, e.Data)
+}
+
+func (w *writer) flush() {
+	if w.err == nil {
+		w.c.Writer.Flush()
+	}
+}
@@ -0,0 +1,241 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"slices"
+	"sync"
+
+	"rest/outbox"
+)
+
+// Event is a product change as it is streamed, ID being the ID of its outbox
+// message
+type Event struct {
+	ID        string
+	Type      string
+	ProductID string
+	// the category of the product followed by the ones above it, empty when
+	// the product has no category
+	Categories []string
+	Data       json.RawMessage
+}
+
+// Filter picks the events a subscriber wants, a nil set or empty category
+// matches everything. Category matches the products in it or below it at the
+// time of the event.
+type Filter struct {
+	Products map[string]bool
+	Category string
+}
+
+func (f Filter) Match(e Event) bool {
+	if f.Products != nil && !f.Products[e.ProductID] {
+		return false
+	}
+	if f.Category != "" && !slices.Contains(e.Categories, f.Category) {
+		return false
+	}
+	return true
+}
+
+// Ancestors returns a category followed by the categories above it
+type Ancestors func(ctx context.Context, category string) ([]string, error)
+
+// Subscription receives the matching events published after it was made.
+// Events is closed when the subscriber fell too far behind and was dropped,
+// or when the hub was closed.
+type Subscription struct {
+	Events <-chan Event
+
+	events chan Event
+	filter Filter
+}
+
+// Hub fans product changes out to the connected clients and keeps the last
+// ones, so a client that reconnects can pick up where it left off. It is an
+// outbox publisher fed by a tail of its own, events only reach it once their
+// change was committed.
+type Hub struct {
+	ancestors   Ancestors
+	mu          sync.Mutex
+	replay      []Event
+	replaySize  int
+	seen        map[string]bool
+	subscribers map[*Subscription]bool
+	bufferSize  int
+	closed      bool
+}
+
+// NewHub keeps the last replay events and gives every subscriber a buffer of
+// bufferSize events before it is dropped
+func NewHub(replay, bufferSize int) *Hub {
+	return &Hub{
+		replaySize:  replay,
+		seen:        map[string]bool{},
+		subscribers: map[*Subscription]bool{},
+		bufferSize:  bufferSize,
+	}
+}
+
+// SetAncestors makes Publish look up the categories above the category of
+// every event, without it an event only matches the category of its product
+func (h *Hub) SetAncestors(ancestors Ancestors) {
+	h.ancestors = ancestors
+}
+
+func (h *Hub) Name() string { return "stream" }
+
+// Publish hands an outbox message to the subscribers, messages handed over
+// again are only sent once. The categories of the event are looked up as it
+// is published, so category filters follow categories that were created or
+// moved after a client subscribed.
+func (h *Hub) Publish(ctx context.Context, m outbox.Message) error {
+	var product struct {
+		CategoryID *string `json:"category_id"`
+	}
+	if err := json.Unmarshal(m.Payload, &product); err != nil {
+		return err
+	}
+
+	e := Event{ID: m.ID, Type: m.Type, ProductID: m.Key, Data: m.Payload}
+	if product.CategoryID != nil {
+		e.Categories = []string{*product.CategoryID}
+		if h.ancestors != nil {
+			categories, err := h.ancestors(ctx, *product.CategoryID)
+			if err != nil {
+				return err
+			}
+			if len(categories) > 0 {
+				e.Categories = categories
+			} else {
+				slog.Debug("streamed product has a category that no longer exists", "product", m.Key, "category", *product.CategoryID)
+			}
+		}
+	}
+	h.Send(e)
+	return nil
+}
+
+// Send records an event in the replay buffer and passes it to the matching
+// subscribers. Subscribers whose buffer is full are dropped rather than
+// holding everyone else up, they can reconnect and replay what they missed.
+func (h *Hub) Send(e Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.seen[e.ID] {
+		return
+	}
+	h.seen[e.ID] = true
+	h.replay = append(h.replay, e)
+	if len(h.replay) > h.replaySize {
+		delete(h.seen, h.replay[0].ID)
+		h.replay = h.replay[1:]
+	}
+
+	for s := range h.subscribers {
+		if !s.filter.Match(e) {
+			continue
+		}
+		select {
+		case s.events <- e:
+		default:
+			h.remove(s)
+		}
+	}
+}
+
+// Subscribe registers a subscriber and returns the matching events after
+// lastID from the replay buffer. ok is false when lastID is no longer in the
+// buffer, so the subscriber may have missed events.
+func (h *Hub) Subscribe(filter Filter, lastID string) (s *Subscription, replay []Event, ok bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	events := make(chan Event, h.bufferSize)
+	s = &Subscription{Events: events, events: events, filter: filter}
+	if h.closed {
+		close(events)
+		return s, nil, true
+	}
+	h.subscribers[s] = true
+
+	if lastID == "" {
+		return s, nil, true
+	}
+	for i, e := range h.replay {
+		if e.ID != lastID {
+			continue
+		}
+		for _, e := range h.replay[i+1:] {
+			if filter.Match(e) {
+				replay = append(replay, e)
+			}
+		}
+		return s, replay, true
+	}
+	return s, nil, false
+}
+
+// SetFilter changes the events a subscriber gets from now on
+func (h *Hub) SetFilter(s *Subscription, filter Filter) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s.filter = filter
+}
+
+// Unsubscribe removes a subscriber, it is safe to call after it was dropped
+func (h *Hub) Unsubscribe(s *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.remove(s)
+}
+
+func (h *Hub) remove(s *Subscription) {
+	if h.subscribers[s] {
+		delete(h.subscribers, s)
+		close(s.events)
+	}
+}
+
+// Reset forgets the replay buffer and drops every subscriber, for when events
+// may have been missed. Streams reconnect and get a reset event, as their last
+// event is no longer buffered, and WebSocket clients subscribe again.
+func (h *Hub) Reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.replay = nil
+	h.seen = map[string]bool{}
+	for s := range h.subscribers {
+		h.remove(s)
+	}
+}
+
+// Subscribers is the number of connected subscribers
+func (h *Hub) Subscribers() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subscribers)
+}
+
+// Close ends every subscription and turns new ones away, so the streams
+// finish when the server shuts down
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for s := range h.subscribers {
+		h.remove(s)
+	}
+}
+
+// Closed reports whether Close was called
+func (h *Hub) Closed() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.closed
+}
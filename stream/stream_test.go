@@ -0,0 +1,239 @@
+package stream
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"rest/db"
+	"rest/outbox"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func event(id, product string, categories ...string) Event {
+	return Event{ID: id, Type: "product.updated", ProductID: product, Categories: categories, Data: json.RawMessage(fmt.Sprintf(`{"id":%q}`, product))}
+}
+
+func ids(events []Event) []string {
+	out := []string{}
+	for _, e := range events {
+		out = append(out, e.ID)
+	}
+	return out
+}
+
+func TestFilter(t *testing.T) {
+	e := event("1", "p1", "c1", "root")
+	assert.True(t, Filter{}.Match(e))
+	assert.True(t, Filter{Products: map[string]bool{"p1": true}}.Match(e))
+	assert.False(t, Filter{Products: map[string]bool{"p2": true}}.Match(e))
+	assert.True(t, Filter{Category: "c1"}.Match(e))
+	assert.True(t, Filter{Category: "root"}.Match(e))
+	assert.False(t, Filter{Category: "c2"}.Match(e))
+	assert.False(t, Filter{Category: "c1"}.Match(event("2", "p1")))
+}
+
+func TestHubFanOut(t *testing.T) {
+	hub := NewHub(10, 10)
+	all, _, _ := hub.Subscribe(Filter{}, "")
+	one, _, _ := hub.Subscribe(Filter{Products: map[string]bool{"p2": true}}, "")
+
+	hub.Send(event("1", "p1"))
+	hub.Send(event("2", "p2"))
+	hub.Send(event("2", "p2")) // handed over again
+
+	assert.Equal(t, "1", (<-all.Events).ID)
+	assert.Equal(t, "2", (<-all.Events).ID)
+	assert.Equal(t, "2", (<-one.Events).ID)
+	assert.Empty(t, all.Events)
+	assert.Empty(t, one.Events)
+
+	hub.Unsubscribe(all)
+	hub.Unsubscribe(all)
+	assert.Equal(t, 1, hub.Subscribers())
+}
+
+func TestHubReplay(t *testing.T) {
+	hub := NewHub(3, 10)
+	for i := 1; i <= 5; i++ {
+		hub.Send(event(fmt.Sprint(i), fmt.Sprintf("p%d", i%2)))
+	}
+
+	_, replay, ok := hub.Subscribe(Filter{}, "3")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"4", "5"}, ids(replay))
+
+	_, replay, ok = hub.Subscribe(Filter{Products: map[string]bool{"p1": true}}, "3")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"5"}, ids(replay))
+
+	_, replay, ok = hub.Subscribe(Filter{}, "5")
+	assert.True(t, ok)
+	assert.Empty(t, replay)
+
+	// fell out of the buffer
+	_, _, ok = hub.Subscribe(Filter{}, "1")
+	assert.False(t, ok)
+}
+
+func TestHubDropsSlowSubscribers(t *testing.T) {
+	hub := NewHub(10, 2)
+	slow, _, _ := hub.Subscribe(Filter{}, "")
+
+	for i := 1; i <= 3; i++ {
+		hub.Send(event(fmt.Sprint(i), "p1"))
+	}
+
+	assert.Equal(t, "1", (<-slow.Events).ID)
+	assert.Equal(t, "2", (<-slow.Events).ID)
+	_, open := <-slow.Events
+	assert.False(t, open)
+	assert.Equal(t, 0, hub.Subscribers())
+
+	// it can pick up from the replay buffer
+	_, replay, ok := hub.Subscribe(Filter{}, "2")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"3"}, ids(replay))
+}
+
+func TestHubReset(t *testing.T) {
+	hub := NewHub(10, 10)
+	sub, _, _ := hub.Subscribe(Filter{}, "")
+	hub.Send(event("1", "p1"))
+	<-sub.Events
+
+	hub.Reset()
+	_, open := <-sub.Events
+	assert.False(t, open)
+	assert.Equal(t, 0, hub.Subscribers())
+
+	// a reconnecting client cannot replay and gets a reset event
+	_, _, ok := hub.Subscribe(Filter{}, "1")
+	assert.False(t, ok)
+}
+
+func TestHubPublish(t *testing.T) {
+	hub := NewHub(10, 10)
+	sub, _, _ := hub.Subscribe(Filter{Category: "c1"}, "")
+
+	require.NoError(t, hub.Publish(context.Background(), outbox.Message{ID: "1", Type: "product.created", Key: "p1", Payload: json.RawMessage(`{"id":"p1","category_id":"c1"}`)}))
+	require.NoError(t, hub.Publish(context.Background(), outbox.Message{ID: "2", Type: "product.created", Key: "p2", Payload: json.RawMessage(`{"id":"p2"}`)}))
+
+	e := <-sub.Events
+	assert.Equal(t, Event{ID: "1", Type: "product.created", ProductID: "p1", Categories: []string{"c1"}, Data: json.RawMessage(`{"id":"p1","category_id":"c1"}`)}, e)
+	assert.Empty(t, sub.Events)
+}
+
+func TestHubPublishFollowsCategories(t *testing.T) {
+	// c2 is placed under c1 after the client subscribed to c1
+	parents := map[string]string{}
+	hub := NewHub(10, 10)
+	hub.SetAncestors(func(ctx context.Context, category string) ([]string, error) {
+		path := []string{category}
+		for parents[category] != "" {
+			category = parents[category]
+			path = append(path, category)
+		}
+		return path, nil
+	})
+	sub, _, _ := hub.Subscribe(Filter{Category: "c1"}, "")
+
+	message := func(id string) outbox.Message {
+		return outbox.Message{ID: id, Type: "product.updated", Key: "p1", Payload: json.RawMessage(`{"id":"p1","category_id":"c2"}`)}
+	}
+	require.NoError(t, hub.Publish(context.Background(), message("1")))
+	assert.Empty(t, sub.Events)
+
+	parents["c2"] = "c1"
+	require.NoError(t, hub.Publish(context.Background(), message("2")))
+	e := <-sub.Events
+	assert.Equal(t, "2", e.ID)
+	assert.Equal(t, []string{"c2", "c1"}, e.Categories)
+}
+
+// Reads events off a stream until it has n of them, a comment counts as one
+func readEvents(t *testing.T, scanner *bufio.Scanner, n int) []map[string]string {
+	var events []map[string]string
+	current := map[string]string{}
+	for len(events) < n && scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			if len(current) > 0 {
+				events = append(events, current)
+				current = map[string]string{}
+			}
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			current["comment"] = line
+			continue
+		}
+		field, value, _ := strings.Cut(line, ": ")
+		current[field] = value
+	}
+	require.NoError(t, scanner.Err())
+	return events
+}
+
+func TestHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	hub := NewHub(10, 10)
+	hub.Send(event("1", "p1"))
+	hub.Send(event("2", "p2"))
+
+	r := gin.New()
+	r.GET("/products/stream", Handler(hub, &db.Database{}, 50*time.Millisecond))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/products/stream?product_id=p1,p3", nil)
+	require.NoError(t, err)
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	scanner := bufio.NewScanner(resp.Body)
+	assert.Equal(t, []map[string]string{{"retry": "3000"}}, readEvents(t, scanner, 1))
+
+	require.Eventually(t, func() bool { return hub.Subscribers() == 1 }, time.Second, 10*time.Millisecond)
+	hub.Send(event("3", "p2"))
+	hub.Send(event("4", "p3"))
+
+	assert.ElementsMatch(t, []map[string]string{
+		{"id": "4", "event": "product.updated", "data": `{"id":"p3"}`},
+		{"comment": ": heartbeat"},
+	}, readEvents(t, scanner, 2))
+}
+
+func TestHandlerReset(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	hub := NewHub(10, 10)
+
+	r := gin.New()
+	r.GET("/products/stream", Handler(hub, &db.Database{}, time.Minute))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/products/stream", nil)
+	require.NoError(t, err)
+	req.Header.Set("Last-Event-ID", "42")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, []map[string]string{
+		{"retry": "3000"},
+		{"event": "reset", "data": `{"last_event_id":"42"}`},
+	}, readEvents(t, bufio.NewScanner(resp.Body), 2))
+}
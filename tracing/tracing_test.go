@@ -0,0 +1,102 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"rest/logging"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type exportedSpan struct {
+	Name        string
+	SpanContext struct {
+		TraceID string
+		SpanID  string
+	}
+	Parent struct {
+		TraceID string
+		SpanID  string
+	}
+	Attributes []struct {
+		Key   string
+		Value struct{ Value any }
+	}
+}
+
+func TestRequestAndQuerySpans(t *testing.T) {
+	var out bytes.Buffer
+	shutdown, err := Setup(context.Background(), "stdout", &out)
+	require.NoError(t, err)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Middleware())
+	r.GET("/products/:id", func(c *gin.Context) {
+		// What pgx does around a query run with the request context
+		var tracer QueryTracer
+		ctx := tracer.TraceQueryStart(c.Request.Context(), nil, pgx.TraceQueryStartData{SQL: "SELECT name FROM products WHERE id = $1 AND name = 'secret'"})
+		tracer.TraceQueryEnd(ctx, nil, pgx.TraceQueryEndData{})
+
+		c.String(http.StatusOK, logging.TraceIDFrom(c))
+	})
+
+	traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest(http.MethodGet, "/products/1", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	require.NoError(t, shutdown(context.Background()))
+	assert.Equal(t, traceID, w.Body.String())
+
+	spans := map[string]exportedSpan{}
+	decoder := json.NewDecoder(&out)
+	for {
+		var span exportedSpan
+		if err := decoder.Decode(&span); err == io.EOF {
+			break
+		} else {
+			require.NoError(t, err)
+		}
+		spans[span.Name] = span
+	}
+
+	server, ok := spans["GET /products/:id"]
+	require.True(t, ok, "missing server span")
+	query, ok := spans["db SELECT"]
+	require.True(t, ok, "missing query span")
+
+	assert.Equal(t, traceID, server.SpanContext.TraceID)
+	assert.Equal(t, "00f067aa0ba902b7", server.Parent.SpanID)
+	assert.Equal(t, traceID, query.SpanContext.TraceID)
+	assert.Equal(t, server.SpanContext.SpanID, query.Parent.SpanID)
+
+	attributes := map[string]any{}
+	for _, attr := range query.Attributes {
+		attributes[attr.Key] = attr.Value.Value
+	}
+	assert.Equal(t, "SELECT name FROM products WHERE id = $1 AND name = ?", attributes["db.query.text"])
+	assert.Equal(t, "cockroachdb", attributes["db.system"])
+}
+
+func TestSanitize(t *testing.T) {
+	testCases := map[string]string{
+		"SELECT * FROM products WHERE id = $1":                             "SELECT * FROM products WHERE id = $1",
+		"UPDATE t SET last_used_at = now() - INTERVAL '1 minute' LIMIT 10": "UPDATE t SET last_used_at = now() - INTERVAL ? LIMIT ?",
+		"SELECT 'it''s', 3.14,\n\tv1 FROM t":                               "SELECT ?, ?, v1 FROM t",
+	}
+
+	for sql, expected := range testCases {
+		assert.Equal(t, expected, Sanitize(sql))
+	}
+}
@@ -5,9 +5,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"strconv"
+
+	"rest/logging"
+
+	"github.com/gin-gonic/gin"
 )
 
 type Product struct {
@@ -17,57 +22,108 @@ type Product struct {
 	Quantity string `json:"quantity"`
 }
 
+// Writes an error response that carries the request and trace IDs
+func ErrorResponse(c *gin.Context, status int, message string) {
+	body := gin.H{"error": message, "request_id": logging.RequestIDFrom(c)}
+	if traceID := logging.TraceIDFrom(c); traceID != "" {
+		body["trace_id"] = traceID
+	}
+	c.JSON(status, body)
+}
+
+// The highest page and limit a list serves. Larger values are read as these,
+// so the offset never overflows into a negative number.
+const (
+	MaxPage  = 100000
+	MaxLimit = 100
+)
+
+// Reads the page and limit query parameters, falling back to the first page of 10
+func Pagination(c *gin.Context) (limit, offset int) {
+	page, err := strconv.Atoi(c.Query("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	page = min(page, MaxPage)
+
+	limit, err = strconv.Atoi(c.Query("limit"))
+	if err != nil || limit < 1 {
+		limit = 10
+	}
+	limit = min(limit, MaxLimit)
+
+	return limit, (page - 1) * limit
+}
+
 func NewProduct(id, name, price, quantity string) Product {
 	return Product{id, name, price, quantity}
 }
 
 // Parses a json file and returns a list of Products
-func JsonToArray(path string) []Product {
+func JsonToArray(path string) ([]Product, error) {
 	file, err := os.Open(path)
 	if err != nil {
-		log.Fatalf("Failed to open file: %v", err)
+		return nil, fmt.Errorf("failed to open file: %w", err)
 	}
 	defer file.Close()
 
 	bytes, err := io.ReadAll(file)
 	if err != nil {
-		log.Fatalf("Failed to read file: %v", err)
+		return nil, fmt.Errorf("failed to read file: %w", err)
 	}
 
 	var products []Product
 
 	err = json.Unmarshal(bytes, &products)
 	if err != nil {
-		log.Fatalf("Failed to parse JSON: %v", err)
+		return nil, fmt.Errorf("failed to parse JSON: %w", err)
 	}
 
-	return products
+	return products, nil
 }
 
-func create(p Product) {
+func create(p Product, header http.Header) error {
 
 	url := "http://localhost:8888/products"
 
 	jsonData, err := json.Marshal(p)
 	if err != nil {
-		log.Fatalf("Error marshalling product to JSON: %v", err)
+		return fmt.Errorf("error marshalling product to JSON: %w", err)
 	}
 
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(jsonData))
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(jsonData))
 	if err != nil {
-		log.Fatalf("Error making POST request: %v", err)
+		return fmt.Errorf("error creating POST request: %w", err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error making POST request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusCreated {
-		fmt.Printf("POST request failed with status: %s\n", resp.Status)
+		slog.Warn("POST request failed", "product_id", p.ID, "status", resp.StatusCode)
 	}
+	return nil
 }
 
-func AddAllProductsToDB(path string) {
-	products := JsonToArray(path)
+// Posts every product in the file to the server, header carries the
+// credentials when authentication is enabled
+func AddAllProductsToDB(path string, header http.Header) error {
+	products, err := JsonToArray(path)
+	if err != nil {
+		return err
+	}
 
 	for _, p := range products {
-		create(p)
+		if err := create(p, header); err != nil {
+			return err
+		}
 	}
+	return nil
 }
@@ -0,0 +1,313 @@
+package webhooks
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"rest/audit"
+	"rest/db"
+	"rest/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// Subscription sends the events it lists to its URL, the secret is only
+// returned when the subscription is created
+type Subscription struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Active    bool      `json:"active"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type subscriptionInput struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Active *bool    `json:"active"`
+}
+
+// Delivery is one event sent to one subscription, with the outcome of its
+// last attempt
+type Delivery struct {
+	ID             string     `json:"id"`
+	SubscriptionID string     `json:"subscription_id"`
+	EventID        string     `json:"event_id"`
+	Event          string     `json:"event"`
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	NextAttemptAt  *time.Time `json:"next_attempt_at"`
+	LastStatus     *int       `json:"last_status"`
+	LastError      string     `json:"last_error"`
+	CreatedAt      time.Time  `json:"created_at"`
+	DeliveredAt    *time.Time `json:"delivered_at"`
+}
+
+const subscriptionColumns = "id::STRING, url, events, active, created_at, updated_at"
+
+const deliveryColumns = "d.id::STRING, d.subscription_id::STRING, d.event_id::STRING, e.type, d.status, d.attempts, " +
+	"CASE WHEN d.status = 'pending' THEN d.next_attempt_at END, d.last_status, d.last_error, d.created_at, d.delivered_at"
+
+func scanSubscription(row pgx.Row) (Subscription, error) {
+	var s Subscription
+	err := row.Scan(&s.ID, &s.URL, &s.Events, &s.Active, &s.CreatedAt, &s.UpdatedAt)
+	return s, err
+}
+
+func scanDelivery(row pgx.Row) (Delivery, error) {
+	var d Delivery
+	err := row.Scan(&d.ID, &d.SubscriptionID, &d.EventID, &d.Event, &d.Status, &d.Attempts, &d.NextAttemptAt, &d.LastStatus, &d.LastError, &d.CreatedAt, &d.DeliveredAt)
+	return d, err
+}
+
+func bindSubscription(c *gin.Context) (subscriptionInput, bool) {
+	var input subscriptionInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err.Error())
+		return input, false
+	}
+	input.URL = strings.TrimSpace(input.URL)
+	if err := validate(input.URL, input.Events); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err.Error())
+		return input, false
+	}
+	return input, true
+}
+
+func respondError(c *gin.Context, err error) {
+	if errors.Is(err, pgx.ErrNoRows) {
+		utils.ErrorResponse(c, http.StatusNotFound, "Webhook subscription not found")
+		return
+	}
+	utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+}
+
+// CreateSubscription handles POST /webhooks
+func CreateSubscription(database *db.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		database := database.WithContext(c.Request.Context())
+
+		input, ok := bindSubscription(c)
+		if !ok {
+			return
+		}
+		active := input.Active == nil || *input.Active
+
+		secret, err := newSecret()
+		if err != nil {
+			utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		s, err := scanSubscription(database.QueryRow(
+			"INSERT INTO webhook_subscriptions (url, secret, events, active) VALUES ($1, $2, $3, $4) RETURNING "+subscriptionColumns,
+			input.URL, secret, input.Events, active,
+		))
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		audit.Record(c, "webhook", s.ID, nil, s)
+		s.Secret = secret
+		c.JSON(http.StatusCreated, s)
+	}
+}
+
+// ListSubscriptions handles GET /webhooks, oldest first
+func ListSubscriptions(database *db.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		database := database.WithContext(c.Request.Context())
+
+		limit, offset := utils.Pagination(c)
+		rows, err := database.Query("SELECT "+subscriptionColumns+" FROM webhook_subscriptions ORDER BY created_at, id LIMIT $1 OFFSET $2", limit, offset)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		defer rows.Close()
+
+		subscriptions := []Subscription{}
+		for rows.Next() {
+			s, err := scanSubscription(rows)
+			if err != nil {
+				respondError(c, err)
+				return
+			}
+			subscriptions = append(subscriptions, s)
+		}
+
+		c.JSON(http.StatusOK, subscriptions)
+	}
+}
+
+// GetSubscription handles GET /webhooks/:id
+func GetSubscription(database *db.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		database := database.WithContext(c.Request.Context())
+
+		s, err := scanSubscription(database.QueryRow("SELECT "+subscriptionColumns+" FROM webhook_subscriptions WHERE id = $1", c.Param("id")))
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, s)
+	}
+}
+
+// UpdateSubscription handles PUT /webhooks/:id. Queued deliveries go to the
+// new URL, changed events only apply to later product changes.
+func UpdateSubscription(database *db.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		database := database.WithContext(c.Request.Context())
+
+		id := c.Param("id")
+		input, ok := bindSubscription(c)
+		if !ok {
+			return
+		}
+
+		var before, after Subscription
+		err := database.Transaction(func(tx *db.Database) error {
+			var err error
+			before, err = scanSubscription(tx.QueryRow("SELECT "+subscriptionColumns+" FROM webhook_subscriptions WHERE id = $1 FOR UPDATE", id))
+			if err != nil {
+				return err
+			}
+			active := before.Active
+			if input.Active != nil {
+				active = *input.Active
+			}
+			after, err = scanSubscription(tx.QueryRow(
+				"UPDATE webhook_subscriptions SET url = $1, events = $2, active = $3, updated_at = now() WHERE id = $4 RETURNING "+subscriptionColumns,
+				input.URL, input.Events, active, id,
+			))
+			return err
+		})
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		audit.Record(c, "webhook", id, before, after)
+		c.JSON(http.StatusOK, after)
+	}
+}
+
+// DeleteSubscription handles DELETE /webhooks/:id, dropping its queued
+// deliveries and their log
+func DeleteSubscription(database *db.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		database := database.WithContext(c.Request.Context())
+
+		id := c.Param("id")
+		s, err := scanSubscription(database.QueryRow("DELETE FROM webhook_subscriptions WHERE id = $1 RETURNING "+subscriptionColumns, id))
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		audit.Record(c, "webhook", id, s, nil)
+		c.JSON(http.StatusNoContent, nil)
+	}
+}
+
+// ListDeliveries handles GET /webhooks/:id/deliveries, newest first,
+// optionally filtered by status and event
+func ListDeliveries(database *db.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		database := database.WithContext(c.Request.Context())
+
+		id := c.Param("id")
+		var exists bool
+		if err := database.QueryRow("SELECT EXISTS (SELECT 1 FROM webhook_subscriptions WHERE id = $1)", id).Scan(&exists); err != nil {
+			respondError(c, err)
+			return
+		}
+		if !exists {
+			respondError(c, pgx.ErrNoRows)
+			return
+		}
+
+		conditions := []string{"d.subscription_id = $1"}
+		values := []any{id}
+		for _, filter := range []struct{ param, condition string }{
+			{"status", "d.status = $%d"},
+			{"event", "e.type = $%d"},
+		} {
+			if value := c.Query(filter.param); value != "" {
+				values = append(values, value)
+				conditions = append(conditions, fmt.Sprintf(filter.condition, len(values)))
+			}
+		}
+
+		limit, offset := utils.Pagination(c)
+		values = append(values, limit, offset)
+		rows, err := database.Query(
+			fmt.Sprintf("SELECT %s FROM webhook_deliveries d JOIN webhook_events e ON e.id = d.event_id WHERE %s ORDER BY d.created_at DESC, d.id LIMIT $%d OFFSET $%d",
+				deliveryColumns, strings.Join(conditions, " AND "), len(values)-1, len(values)),
+			values...,
+		)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		defer rows.Close()
+
+		deliveries := []Delivery{}
+		for rows.Next() {
+			d, err := scanDelivery(rows)
+			if err != nil {
+				respondError(c, err)
+				return
+			}
+			deliveries = append(deliveries, d)
+		}
+
+		c.JSON(http.StatusOK, deliveries)
+	}
+}
+
+// Redeliver handles POST /webhooks/:id/deliveries/:delivery_id/redeliver,
+// queueing the delivery again with a fresh set of attempts. It works for
+// dead deliveries as well as ones the receiver wants a second time.
+func Redeliver(database *db.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		database := database.WithContext(c.Request.Context())
+
+		id, deliveryID := c.Param("id"), c.Param("delivery_id")
+		var d Delivery
+		err := database.Transaction(func(tx *db.Database) error {
+			err := tx.ExecQuery(
+				"UPDATE webhook_deliveries SET status = 'pending', attempts = 0, next_attempt_at = now(), delivered_at = NULL WHERE id = $1 AND subscription_id = $2",
+				deliveryID, id,
+			)
+			if err != nil {
+				return err
+			}
+			d, err = scanDelivery(tx.QueryRow(
+				"SELECT "+deliveryColumns+" FROM webhook_deliveries d JOIN webhook_events e ON e.id = d.event_id WHERE d.id = $1 AND d.subscription_id = $2",
+				deliveryID, id,
+			))
+			return err
+		})
+		if errors.Is(err, pgx.ErrNoRows) {
+			utils.ErrorResponse(c, http.StatusNotFound, "Webhook delivery not found")
+			return
+		}
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		audit.Record(c, "webhook_delivery", deliveryID, nil, d)
+		c.JSON(http.StatusAccepted, d)
+	}
+}
@@ -0,0 +1,400 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"rest/db"
+	"rest/outbox"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Product lifecycle events a subscription can ask for
+const (
+	ProductCreated  = "product.created"
+	ProductUpdated  = "product.updated"
+	ProductDeleted  = "product.deleted"
+	ProductRestored = "product.restored"
+)
+
+var Events = []string{ProductCreated, ProductUpdated, ProductDeleted, ProductRestored}
+
+// Headers of every delivery
+const (
+	SignatureHeader = "Webhook-Signature"
+	EventHeader     = "Webhook-Event"
+	DeliveryHeader  = "Webhook-Delivery"
+)
+
+// Delivery states, dead deliveries ran out of attempts and are only sent
+// again by the redeliver endpoint
+const (
+	Pending   = "pending"
+	Delivered = "delivered"
+	Dead      = "dead"
+)
+
+// MaxAttempts is how often a delivery is tried before it is dead-lettered
+const MaxAttempts = 8
+
+// Event is the body of every delivery
+type Event struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// Publisher turns outbox messages into webhook events, so webhooks follow the
+// outbox instead of every change writing a queue of its own
+type Publisher struct {
+	database *db.Database
+}
+
+func NewPublisher(database *db.Database) *Publisher {
+	return &Publisher{database: database}
+}
+
+func (p *Publisher) Name() string { return "webhooks" }
+
+// Publish records the event of a message and queues a delivery to every
+// active subscription that asked for it. The relay may hand over a message
+// more than once, its event is only recorded the first time.
+func (p *Publisher) Publish(ctx context.Context, m outbox.Message) error {
+	if !slices.Contains(Events, m.Type) {
+		return nil
+	}
+
+	database := p.database.WithContext(ctx)
+	return database.Transaction(func(tx *db.Database) error {
+		var id string
+		err := tx.QueryRow(
+			"INSERT INTO webhook_events (message_id, type, data, created_at) VALUES ($1, $2, $3, $4) ON CONFLICT (message_id) DO NOTHING RETURNING id::STRING",
+			m.ID, m.Type, m.Payload, m.CreatedAt,
+		).Scan(&id)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return tx.ExecQuery(
+			"INSERT INTO webhook_deliveries (subscription_id, event_id) SELECT id, $1 FROM webhook_subscriptions WHERE active AND $2 = ANY(events)",
+			id, m.Type,
+		)
+	})
+}
+
+// Purge deletes deliveries that finished before the given time and the
+// events left without deliveries. Pending deliveries are kept however old.
+func Purge(database *db.Database, before time.Time) error {
+	return database.Transaction(func(tx *db.Database) error {
+		if err := tx.ExecQuery("DELETE FROM webhook_deliveries WHERE status <> 'pending' AND created_at < $1", before); err != nil {
+			return err
+		}
+		return tx.ExecQuery("DELETE FROM webhook_events WHERE created_at < $1 AND NOT EXISTS (SELECT 1 FROM webhook_deliveries d WHERE d.event_id = webhook_events.id)", before)
+	})
+}
+
+// Sign is the hex HMAC-SHA256 of the timestamp and body joined by a dot
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignatureValue is the Webhook-Signature header, t=<unix time>,v1=<signature>
+func SignatureValue(secret string, at time.Time, body []byte) string {
+	return fmt.Sprintf("t=%d,v1=%s", at.Unix(), Sign(secret, at.Unix(), body))
+}
+
+// Verify checks a Webhook-Signature header the way a receiver should,
+// rejecting signatures older than tolerance to stop replays
+func Verify(secret, header string, body []byte, now time.Time, tolerance time.Duration) error {
+	var timestamp int64
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp, _ = strconv.ParseInt(value, 10, 64)
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	if timestamp == 0 || len(signatures) == 0 {
+		return errors.New("malformed signature header")
+	}
+	if age := now.Sub(time.Unix(timestamp, 0)); age > tolerance || age < -tolerance {
+		return errors.New("signature timestamp is outside the tolerance")
+	}
+
+	expected := Sign(secret, timestamp, body)
+	for _, s := range signatures {
+		if hmac.Equal([]byte(s), []byte(expected)) {
+			return nil
+		}
+	}
+	return errors.New("signature does not match")
+}
+
+// Backoff is how long to wait before the next attempt after a failed one,
+// doubling from 30 seconds up to 6 hours
+func Backoff(attempts int) time.Duration {
+	wait := 30 * time.Second
+	for i := 1; i < attempts && wait < 6*time.Hour; i++ {
+		wait *= 2
+	}
+	return min(wait, 6*time.Hour)
+}
+
+// Subscriptions are signed with a random secret, shown only when created
+func newSecret() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+// ErrPrivateAddress is returned for webhook URLs that lead into the network
+// the server runs in, such as localhost or a cloud metadata endpoint
+var ErrPrivateAddress = errors.New("url must not point to a loopback, private or link-local address")
+
+// Ranges the net.IP methods do not cover: "this network" and carrier-grade NAT
+var reserved = []*net.IPNet{
+	{IP: net.IPv4(0, 0, 0, 0), Mask: net.CIDRMask(8, 32)},
+	{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)},
+}
+
+func private(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return true
+	}
+	for _, n := range reserved {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks the address the worker is about to connect to, after DNS resolved
+// it, so a host name cannot be pointed at the internal network once the
+// subscription was accepted
+func checkAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || private(ip) {
+		return ErrPrivateAddress
+	}
+	return nil
+}
+
+// Checks the URL and events of a subscription
+func validate(rawURL string, events []string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url must be an absolute http or https URL")
+	}
+	host := strings.ToLower(u.Hostname())
+	if ip := net.ParseIP(host); (ip != nil && private(ip)) || host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return ErrPrivateAddress
+	}
+	if len(events) == 0 {
+		return errors.New("at least one event is required")
+	}
+	for _, e := range events {
+		if !slices.Contains(Events, e) {
+			return fmt.Errorf("unknown event %q, use %s", e, strings.Join(Events, ", "))
+		}
+	}
+	return nil
+}
+
+// Worker sends queued deliveries and retries failed ones
+type Worker struct {
+	database *db.Database
+	client   *http.Client
+}
+
+// NewWorker sends deliveries with client. Without one, the worker refuses to
+// connect to private addresses and ignores proxy settings, which would hide
+// where a request really goes.
+func NewWorker(database *db.Database, client *http.Client) *Worker {
+	if client == nil {
+		dialer := &net.Dialer{Timeout: 5 * time.Second, Control: checkAddress}
+		client = &http.Client{Timeout: 10 * time.Second, Transport: &http.Transport{DialContext: dialer.DialContext}}
+	}
+	return &Worker{database: database, client: client}
+}
+
+// Run sends due deliveries every interval until ctx is done
+func (w *Worker) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := w.DeliverDue(ctx); err != nil {
+			slog.Error("failed to send webhooks", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+type job struct {
+	delivery string
+	attempts int
+	url      string
+	secret   string
+	event    Event
+}
+
+// DeliverDue sends the deliveries whose next attempt is due, returning how
+// many succeeded
+func (w *Worker) DeliverDue(ctx context.Context) (int, error) {
+	database := w.database.WithContext(ctx)
+
+	// claiming pushes the next attempt back, so another instance does not pick
+	// up the same deliveries while these are in flight
+	rows, err := database.Query(
+		"UPDATE webhook_deliveries SET next_attempt_at = now() + INTERVAL '5 minutes' WHERE id IN " +
+			"(SELECT id FROM webhook_deliveries WHERE status = 'pending' AND next_attempt_at <= now() ORDER BY next_attempt_at LIMIT 50) RETURNING id::STRING",
+	)
+	if err != nil {
+		return 0, err
+	}
+	var claimed []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		claimed = append(claimed, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(claimed) == 0 {
+		return 0, err
+	}
+
+	rows, err = database.Query(
+		"SELECT d.id::STRING, d.attempts, s.url, s.secret, e.id::STRING, e.type, e.created_at, e.data FROM webhook_deliveries d "+
+			"JOIN webhook_subscriptions s ON s.id = d.subscription_id JOIN webhook_events e ON e.id = d.event_id "+
+			"WHERE d.id = ANY($1::UUID[]) ORDER BY e.created_at, d.id",
+		claimed,
+	)
+	if err != nil {
+		return 0, err
+	}
+	var jobs []job
+	for rows.Next() {
+		var j job
+		if err := rows.Scan(&j.delivery, &j.attempts, &j.url, &j.secret, &j.event.ID, &j.event.Type, &j.event.CreatedAt, &j.event.Data); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		jobs = append(jobs, j)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	delivered := 0
+	for _, j := range jobs {
+		status, err := w.send(ctx, j)
+		if err == nil {
+			delivered++
+		} else {
+			slog.Warn("webhook delivery failed", "delivery", j.delivery, "url", j.url, "attempt", j.attempts+1, "error", err)
+		}
+		if err := record(database, j, status, err); err != nil {
+			return delivered, err
+		}
+	}
+	return delivered, nil
+}
+
+// Posts the event, any 2xx response counts as delivered
+func (w *Worker) send(ctx context.Context, j job) (int, error) {
+	body, err := json.Marshal(j.event)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, j.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, SignatureValue(j.secret, time.Now(), body))
+	req.Header.Set(EventHeader, j.event.Type)
+	req.Header.Set(DeliveryHeader, j.delivery)
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver responded with %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// Stores the outcome of an attempt, scheduling a retry or dead-lettering
+// the delivery when it failed. Without a response, status is 0 and the last
+// status is stored as NULL.
+func record(database *db.Database, j job, status int, sendErr error) error {
+	var lastStatus *int
+	if status != 0 {
+		lastStatus = &status
+	}
+
+	attempts := j.attempts + 1
+	if sendErr == nil {
+		return database.ExecQuery(
+			"UPDATE webhook_deliveries SET status = 'delivered', attempts = $1, last_status = $2, last_error = '', delivered_at = now() WHERE id = $3",
+			attempts, lastStatus, j.delivery,
+		)
+	}
+
+	state := Pending
+	if attempts >= MaxAttempts {
+		state = Dead
+	}
+	return database.ExecQuery(
+		"UPDATE webhook_deliveries SET status = $1, attempts = $2, last_status = $3, last_error = $4, next_attempt_at = now() + $5::INTERVAL WHERE id = $6",
+		state, attempts, lastStatus, sendErr.Error(), Backoff(attempts).String(), j.delivery,
+	)
+}
@@ -0,0 +1,168 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"rest/db"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	body := []byte(`{"id":"1"}`)
+	header := SignatureValue("secret", now, body)
+
+	require.NoError(t, Verify("secret", header, body, now.Add(time.Minute), 5*time.Minute))
+
+	testCases := []struct {
+		name   string
+		secret string
+		header string
+		body   []byte
+		now    time.Time
+	}{
+		{"wrong secret", "other", header, body, now},
+		{"tampered body", "secret", header, []byte(`{"id":"2"}`), now},
+		{"too old", "secret", header, body, now.Add(10 * time.Minute)},
+		{"malformed", "secret", "v1=abc", body, now},
+		{"empty", "secret", "", body, now},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Error(t, Verify(tc.secret, tc.header, tc.body, tc.now, 5*time.Minute))
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	assert.Equal(t, 30*time.Second, Backoff(1))
+	assert.Equal(t, time.Minute, Backoff(2))
+	assert.Equal(t, 4*time.Minute, Backoff(4))
+	assert.Equal(t, 6*time.Hour, Backoff(20))
+	assert.Equal(t, 6*time.Hour, Backoff(1000))
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, validate("https://example.com/hooks", []string{ProductCreated, ProductDeleted}))
+	assert.Error(t, validate("example.com/hooks", []string{ProductCreated}))
+	assert.Error(t, validate("ftp://example.com", []string{ProductCreated}))
+	assert.Error(t, validate("https://example.com", nil))
+	assert.Error(t, validate("https://example.com", []string{"order.created"}))
+
+	for _, private := range []string{
+		"http://127.0.0.1:8888/hooks",
+		"http://169.254.169.254/latest/meta-data",
+		"http://10.0.0.5/hooks",
+		"http://192.168.1.1/hooks",
+		"http://[::1]/hooks",
+		"http://[::ffff:127.0.0.1]/hooks",
+		"http://0.0.0.0/hooks",
+		"http://localhost/hooks",
+		"http://api.localhost/hooks",
+	} {
+		assert.ErrorIs(t, validate(private, []string{ProductCreated}), ErrPrivateAddress, private)
+	}
+}
+
+func TestCheckAddress(t *testing.T) {
+	assert.NoError(t, checkAddress("tcp4", "93.184.215.14:443", nil))
+	assert.NoError(t, checkAddress("tcp6", "[2606:2800:21f:cb07:6820:80da:af6b:8b2c]:443", nil))
+	assert.ErrorIs(t, checkAddress("tcp4", "127.0.0.1:80", nil), ErrPrivateAddress)
+	assert.ErrorIs(t, checkAddress("tcp4", "169.254.169.254:80", nil), ErrPrivateAddress)
+	assert.ErrorIs(t, checkAddress("tcp4", "100.64.0.1:80", nil), ErrPrivateAddress)
+	assert.ErrorIs(t, checkAddress("tcp6", "[fe80::1]:80", nil), ErrPrivateAddress)
+}
+
+func TestWorkerRefusesPrivateAddresses(t *testing.T) {
+	called := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer server.Close()
+
+	w := NewWorker(nil, nil)
+	_, err := w.send(context.Background(), job{delivery: "d1", url: server.URL, secret: "secret", event: Event{ID: "e1", Type: ProductUpdated}})
+	assert.ErrorIs(t, err, ErrPrivateAddress)
+	assert.False(t, called)
+}
+
+func TestNewSecret(t *testing.T) {
+	a, err := newSecret()
+	require.NoError(t, err)
+	b, err := newSecret()
+	require.NoError(t, err)
+	assert.NotEqual(t, a, b)
+	assert.Regexp(t, `^whsec_[0-9a-f]{48}$`, a)
+}
+
+func TestSend(t *testing.T) {
+	var received Event
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		assert.NoError(t, Verify("secret", r.Header.Get(SignatureHeader), body, time.Now(), time.Minute))
+		assert.Equal(t, ProductUpdated, r.Header.Get(EventHeader))
+		assert.Equal(t, "d1", r.Header.Get(DeliveryHeader))
+		require.NoError(t, json.Unmarshal(body, &received))
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	w := NewWorker(nil, server.Client())
+	j := job{
+		delivery: "d1",
+		url:      server.URL,
+		secret:   "secret",
+		event:    Event{ID: "e1", Type: ProductUpdated, CreatedAt: time.Unix(1700000000, 0).UTC(), Data: json.RawMessage(`{"id":"3"}`)},
+	}
+
+	code, err := w.send(context.Background(), j)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, j.event, received)
+
+	status = http.StatusInternalServerError
+	code, err = w.send(context.Background(), j)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusInternalServerError, code)
+}
+
+// execConn keeps the arguments of the last statement it ran
+type execConn struct {
+	db.Conn
+	args []any
+}
+
+func (c *execConn) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	c.args = args
+	return pgconn.CommandTag{}, nil
+}
+
+func TestRecordLastStatus(t *testing.T) {
+	conn := &execConn{}
+	database := db.NewDatabase("", "", "", "", "test", conn)
+	j := job{delivery: "d1", attempts: 1}
+
+	require.NoError(t, record(&database, j, 0, errors.New("connection refused")))
+	assert.Nil(t, conn.args[2])
+
+	require.NoError(t, record(&database, j, http.StatusBadGateway, errors.New("receiver responded with 502 Bad Gateway")))
+	status := http.StatusBadGateway
+	assert.Equal(t, &status, conn.args[2])
+
+	require.NoError(t, record(&database, j, http.StatusOK, nil))
+	status = http.StatusOK
+	assert.Equal(t, &status, conn.args[1])
+}
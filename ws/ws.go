@@ -0,0 +1,374 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"rest/auth"
+	"rest/stream"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// MaxSubscriptions is how many products one connection can follow
+	MaxSubscriptions = 1000
+	// IdleTimeout closes connections the client has not sent anything on,
+	// clients should ping well within it
+	IdleTimeout = 2 * time.Minute
+
+	writeTimeout   = 10 * time.Second
+	maxMessageSize = 64 << 10
+)
+
+// Messages clients send
+const (
+	Subscribe   = "subscribe"
+	Unsubscribe = "unsubscribe"
+	Ping        = "ping"
+)
+
+type request struct {
+	Type     string   `json:"type"`
+	ID       string   `json:"id,omitempty"`
+	Products []string `json:"products"`
+}
+
+// The reply to subscribe and unsubscribe carries every product the
+// connection follows afterwards
+type subscriptionReply struct {
+	Type     string   `json:"type"`
+	ID       string   `json:"id,omitempty"`
+	Products []string `json:"products"`
+}
+
+type reply struct {
+	Type   string `json:"type"`
+	ID     string `json:"id,omitempty"`
+	Error  string `json:"error,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type eventMessage struct {
+	Type      string          `json:"type"`
+	Event     string          `json:"event"`
+	EventID   string          `json:"event_id"`
+	ProductID string          `json:"product_id"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// Subprotocols carrying credentials, the credential follows the scheme in
+// Sec-WebSocket-Protocol, e.g. "bearer, <token>"
+const (
+	BearerProtocol = "bearer"
+	APIKeyProtocol = "apikey"
+)
+
+// Server runs the /ws connections. Product changes come from the same hub
+// as the change stream, closing the hub closes every connection.
+type Server struct {
+	hub     *stream.Hub
+	origins []*url.URL
+	proxies []netip.Prefix
+	conns   sync.WaitGroup
+}
+
+// NewServer accepts connections from pages on the given origins, such as
+// https://shop.example.com, besides those on the server itself. Origins that
+// cannot be parsed are left out.
+func NewServer(hub *stream.Hub, origins []string) *Server {
+	s := &Server{hub: hub}
+	for _, origin := range origins {
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			slog.Warn("ignored invalid WebSocket origin", "origin", origin)
+			continue
+		}
+		s.origins = append(s.origins, u)
+	}
+	return s
+}
+
+// TrustProxies believes the X-Forwarded-Proto header of requests from the
+// given IPs and CIDRs, such as 10.0.0.0/8, to tell the scheme of the server
+// a page must be on
+func (s *Server) TrustProxies(proxies []string) error {
+	s.proxies = nil
+	for _, proxy := range proxies {
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			addr, err := netip.ParseAddr(proxy)
+			if err != nil {
+				return fmt.Errorf("invalid proxy %q", proxy)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		s.proxies = append(s.proxies, prefix)
+	}
+	return nil
+}
+
+// Handler handles GET /ws, upgrading the request once the caller was
+// authenticated by the middleware before it
+func (s *Server) Handler() gin.HandlerFunc {
+	server := websocket.Server{Handler: s.serve, Handshake: s.handshake}
+
+	return func(c *gin.Context) {
+		s.conns.Add(1)
+		defer s.conns.Done()
+		server.ServeHTTP(c.Writer, c.Request)
+	}
+}
+
+// Wait blocks until every connection is closed or ctx is done
+func (s *Server) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.conns.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+var errOrigin = errors.New("origin not allowed")
+
+// The scheme the client used to reach the server, which is only taken from
+// X-Forwarded-Proto when a trusted proxy sent the request
+func (s *Server) scheme(req *http.Request) string {
+	if req.TLS != nil {
+		return "https"
+	}
+	proto, _, _ := strings.Cut(req.Header.Get("X-Forwarded-Proto"), ",")
+	if proto = strings.ToLower(strings.TrimSpace(proto)); proto == "" {
+		return "http"
+	}
+	if addr, err := netip.ParseAddrPort(req.RemoteAddr); err == nil {
+		ip := addr.Addr().Unmap()
+		if slices.ContainsFunc(s.proxies, func(p netip.Prefix) bool { return p.Contains(ip) }) {
+			return proto
+		}
+	}
+	return "http"
+}
+
+func sameOrigin(a, b *url.URL) bool {
+	return strings.EqualFold(a.Scheme, b.Scheme) && strings.EqualFold(a.Host, b.Host)
+}
+
+// Turns away pages on other sites, which would otherwise connect with the
+// credentials of the browser they run in. Clients that are not browsers send
+// no Origin. Only the credential scheme is echoed back as the protocol, never
+// the credential itself.
+func (s *Server) handshake(config *websocket.Config, req *http.Request) error {
+	if origin := req.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		if err != nil {
+			return errOrigin
+		}
+		self := &url.URL{Scheme: s.scheme(req), Host: req.Host}
+		if !sameOrigin(u, self) && !slices.ContainsFunc(s.origins, func(allowed *url.URL) bool {
+			return sameOrigin(u, allowed)
+		}) {
+			slog.Warn("refused WebSocket from another origin", "origin", origin)
+			return errOrigin
+		}
+		config.Origin = u
+	}
+
+	if scheme, _ := credential(config.Protocol); scheme != "" {
+		config.Protocol = []string{scheme}
+	} else {
+		config.Protocol = nil
+	}
+	return nil
+}
+
+// Splits the subprotocols of a request into a credential scheme and the
+// credential, both empty when there is none
+func credential(protocols []string) (scheme, value string) {
+	if len(protocols) != 2 {
+		return "", ""
+	}
+	switch scheme := strings.ToLower(protocols[0]); scheme {
+	case BearerProtocol, APIKeyProtocol:
+		return scheme, protocols[1]
+	}
+	return "", ""
+}
+
+// Credentials moves a credential sent as subprotocols, e.g. "bearer, <token>"
+// or "apikey, <key>", into the headers the authentication middleware reads.
+// Browsers cannot set other headers on a WebSocket and credentials in the
+// query string would end up in logs.
+func Credentials() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var protocols []string
+		for _, p := range strings.Split(c.GetHeader("Sec-WebSocket-Protocol"), ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				protocols = append(protocols, p)
+			}
+		}
+
+		switch scheme, value := credential(protocols); scheme {
+		case APIKeyProtocol:
+			if c.GetHeader(auth.Header) == "" {
+				c.Request.Header.Set(auth.Header, value)
+			}
+		case BearerProtocol:
+			if c.GetHeader("Authorization") == "" {
+				c.Request.Header.Set("Authorization", "Bearer "+value)
+			}
+		}
+		c.Next()
+	}
+}
+
+// connection is the state of one client, the products it follows
+type connection struct {
+	conn     *websocket.Conn
+	hub      *stream.Hub
+	sub      *stream.Subscription
+	products map[string]bool
+}
+
+func (s *Server) serve(conn *websocket.Conn) {
+	conn.MaxPayloadBytes = maxMessageSize
+
+	// nothing is followed until the client subscribes
+	sub, _, _ := s.hub.Subscribe(stream.Filter{Products: map[string]bool{}}, "")
+	defer s.hub.Unsubscribe(sub)
+
+	c := &connection{conn: conn, hub: s.hub, sub: sub, products: map[string]bool{}}
+	requests := make(chan request)
+	done := make(chan struct{})
+	defer close(done)
+	go c.read(requests, done)
+
+	for {
+		select {
+		case req, open := <-requests:
+			if !open {
+				conn.Close()
+				return
+			}
+			if err := c.send(c.handle(req)); err != nil {
+				conn.Close()
+				return
+			}
+		case e, open := <-sub.Events:
+			if !open {
+				c.close(s.hub.Closed())
+				return
+			}
+			err := c.send(eventMessage{Type: "event", Event: e.Type, EventID: e.ID, ProductID: e.ProductID, Data: e.Data})
+			if err != nil {
+				conn.Close()
+				return
+			}
+		}
+	}
+}
+
+// Reads requests until the client goes away or stays quiet too long. Messages
+// that cannot be decoded are answered with an error rather than dropping the
+// connection.
+func (c *connection) read(requests chan<- request, done <-chan struct{}) {
+	defer close(requests)
+
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(IdleTimeout))
+		var msg string
+		if err := websocket.Message.Receive(c.conn, &msg); err != nil {
+			return
+		}
+
+		var req request
+		if err := json.Unmarshal([]byte(msg), &req); err != nil {
+			if c.send(reply{Type: "error", Error: "invalid message: " + err.Error()}) != nil {
+				return
+			}
+			continue
+		}
+		select {
+		case requests <- req:
+		case <-done:
+			return
+		}
+	}
+}
+
+// Applies a request to the connection and returns the reply
+func (c *connection) handle(req request) any {
+	switch req.Type {
+	case Ping:
+		return reply{Type: "pong", ID: req.ID}
+	case Subscribe, Unsubscribe:
+		if len(req.Products) == 0 {
+			return reply{Type: "error", ID: req.ID, Error: "products must list at least one product ID"}
+		}
+
+		products := maps.Clone(c.products)
+		for _, id := range req.Products {
+			if req.Type == Subscribe {
+				products[id] = true
+			} else {
+				delete(products, id)
+			}
+		}
+		if len(products) > MaxSubscriptions {
+			return reply{Type: "error", ID: req.ID, Error: fmt.Sprintf("a connection can follow at most %d products", MaxSubscriptions)}
+		}
+
+		// the hub reads the filter while publishing, so it gets its own copy
+		c.products = products
+		c.hub.SetFilter(c.sub, stream.Filter{Products: maps.Clone(products)})
+
+		typ := "subscribed"
+		if req.Type == Unsubscribe {
+			typ = "unsubscribed"
+		}
+		followed := []string{}
+		for id := range products {
+			followed = append(followed, id)
+		}
+		slices.Sort(followed)
+		return subscriptionReply{Type: typ, ID: req.ID, Products: followed}
+	default:
+		return reply{Type: "error", ID: req.ID, Error: fmt.Sprintf("unknown message type %q, use subscribe, unsubscribe or ping", req.Type)}
+	}
+}
+
+func (c *connection) send(v any) error {
+	c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return websocket.JSON.Send(c.conn, v)
+}
+
+// Tells the client why the server ends the connection before closing it
+func (c *connection) close(shutdown bool) {
+	reason := "events may have been missed, reconnect and subscribe again"
+	if shutdown {
+		reason = "server is shutting down"
+	}
+	if err := c.send(reply{Type: "closing", Reason: reason}); err != nil {
+		slog.Debug("failed to say goodbye on a WebSocket", "error", err)
+	}
+	c.conn.Close()
+}
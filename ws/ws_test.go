@@ -0,0 +1,222 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"rest/auth"
+	"rest/stream"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
+)
+
+func serve(t *testing.T, s *Server) string {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/ws", s.Handler())
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func connect(t *testing.T, hub *stream.Hub) (*Server, *websocket.Conn) {
+	s := NewServer(hub, nil)
+	serverURL := serve(t, s)
+
+	conn, err := websocket.Dial("ws"+strings.TrimPrefix(serverURL, "http")+"/ws", "", serverURL)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return s, conn
+}
+
+// Sends a message and returns the next one the server sends
+func roundTrip(t *testing.T, conn *websocket.Conn, msg string) map[string]any {
+	require.NoError(t, websocket.Message.Send(conn, msg))
+	return receive(t, conn)
+}
+
+func receive(t *testing.T, conn *websocket.Conn) map[string]any {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var reply map[string]any
+	require.NoError(t, websocket.JSON.Receive(conn, &reply))
+	return reply
+}
+
+func TestProtocol(t *testing.T) {
+	hub := stream.NewHub(10, 10)
+	_, conn := connect(t, hub)
+
+	assert.Equal(t, map[string]any{"type": "pong", "id": "1"}, roundTrip(t, conn, `{"type": "ping", "id": "1"}`))
+	assert.Equal(t,
+		map[string]any{"type": "subscribed", "id": "2", "products": []any{"p1", "p2"}},
+		roundTrip(t, conn, `{"type": "subscribe", "id": "2", "products": ["p2", "p1"]}`),
+	)
+
+	hub.Send(stream.Event{ID: "10", Type: "product.updated", ProductID: "p3", Data: json.RawMessage(`{"id":"p3"}`)})
+	hub.Send(stream.Event{ID: "11", Type: "product.deleted", ProductID: "p1", Data: json.RawMessage(`{"id":"p1"}`)})
+	assert.Equal(t,
+		map[string]any{"type": "event", "event": "product.deleted", "event_id": "11", "product_id": "p1", "data": map[string]any{"id": "p1"}},
+		receive(t, conn),
+	)
+
+	assert.Equal(t,
+		map[string]any{"type": "unsubscribed", "products": []any{"p2"}},
+		roundTrip(t, conn, `{"type": "unsubscribe", "products": ["p1"]}`),
+	)
+	hub.Send(stream.Event{ID: "12", Type: "product.updated", ProductID: "p1", Data: json.RawMessage(`{"id":"p1"}`)})
+	hub.Send(stream.Event{ID: "13", Type: "product.updated", ProductID: "p2", Data: json.RawMessage(`{"id":"p2"}`)})
+	assert.Equal(t, "13", receive(t, conn)["event_id"])
+
+	assert.Equal(t, "error", roundTrip(t, conn, `{"type": "publish"}`)["type"])
+	assert.Equal(t, "error", roundTrip(t, conn, `not json`)["type"])
+	assert.Equal(t, "error", roundTrip(t, conn, `{"type": "subscribe", "products": []}`)["type"])
+}
+
+func TestSubscriptionLimit(t *testing.T) {
+	hub := stream.NewHub(10, 10)
+	sub, _, _ := hub.Subscribe(stream.Filter{}, "")
+	c := &connection{hub: hub, sub: sub, products: map[string]bool{}}
+
+	products := make([]string, MaxSubscriptions+1)
+	for i := range products {
+		products[i] = fmt.Sprint(i)
+	}
+
+	assert.Equal(t, reply{Type: "error", Error: "a connection can follow at most 1000 products"}, c.handle(request{Type: Subscribe, Products: products}))
+	assert.Empty(t, c.products)
+
+	c.handle(request{Type: Subscribe, Products: products[:MaxSubscriptions]})
+	assert.Len(t, c.products, MaxSubscriptions)
+}
+
+func TestShutdown(t *testing.T) {
+	hub := stream.NewHub(10, 10)
+	s, conn := connect(t, hub)
+	roundTrip(t, conn, `{"type": "ping"}`)
+
+	hub.Close()
+	assert.Equal(t, map[string]any{"type": "closing", "reason": "server is shutting down"}, receive(t, conn))
+
+	var msg string
+	assert.ErrorIs(t, websocket.Message.Receive(conn, &msg), io.EOF)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.NoError(t, s.Wait(ctx))
+}
+
+func TestOrigin(t *testing.T) {
+	hub := stream.NewHub(10, 10)
+	serverURL := serve(t, NewServer(hub, []string{"https://shop.example.com/"}))
+	socketURL := "ws" + strings.TrimPrefix(serverURL, "http") + "/ws"
+
+	testCases := []struct {
+		name    string
+		origin  string
+		allowed bool
+	}{
+		{"same host", serverURL, true},
+		{"same host on another scheme", "https" + strings.TrimPrefix(serverURL, "http"), false},
+		{"allowed", "https://shop.example.com", true},
+		{"other site", "https://evil.example.com", false},
+		{"allowed host on another scheme", "http://shop.example.com", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conn, err := websocket.Dial(socketURL, "", tc.origin)
+			if !tc.allowed {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			conn.Close()
+		})
+	}
+
+	// clients that are not browsers send no Origin
+	req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	assert.NoError(t, NewServer(hub, nil).handshake(&websocket.Config{}, req))
+}
+
+func TestOriginBehindProxy(t *testing.T) {
+	s := NewServer(stream.NewHub(10, 10), nil)
+	req := httptest.NewRequest(http.MethodGet, "http://shop.example.com/ws", nil)
+	req.RemoteAddr = "10.0.0.7:4321"
+	req.Header.Set("Origin", "https://shop.example.com")
+	req.Header.Set("X-Forwarded-Proto", "https")
+
+	// the header is only believed from a trusted proxy
+	assert.ErrorIs(t, s.handshake(&websocket.Config{}, req), errOrigin)
+
+	require.NoError(t, s.TrustProxies([]string{"10.0.0.0/8"}))
+	assert.NoError(t, s.handshake(&websocket.Config{}, req))
+
+	req.Header.Set("X-Forwarded-Proto", "http")
+	assert.ErrorIs(t, s.handshake(&websocket.Config{}, req), errOrigin)
+
+	assert.Error(t, s.TrustProxies([]string{"proxy.example.com"}))
+}
+
+func TestProtocolEchoesOnlyTheScheme(t *testing.T) {
+	_, conn := connect(t, stream.NewHub(10, 10))
+	assert.Empty(t, conn.Config().Protocol)
+
+	serverURL := serve(t, NewServer(stream.NewHub(10, 10), nil))
+	config, err := websocket.NewConfig("ws"+strings.TrimPrefix(serverURL, "http")+"/ws", serverURL)
+	require.NoError(t, err)
+	config.Protocol = []string{BearerProtocol, "secret-token"}
+	conn, err = websocket.DialConfig(config)
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, []string{BearerProtocol}, conn.Config().Protocol)
+}
+
+func TestCredentials(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/ws", Credentials(), func(c *gin.Context) {
+		c.String(http.StatusOK, "%s|%s", c.GetHeader(auth.Header), c.GetHeader("Authorization"))
+	})
+
+	testCases := []struct {
+		name     string
+		header   map[string]string
+		expected string
+	}{
+		{"api key", map[string]string{"Sec-WebSocket-Protocol": "apikey, secret"}, "secret|"},
+		{"access token", map[string]string{"Sec-WebSocket-Protocol": "bearer, token"}, "|Bearer token"},
+		{"headers win", map[string]string{"Sec-WebSocket-Protocol": "apikey, protocol", auth.Header: "header"}, "header|"},
+		{"other protocol", map[string]string{"Sec-WebSocket-Protocol": "chat"}, "|"},
+		{"scheme without credential", map[string]string{"Sec-WebSocket-Protocol": "bearer"}, "|"},
+		{"nothing", nil, "|"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+			for key, value := range tc.header {
+				req.Header.Set(key, value)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			assert.Equal(t, tc.expected, w.Body.String())
+		})
+	}
+
+	// credentials in the query string are ignored
+	req := httptest.NewRequest(http.MethodGet, "/ws?api_key=secret&access_token=token", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, "|", w.Body.String())
+}